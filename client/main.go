@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -20,27 +26,62 @@ type certificateSigningRequest struct {
 }
 
 type certificateSigningResponse struct {
-	Certificate []byte `json:"certificate"`
+	Certificate []byte   `json:"certificate"`
+	Chain       [][]byte `json:"chain"`
+}
+
+// generateKey generates a private key of the given type and its PEM encoding.
+func generateKey(keyType string) (crypto.Signer, *pem.Block, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa":
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rsaKey, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	case "ecdsa-p256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "ed25519":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
 }
 
 func main() {
-	privateKeyFilename := os.Args[1]
-	certificateFilename := os.Args[2]
+	keyType := flag.String("key-type", "rsa", "key type: rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521 or ed25519")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: %s [-key-type type] <private key file> <certificate file>", os.Args[0])
+	}
+	privateKeyFilename := flag.Arg(0)
+	certificateFilename := flag.Arg(1)
 
 	// create private key and save it
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, privateKeyBlock, err := generateKey(*keyType)
 	if err != nil {
-		log.Fatalf("failed to generate RSA key pair: %v", err)
+		log.Fatalf("failed to generate %s key pair: %v", *keyType, err)
 	}
 	privateKeyFile, err := os.Create(privateKeyFilename)
 	if err != nil {
 		log.Fatalf("failed to open %s for writing key: %v", privateKeyFilename, err)
 	}
 	defer privateKeyFile.Close()
-	privateKeyPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
+	privateKeyPem := pem.EncodeToMemory(privateKeyBlock)
 	if _, err := privateKeyFile.Write(privateKeyPem); err != nil {
 		log.Fatalf("failed to write privkey data to %s: %v", privateKeyFilename, err)
 	}
@@ -51,9 +92,8 @@ func main() {
 			Organization: []string{"Example, Inc."},
 			Country:      []string{"US"},
 		},
-		IPAddresses:        []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
-		DNSNames:           []string{"localhost"},
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:    []string{"localhost"},
 	}
 	csrBytesDER, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, privateKey)
 	if err != nil {
@@ -80,6 +120,12 @@ func main() {
 		Type:  "CERTIFICATE",
 		Bytes: response.Certificate,
 	})
+	for _, cert := range response.Chain {
+		pemCertData = append(pemCertData, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert,
+		})...)
+	}
 	certificateFile, err := os.Create(certificateFilename)
 	if err != nil {
 		log.Fatalf("Failed to open %s for writing csr: %v", certificateFilename, err)
@@ -2,81 +2,328 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/adrianosela/ca/src/acme"
 	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/config"
 	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/keys"
+	"github.com/adrianosela/ca/src/revocation"
+	"github.com/adrianosela/ca/src/scep"
 	"github.com/adrianosela/ca/src/service"
+	"github.com/adrianosela/ca/src/signer"
+	"github.com/adrianosela/ca/src/store"
 	"github.com/adrianosela/ca/src/template"
-	"github.com/adrianosela/kmssigner"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 )
 
 const (
-	kmsKeyId             = "alias/my-ca-certificate-key"
-	issuerCertificatePEM = `-----BEGIN CERTIFICATE-----
-MIIDYTCCAkmgAwIBAgIINgIDyGR/gtEwDQYJKoZIhvcNAQELBQAwPzELMAkGA1UE
-BhMCQ0ExGjAYBgNVBAoTEUFkcmlhbm8gU2VsYSBJbmMuMRQwEgYDVQQDEwthZHJp
-YW5vc2VsYTAeFw0yMzEwMTAxODQzMjVaFw0zMzEwMDcxODQ4MjVaMD8xCzAJBgNV
-BAYTAkNBMRowGAYDVQQKExFBZHJpYW5vIFNlbGEgSW5jLjEUMBIGA1UEAxMLYWRy
-aWFub3NlbGEwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQCqsDA4LGhE
-fjsTiD+4OY7aPPy4FbBb/yAUo3g+X5iUmEpelpIIxPjBjSPk4on6yXyRE4kzrshK
-OleAthHXZD/JtUdkL9cBM8EibGhi8yRLpTOEWeUUs1vFieTDk4e8JjYp8gNnlxkJ
-F9wJJoIN+0spIopv91cEjLLIqpVxYx5eaM7ozhYVWfC3OSczzR1b9Kl7pHjDoiyP
-A/BM3Buso7cI/+vcnsExPh5oKp0pMBpBDONJuluAOYso2Xn45mdTxy2xmlVIBVeI
-JwlexRzVIQhvFmhPKEf5bDrLOEGMtestdWT7OdO3URgE784ASE1pVoB56OehEqtR
-0iquH291K1G3AgMBAAGjYTBfMA4GA1UdDwEB/wQEAwIChDAdBgNVHSUEFjAUBggr
-BgEFBQcDAgYIKwYBBQUHAwEwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUO43u
-O6t0qWa6KOEiu2wo2bP6JkMwDQYJKoZIhvcNAQELBQADggEBAA6GxMHxX5O4b5qN
-NzhD8tY845lTr/46a2BdpvkzJcD/5lmvAidXEUnViAwpBNMdxDqkl+X9YLO5qoex
-feXbf2zlA2mLjYe6ZPUV+YKKidIV4cO7A8lA7nPzm7YyZUXk50ohjpLx5SsFdkha
-UHDjsgXRc0e506JuQhEfwQBkK9dC4O/rUTttC3pgmkAxWXumxzqEpUumUy/VmyHu
-aCif7n5lfVM1rU0bb4+4Y9uCfYVR2CTchDvap4i/E+iNbmb4/XzrWX9Oz3iDORr2
-JUdeyYWZMtwX2tezyQS894oemgHR1Up6mTxnoF12uncUjs2GetGucC0O5wQZAxYx
-dxaYKUk=
------END CERTIFICATE-----`
+	scepRACommonName = "SCEP Registration Authority"
+	// shutdownTimeout is how long in-flight requests are given to complete on shutdown.
+	shutdownTimeout = time.Second * 30
+	// failureAuditQueueSize is how many audit events of denied and failed requests are
+	// queued for delivery (in the background) before further ones are dropped.
+	failureAuditQueueSize = 1000
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:]))
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the service until it fails to serve or a shutdown signal is received. It
+// returns errors rather than exiting, such that stores and auditors are closed (e.g.
+// audit logs checkpointed) by its deferred calls on any exit.
+func run() error {
+	configFile := flag.String("config", "ca.yaml", "path to the configuration file")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit")
+	listenAddress := flag.String("listen-address", "", "address to listen on (overrides server.listen_address)")
+	issuerCertificateFile := flag.String("issuer-certificate", "", "path to the issuer certificate (overrides issuer.certificate_file)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	if *listenAddress != "" {
+		cfg.Server.ListenAddress = *listenAddress
+	}
+	if *issuerCertificateFile != "" {
+		cfg.Issuer.CertificateFile = *issuerCertificateFile
+	}
+	if err = cfg.Validate(); err != nil {
+		// every problem found is listed, one per line
+		return fmt.Errorf("invalid configuration:\n%v", err)
+	}
+	if *checkConfig {
+		log.Print("configuration OK")
+		return nil
+	}
+
 	ctx := context.Background()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS SDK config: %v", err)
+	}
+
+	issuerCertificate, err := issuer.LoadCertificate(cfg.Issuer.CertificateFile)
+	if err != nil {
+		return fmt.Errorf("failed to load issuer certificate: %v", err)
+	}
+
+	caSigner, err := signer.New(ctx, &cfg.Signer)
 	if err != nil {
-		log.Fatalf("failed to load AWS SDK config: %v", err)
+		return fmt.Errorf("failed to initialize %s signer: %v", cfg.Signer.Type, err)
+	}
+	if err = signer.VerifyPublicKey(caSigner, issuerCertificate); err != nil {
+		return fmt.Errorf("invalid signer: %v", err)
 	}
 
-	signer, err := kmssigner.NewSigner(cfg, kmsKeyId, types.SigningAlgorithmSpecRsassaPkcs1V15Sha256)
+	debianWeakKeys, err := keys.LoadDebianWeakKeys(cfg.Issuer.DebianWeakKeysFiles...)
 	if err != nil {
-		log.Fatalf("failed to initialize KMS signer: %v", err)
+		return fmt.Errorf("failed to load weak keys: %v", err)
+	}
+
+	issuerOpts := []issuer.Option{
+		issuer.WithSignatureAlgorithm(signer.SignatureAlgorithm(caSigner)),
+		issuer.WithKeyChecker(keys.NewChecker(keys.WithDebianWeakKeys(debianWeakKeys))),
+	}
+	if cfg.Issuer.ChainFile != "" {
+		chain, err := issuer.LoadChain(cfg.Issuer.ChainFile, issuerCertificate)
+		if err != nil {
+			return fmt.Errorf("failed to load issuer certificate chain: %v", err)
+		}
+		issuerOpts = append(issuerOpts, issuer.WithChain(chain...))
 	}
 
-	issuerCertificateDER, _ := pem.Decode([]byte(issuerCertificatePEM))
-	issuerCertificate, err := x509.ParseCertificate(issuerCertificateDER.Bytes)
+	certificates, err := store.NewFileStore(cfg.Storage.CertificatesFile)
 	if err != nil {
-		log.Fatalf("failed to parse x509 issuer certificate from PEM: %v", err)
+		return fmt.Errorf("failed to initialize certificate store: %v", err)
 	}
+	defer certificates.Close()
 
-	qldbAuditor, err := auditor.NewQLDBAuditor(cfg, "MyLedger", "AuditEvents")
+	intents, err := auditor.NewFileIntentJournal(cfg.Storage.IntentsFile)
 	if err != nil {
-		log.Fatalf("failed to initialize QLDB auditor: %v", err)
+		return fmt.Errorf("failed to initialize issuance intent journal: %v", err)
+	}
+	defer intents.Close()
+
+	aud, err := auditor.NewMultiAuditorFromConfig(awsCfg, cfg.Auditors...)
+	if err != nil {
+		return fmt.Errorf("failed to initialize auditors: %v", err)
+	}
+	defer aud.Close(ctx)
+	if backlog := aud.Backlog(); backlog > 0 {
+		log.Printf("delivering %d spooled audit events", backlog)
 	}
-	defer qldbAuditor.Close(ctx)
+	// unauthenticated clients can cause failure events at will, so they are delivered
+	// in the background (and dropped under load) rather than on the request path
+	failureAud := auditor.NewAsyncAuditor(aud, failureAuditQueueSize)
+	defer failureAud.Close(ctx)
 
-	svc := service.NewService(
-		issuer.New(
-			issuerCertificate,
-			signer,
-			template.New(time.Minute*5, time.Minute*5),
+	revocations, err := revocation.NewFileStore(cfg.Storage.RevocationsFile)
+	if err != nil {
+		return fmt.Errorf("failed to initialize revocation store: %v", err)
+	}
+
+	serialNumbers := template.WithSerialNumberGenerator(
+		template.NewUniqueSerialNumberGenerator(
+			template.NewRandomSerialNumberGenerator(),
+			certificates,
 		),
-		qldbAuditor,
 	)
 
-	if err = http.ListenAndServe(":80", svc.HTTPHandler()); err != nil {
-		log.Fatalf("failed to listen and serve http: %v", err)
+	profileOpts := []template.Option{serialNumbers}
+	if cfg.Policy != nil && cfg.Profiles.HasCAProfile() {
+		// subordinate CAs must not be able to issue names the policy does not allow
+		nameConstraints, err := cfg.Policy.NameConstraints()
+		if err != nil {
+			return fmt.Errorf("failed to derive name constraints from issuance policy: %v", err)
+		}
+		for _, widening := range cfg.Policy.NameConstraintsWidenings() {
+			log.Printf("WARNING: subordinate CA name constraints are wider than the issuance policy: %s", widening)
+		}
+		profileOpts = append(profileOpts, template.WithNameConstraints(nameConstraints))
+	}
+
+	profiles, err := template.NewRegistry(&cfg.Profiles, profileOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to initialize certificate profiles: %v", err)
+	}
+	for _, name := range profiles.Names() {
+		if notAllowed := cfg.Profiles.Profiles[name].ExtKeyUsagesNotAllowedBy(issuerCertificate); len(notAllowed) > 0 {
+			log.Printf("WARNING: certificates of profile %q will not verify: the issuer certificate does not allow the extended key usages %v", name, notAllowed)
+		}
+	}
+	defaultProfile, err := profiles.Resolve("")
+	if err != nil {
+		return fmt.Errorf("failed to resolve default certificate profile: %v", err)
+	}
+
+	clientAuthenticator, err := cfg.Auth.Clients.Authenticator()
+	if err != nil {
+		return fmt.Errorf("failed to initialize client authentication: %v", err)
+	}
+	if clientAuthenticator == nil {
+		log.Printf("WARNING: no client authentication configured, anyone can request certificates")
+	}
+	adminAuthenticator, err := cfg.Auth.Admins.Authenticator()
+	if err != nil {
+		return fmt.Errorf("failed to initialize admin authentication: %v", err)
+	}
+
+	iss := issuer.New(
+		issuerCertificate,
+		caSigner,
+		defaultProfile,
+		issuerOpts...,
+	)
+
+	ocspResponder, err := revocation.NewOCSPResponder(
+		iss,
+		revocations,
+		revocation.WithResponseValidity(cfg.Revocation.OCSPResponseValidity),
+		revocation.WithCacheTTL(cfg.Revocation.OCSPCacheTTL),
+		revocation.WithCacheSize(cfg.Revocation.OCSPCacheSize),
+		revocation.WithCertificateStore(certificates),
+		revocation.WithAuditor(aud),
+		revocation.WithDelegatedSigning(cfg.Revocation.OCSPDelegateLifespan),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to initialize OCSP responder: %v", err)
+	}
+
+	var crlBuilder *revocation.CRLBuilder
+	if issuerCertificate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		log.Printf("WARNING: issuer certificate does not have the cRLSign key usage, the CRL endpoint is disabled")
+	} else {
+		crlBuilder = revocation.NewCRLBuilder(
+			iss,
+			revocations,
+			revocation.WithNextUpdate(cfg.Revocation.CRLNextUpdate),
+			revocation.WithRegenerationInterval(cfg.Revocation.CRLRegenerateInterval),
+			revocation.WithNumberFile(cfg.Storage.CRLNumberFile),
+		)
+	}
+
+	svcOpts := []service.Option{
+		service.WithCertificateStore(certificates),
+		service.WithIntentJournal(intents),
+		service.WithFailureAuditor(failureAud),
+		service.WithProfiles(profiles),
+		service.WithRevocation(revocations, crlBuilder),
+		service.WithOCSPResponder(ocspResponder),
+		service.WithAuthenticator(clientAuthenticator),
+		service.WithAdminAuthenticator(adminAuthenticator),
+	}
+	if cfg.Policy != nil {
+		svcOpts = append(svcOpts, service.WithPolicy(cfg.Policy))
+	}
+
+	if cfg.ACME.Enabled {
+		acmeProfile, err := profiles.Resolve(cfg.ACME.Profile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve ACME certificate profile: %v", err)
+		}
+		acmeOpts := []acme.Option{
+			acme.WithCertificateStore(certificates),
+			acme.WithIntentJournal(intents),
+			acme.WithFailureAuditor(failureAud),
+			acme.WithTemplateBuilder(acmeProfile),
+		}
+		if cfg.Policy != nil {
+			acmeOpts = append(acmeOpts, acme.WithPolicy(cfg.Policy))
+		}
+		if cfg.ACME.BaseURL != "" {
+			acmeOpts = append(acmeOpts, acme.WithBaseURL(cfg.ACME.BaseURL))
+		}
+		externalAccountKeys, err := cfg.ACME.ExternalAccountKeyMap()
+		if err != nil {
+			return fmt.Errorf("invalid ACME external account keys: %v", err)
+		}
+		if len(externalAccountKeys) > 0 {
+			acmeOpts = append(acmeOpts, acme.WithExternalAccountKeys(externalAccountKeys))
+		} else {
+			log.Printf("WARNING: no ACME external account keys configured, anyone can create ACME accounts")
+		}
+		svcOpts = append(svcOpts, service.WithACME(acme.NewServer(iss, aud, acmeOpts...)))
+	}
+
+	if cfg.EST.Enabled {
+		svcOpts = append(svcOpts, service.WithEST())
+	}
+
+	if cfg.SCEP.Enabled {
+		scepChallenges, err := cfg.SCEP.ChallengeStore()
+		if err != nil {
+			return fmt.Errorf("failed to initialize SCEP challenge passwords: %v", err)
+		}
+		scepRA, err := scep.NewRegistrationAuthority(iss, scepRACommonName, cfg.SCEP.RALifespan)
+		if err != nil {
+			return fmt.Errorf("failed to initialize SCEP registration authority: %v", err)
+		}
+		scepOpts := []scep.Option{
+			scep.WithChallengeStore(scepChallenges),
+			scep.WithCertificateStore(certificates),
+			scep.WithIntentJournal(intents),
+			scep.WithFailureAuditor(failureAud),
+			scep.WithRevocationStore(revocations),
+		}
+		if cfg.Policy != nil {
+			scepOpts = append(scepOpts, scep.WithPolicy(cfg.Policy))
+		}
+		svcOpts = append(svcOpts, service.WithSCEP(scep.NewServer(iss, aud, scepRA, scepOpts...)))
+	}
+
+	svc := service.NewService(iss, aud, svcOpts...)
+	if _, err = svc.ReconcileIntents(); err != nil {
+		return fmt.Errorf("failed to reconcile issuance intents: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:    cfg.Server.ListenAddress,
+		Handler: svc.HTTPHandler(),
+	}
+	served := make(chan error, 1)
+	go func() {
+		if cfg.Server.TLS == nil {
+			served <- server.ListenAndServe()
+			return
+		}
+		// client certificates are verified by the service (see config.TLSConfig)
+		server.TLSConfig = &tls.Config{ClientAuth: tls.RequestClientCert}
+		served <- server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err = <-served:
+		return fmt.Errorf("failed to listen and serve http: %v", err)
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down http server gracefully: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,163 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultChallengeTimeout = time.Second * 10
+	maxHTTP01ResponseSize   = 1 << 10
+)
+
+// ChallengeValidator represents an entity capable of validating
+// an ACME challenge of a given type for a domain name.
+type ChallengeValidator interface {
+	Validate(ctx context.Context, domain, token, keyAuthorization string) error
+}
+
+// HTTP01Validator is an http-01 (RFC 8555 section 8.3)
+// implementation of the ChallengeValidator interface.
+type HTTP01Validator struct {
+	client *http.Client
+	port   int
+}
+
+// ensure HTTP01Validator implements ChallengeValidator.
+var _ ChallengeValidator = (*HTTP01Validator)(nil)
+
+// NewHTTP01Validator returns an http-01 implementation of the ChallengeValidator interface.
+// The port is only meant to be overridden (from 80) for testing against local servers. The
+// default client does not follow redirects, and refuses to connect to non-public addresses
+// (checked when connecting, such that names resolving to them are refused too).
+func NewHTTP01Validator(client *http.Client, port int) *HTTP01Validator {
+	if client == nil {
+		dialer := &net.Dialer{Timeout: defaultChallengeTimeout, Control: refuseNonPublicAddress}
+		client = &http.Client{
+			Timeout:   defaultChallengeTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if port == 0 {
+		port = 80
+	}
+	return &HTTP01Validator{client: client, port: port}
+}
+
+// Validate validates an http-01 challenge.
+func (v *HTTP01Validator) Validate(ctx context.Context, domain, token, keyAuthorization string) error {
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", net.JoinHostPort(domain, strconv.Itoa(v.port)), token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build http-01 request: %v", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned status code %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTP01ResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %v", url, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return fmt.Errorf("response from %s does not match the key authorization", url)
+	}
+	return nil
+}
+
+// nonPublicPrefixes are the (IPv4) address blocks which are neither loopback, private,
+// link-local, multicast nor unspecified, yet are not publicly routable either.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// refuseNonPublicAddress is a net.Dialer control function refusing
+// connections to loopback, private, link-local or reserved addresses.
+func refuseNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", host, err)
+	}
+	if !isPublicAddress(addr.Unmap()) {
+		return fmt.Errorf("refusing to connect to non-public address %s", addr)
+	}
+	return nil
+}
+
+func isPublicAddress(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// TXTResolver represents an entity capable of resolving DNS TXT records.
+// Note that *net.Resolver implements TXTResolver.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DNS01Validator is a dns-01 (RFC 8555 section 8.4)
+// implementation of the ChallengeValidator interface.
+type DNS01Validator struct {
+	resolver TXTResolver
+}
+
+// ensure DNS01Validator implements ChallengeValidator.
+var _ ChallengeValidator = (*DNS01Validator)(nil)
+
+// NewDNS01Validator returns a dns-01 implementation of the ChallengeValidator interface.
+func NewDNS01Validator(resolver TXTResolver) *DNS01Validator {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &DNS01Validator{resolver: resolver}
+}
+
+// Validate validates a dns-01 challenge.
+func (v *DNS01Validator) Validate(ctx context.Context, domain, token, keyAuthorization string) error {
+	name := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+	records, err := v.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to look up TXT records for %s: %v", name, err)
+	}
+	hash := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(hash[:])
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record for %s matches the key authorization", name)
+}
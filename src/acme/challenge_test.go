@@ -0,0 +1,63 @@
+package acme
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTP01ValidatorRefusesNonPublicAddresses(t *testing.T) {
+	// a server on a loopback address, which must never be reached
+	var reached atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Store(true)
+		io.WriteString(w, "token.thumbprint")
+	}))
+	defer server.Close()
+	port := netip.MustParseAddrPort(strings.TrimPrefix(server.URL, "http://")).Port()
+
+	validator := NewHTTP01Validator(nil, int(port))
+	for _, domain := range []string{"127.0.0.1", "localhost"} {
+		err := validator.Validate(context.Background(), domain, "token", "token.thumbprint")
+		if err == nil || !strings.Contains(err.Error(), "non-public address") {
+			t.Fatalf("expected validation against %s to be refused, got: %v", domain, err)
+		}
+	}
+	if reached.Load() {
+		t.Fatal("expected the loopback server not to be reached")
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34", public: true},
+		{address: "2606:2800:220:1:248:1893:25c8:1946", public: true},
+		{address: "127.0.0.1"},
+		{address: "::1"},
+		{address: "10.1.2.3"},
+		{address: "172.16.0.1"},
+		{address: "192.168.1.1"},
+		{address: "169.254.169.254"},
+		{address: "fe80::1"},
+		{address: "fc00::1"},
+		{address: "100.64.0.1"},
+		{address: "0.0.0.0"},
+		{address: "224.0.0.1"},
+		{address: "240.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			if public := isPublicAddress(netip.MustParseAddr(test.address)); public != test.public {
+				t.Fatalf("expected public: %t, got: %t", test.public, public)
+			}
+		})
+	}
+}
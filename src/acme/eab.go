@@ -0,0 +1,63 @@
+package acme
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+)
+
+// externalAccountBindingAlgorithms are the MAC algorithms accepted for external account bindings.
+var externalAccountBindingAlgorithms = map[string]bool{
+	string(jose.HS256): true,
+	string(jose.HS384): true,
+	string(jose.HS512): true,
+}
+
+// verifyExternalAccountBinding verifies the external account binding (RFC 8555 section 7.3.4)
+// of a new-account request with the given account key, and returns the external account key id.
+func (s *Server) verifyExternalAccountBinding(c *gin.Context, binding json.RawMessage, accountKey *jose.JSONWebKey) (string, *problem) {
+	if len(binding) == 0 {
+		return "", newProblem(http.StatusForbidden, "externalAccountRequired", "new accounts require an external account binding")
+	}
+	jws, err := jose.ParseSigned(string(binding))
+	if err != nil {
+		return "", malformed("invalid external account binding: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return "", malformed("external account binding must have exactly one signature")
+	}
+	header := jws.Signatures[0].Protected
+	if !externalAccountBindingAlgorithms[header.Algorithm] {
+		return "", malformed("unsupported external account binding algorithm %q", header.Algorithm)
+	}
+	if header.Nonce != "" {
+		return "", malformed("external account binding must not have a nonce")
+	}
+	if url, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); url != s.url(c, "/new-account") {
+		return "", unauthorized("external account binding url header %q does not match request url", url)
+	}
+	key, ok := s.externalAccountKeys[header.KeyID]
+	if !ok {
+		return "", unauthorized("unknown external account key id %q", header.KeyID)
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return "", unauthorized("external account binding signature verification failed")
+	}
+
+	var boundKey jose.JSONWebKey
+	if err = json.Unmarshal(payload, &boundKey); err != nil {
+		return "", malformed("invalid external account binding payload: %v", err)
+	}
+	boundThumbprint, err := jwkThumbprint(&boundKey)
+	if err != nil {
+		return "", malformed("invalid external account binding payload: %v", err)
+	}
+	accountThumbprint, err := jwkThumbprint(accountKey)
+	if err != nil || boundThumbprint != accountThumbprint {
+		return "", unauthorized("external account binding is not for the account key")
+	}
+	return header.KeyID, nil
+}
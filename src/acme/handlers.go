@@ -0,0 +1,802 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+)
+
+const challengeValidationTimeout = time.Second * 30
+
+type newAccountPayload struct {
+	Contact                []string        `json:"contact"`
+	TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+	OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding"`
+}
+
+type accountUpdatePayload struct {
+	Contact []string `json:"contact"`
+	Status  string   `json:"status"`
+}
+
+type newOrderPayload struct {
+	Identifiers []identifier `json:"identifiers"`
+}
+
+type finalizePayload struct {
+	CSR string `json:"csr"`
+}
+
+func (s *Server) accountJSON(c *gin.Context, acct *account) gin.H {
+	return gin.H{
+		"status":  acct.status,
+		"contact": acct.contact,
+		"orders":  s.url(c, "/account/"+acct.id+"/orders"),
+	}
+}
+
+// orderJSON must be called with the lock held.
+func (s *Server) orderJSON(c *gin.Context, o *order) gin.H {
+	authorizations := []string{}
+	for _, id := range o.authorizationIDs {
+		authorizations = append(authorizations, s.url(c, "/authz/"+id))
+	}
+	resp := gin.H{
+		"status":         o.status,
+		"expires":        o.expires.Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authorizations,
+		"finalize":       s.url(c, "/order/"+o.id+"/finalize"),
+	}
+	if o.certificateID != "" {
+		resp["certificate"] = s.url(c, "/cert/"+o.certificateID)
+	}
+	if o.err != nil {
+		resp["error"] = o.err
+	}
+	return resp
+}
+
+// authorizationJSON must be called with the lock held.
+func (s *Server) authorizationJSON(c *gin.Context, authz *authorization) gin.H {
+	challenges := []gin.H{}
+	for _, id := range authz.challengeIDs {
+		challenges = append(challenges, s.challengeJSON(c, s.challenges[id]))
+	}
+	resp := gin.H{
+		"identifier": authz.identifier,
+		"status":     authz.status,
+		"expires":    authz.expires.Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if authz.wildcard {
+		resp["wildcard"] = true
+	}
+	return resp
+}
+
+func (s *Server) challengeJSON(c *gin.Context, chal *challenge) gin.H {
+	resp := gin.H{
+		"type":   chal.typ,
+		"url":    s.url(c, "/challenge/"+chal.id),
+		"status": chal.status,
+		"token":  chal.token,
+	}
+	if !chal.validated.IsZero() {
+		resp["validated"] = chal.validated.Format(time.RFC3339)
+	}
+	if chal.err != nil {
+		resp["error"] = chal.err
+	}
+	return resp
+}
+
+func (s *Server) newAccountHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/new-account", true)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+	var payload newAccountPayload
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.fail(c, malformed("invalid new-account payload: %v", err))
+		return
+	}
+	thumbprint, err := jwkThumbprint(req.jwk)
+	if err != nil {
+		s.fail(c, malformed("failed to compute jwk thumbprint: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	if id, ok := s.accountsByThumbprint[thumbprint]; ok {
+		acct := s.accounts[id]
+		s.mu.Unlock()
+		c.Header("Location", s.url(c, "/account/"+acct.id))
+		s.respond(c, http.StatusOK, s.accountJSON(c, acct))
+		return
+	}
+	if payload.OnlyReturnExisting {
+		s.mu.Unlock()
+		s.fail(c, newProblem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key"))
+		return
+	}
+
+	externalAccountID := ""
+	if len(s.externalAccountKeys) > 0 {
+		if externalAccountID, prob = s.verifyExternalAccountBinding(c, payload.ExternalAccountBinding, req.jwk); prob != nil {
+			s.mu.Unlock()
+			s.auditAuthenticationFailure(c, prob)
+			s.fail(c, prob)
+			return
+		}
+	}
+
+	acct := &account{
+		id:                newID(),
+		key:               req.jwk,
+		thumbprint:        thumbprint,
+		status:            statusValid,
+		contact:           payload.Contact,
+		lastSeen:          time.Now(),
+		externalAccountID: externalAccountID,
+	}
+	s.accounts[acct.id] = acct
+	s.accountsByThumbprint[thumbprint] = acct.id
+	s.mu.Unlock()
+
+	c.Header("Location", s.url(c, "/account/"+acct.id))
+	s.respond(c, http.StatusCreated, s.accountJSON(c, acct))
+}
+
+func (s *Server) accountHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/account/"+c.Param("id"), false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+	if req.account.id != c.Param("id") {
+		s.fail(c, unauthorized("account key does not match account"))
+		return
+	}
+
+	s.mu.Lock()
+	if len(req.payload) > 0 {
+		var payload accountUpdatePayload
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			s.mu.Unlock()
+			s.fail(c, malformed("invalid account update payload: %v", err))
+			return
+		}
+		if payload.Contact != nil {
+			req.account.contact = payload.Contact
+		}
+		if payload.Status == statusDeactivated {
+			req.account.status = statusDeactivated
+		}
+	}
+	resp := s.accountJSON(c, req.account)
+	s.mu.Unlock()
+
+	s.respond(c, http.StatusOK, resp)
+}
+
+func (s *Server) accountOrdersHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/account/"+c.Param("id")+"/orders", false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+	if req.account.id != c.Param("id") {
+		s.fail(c, unauthorized("account key does not match account"))
+		return
+	}
+
+	s.mu.Lock()
+	orders := []string{}
+	for _, id := range req.account.orderIDs {
+		if o := s.orders[id]; o.status == statusPending || o.status == statusReady || o.status == statusProcessing {
+			orders = append(orders, s.url(c, "/order/"+id))
+		}
+	}
+	s.mu.Unlock()
+
+	s.respond(c, http.StatusOK, gin.H{"orders": orders})
+}
+
+func (s *Server) newOrderHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/new-order", false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+	var payload newOrderPayload
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.fail(c, malformed("invalid new-order payload: %v", err))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.fail(c, malformed("order must have at least one identifier"))
+		return
+	}
+
+	identifiers := []identifier{}
+	seen := map[string]bool{}
+	for _, id := range payload.Identifiers {
+		if id.Type != "dns" {
+			s.fail(c, newProblem(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported", id.Type))
+			return
+		}
+		value := strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		if err := checkDNSIdentifier(strings.TrimPrefix(value, "*.")); err != nil {
+			s.fail(c, rejectedIdentifier("invalid dns identifier %q: %v", id.Value, err))
+			return
+		}
+		if !seen[value] {
+			seen[value] = true
+			identifiers = append(identifiers, identifier{Type: "dns", Value: value})
+		}
+	}
+
+	expires := time.Now().Add(s.orderLifetime).UTC()
+	o := &order{
+		id:          newID(),
+		accountID:   req.account.id,
+		status:      statusPending,
+		expires:     expires,
+		identifiers: identifiers,
+	}
+
+	s.mu.Lock()
+	for _, id := range identifiers {
+		authz := &authorization{
+			id:         newID(),
+			accountID:  req.account.id,
+			identifier: id,
+			status:     statusPending,
+			expires:    expires,
+		}
+		challengeTypes := []string{ChallengeHTTP01, ChallengeDNS01}
+		if strings.HasPrefix(id.Value, "*.") {
+			// wildcards can only be validated via dns-01 (RFC 8555 section 7.1.3)
+			authz.wildcard = true
+			authz.identifier.Value = strings.TrimPrefix(id.Value, "*.")
+			challengeTypes = []string{ChallengeDNS01}
+		}
+		for _, typ := range challengeTypes {
+			if _, ok := s.validators[typ]; !ok {
+				continue
+			}
+			chal := &challenge{
+				id:              newID(),
+				authorizationID: authz.id,
+				typ:             typ,
+				status:          statusPending,
+				token:           newID(),
+			}
+			s.challenges[chal.id] = chal
+			authz.challengeIDs = append(authz.challengeIDs, chal.id)
+		}
+		s.authorizations[authz.id] = authz
+		o.authorizationIDs = append(o.authorizationIDs, authz.id)
+	}
+	s.orders[o.id] = o
+	req.account.orderIDs = append(req.account.orderIDs, o.id)
+	resp := s.orderJSON(c, o)
+	s.mu.Unlock()
+
+	c.Header("Location", s.url(c, "/order/"+o.id))
+	s.respond(c, http.StatusCreated, resp)
+}
+
+func (s *Server) orderHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/order/"+c.Param("id"), false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.orders[c.Param("id")]
+	if !ok || o.accountID != req.account.id {
+		s.mu.Unlock()
+		s.fail(c, notFound("order does not exist"))
+		return
+	}
+	s.refreshOrderStatus(o)
+	resp := s.orderJSON(c, o)
+	s.mu.Unlock()
+
+	s.respond(c, http.StatusOK, resp)
+}
+
+func (s *Server) authorizationHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/authz/"+c.Param("id"), false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+
+	s.mu.Lock()
+	authz, ok := s.authorizations[c.Param("id")]
+	if !ok || authz.accountID != req.account.id {
+		s.mu.Unlock()
+		s.fail(c, notFound("authorization does not exist"))
+		return
+	}
+	if authz.status == statusPending && time.Now().After(authz.expires) {
+		authz.status = "expired"
+	}
+	resp := s.authorizationJSON(c, authz)
+	s.mu.Unlock()
+
+	s.respond(c, http.StatusOK, resp)
+}
+
+func (s *Server) challengeHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/challenge/"+c.Param("id"), false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+
+	s.mu.Lock()
+	chal, ok := s.challenges[c.Param("id")]
+	if !ok {
+		s.mu.Unlock()
+		s.fail(c, notFound("challenge does not exist"))
+		return
+	}
+	authz := s.authorizations[chal.authorizationID]
+	if authz.accountID != req.account.id {
+		s.mu.Unlock()
+		s.fail(c, notFound("challenge does not exist"))
+		return
+	}
+	// an empty payload is a POST-as-GET, anything else is a request to validate the challenge
+	if len(req.payload) == 0 || chal.status != statusPending || authz.status != statusPending {
+		resp := s.challengeJSON(c, chal)
+		s.mu.Unlock()
+		c.Header("Link", "<"+s.url(c, "/authz/"+authz.id)+">;rel=\"up\"")
+		s.respond(c, http.StatusOK, resp)
+		return
+	}
+	if time.Now().After(authz.expires) {
+		authz.status = "expired"
+		s.mu.Unlock()
+		s.fail(c, unauthorized("authorization has expired"))
+		return
+	}
+	chal.status = statusProcessing
+	validator := s.validators[chal.typ]
+	domain := authz.identifier.Value
+	keyAuth := keyAuthorization(chal.token, req.account.thumbprint)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), challengeValidationTimeout)
+	err := validator.Validate(ctx, domain, chal.token, keyAuth)
+	cancel()
+
+	s.mu.Lock()
+	if err != nil {
+		chal.status = statusInvalid
+		// the cause is not disclosed, since it describes what the CA could reach
+		log.Printf("acme %s validation of %s failed: %v", chal.typ, domain, err)
+		chal.err = unauthorized("%s validation failed", chal.typ)
+		authz.status = statusInvalid
+	} else {
+		chal.status = statusValid
+		chal.validated = time.Now().UTC()
+		authz.status = statusValid
+	}
+	resp := s.challengeJSON(c, chal)
+	s.mu.Unlock()
+
+	c.Header("Link", "<"+s.url(c, "/authz/"+authz.id)+">;rel=\"up\"")
+	s.respond(c, http.StatusOK, resp)
+}
+
+// refreshOrderStatus derives the status of a pending order from its
+// authorizations (RFC 8555 section 7.1.6). It must be called with the lock held.
+func (s *Server) refreshOrderStatus(o *order) {
+	if o.status != statusPending {
+		return
+	}
+	if time.Now().After(o.expires) {
+		o.status = statusInvalid
+		o.err = unauthorized("order has expired")
+		return
+	}
+	for _, id := range o.authorizationIDs {
+		switch s.authorizations[id].status {
+		case statusValid:
+		case statusPending:
+			return
+		default:
+			o.status = statusInvalid
+			o.err = unauthorized("authorization for %s failed", s.authorizations[id].identifier.Value)
+			return
+		}
+	}
+	o.status = statusReady
+}
+
+func (s *Server) finalizeHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/order/"+c.Param("id")+"/finalize", false)
+	if prob != nil {
+		s.failIssuance(c, newIssuanceAttempt(c, s.auditClient(c, nil)), failureCategory(prob), prob)
+		return
+	}
+	attempt := newIssuanceAttempt(c, s.auditClient(c, req.account))
+	var payload finalizePayload
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, malformed("invalid finalize payload: %v", err))
+		return
+	}
+	csrDER, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, badCSR("csr is not base64url encoded"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, badCSR("invalid csr: %v", err))
+		return
+	}
+	if err = csr.CheckSignature(); err != nil {
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, badCSR("invalid csr signature: %v", err))
+		return
+	}
+	attempt.csr = csr
+
+	s.mu.Lock()
+	o, ok := s.orders[c.Param("id")]
+	if !ok || o.accountID != req.account.id {
+		s.mu.Unlock()
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, notFound("order does not exist"))
+		return
+	}
+	s.refreshOrderStatus(o)
+	if o.status != statusReady {
+		s.mu.Unlock()
+		s.failIssuance(c, attempt, auditor.FailureCategoryUnauthorized, orderNotReady("order is %s", o.status))
+		return
+	}
+	if prob = checkCSRIdentifiers(csr, o.identifiers); prob != nil {
+		s.mu.Unlock()
+		s.failIssuance(c, attempt, auditor.FailureCategoryInvalidRequest, prob)
+		return
+	}
+	o.status = statusProcessing
+	s.mu.Unlock()
+
+	chainPEM, prob := s.issue(attempt)
+
+	s.mu.Lock()
+	if prob != nil {
+		o.status = statusInvalid
+		o.err = prob
+		s.mu.Unlock()
+		s.fail(c, prob)
+		return
+	}
+	cert := &certificate{id: newID(), accountID: req.account.id, chainPEM: chainPEM}
+	s.issued[cert.id] = cert
+	o.certificateID = cert.id
+	o.status = statusValid
+	resp := s.orderJSON(c, o)
+	s.mu.Unlock()
+
+	c.Header("Location", s.url(c, "/order/"+o.id))
+	s.respond(c, http.StatusOK, resp)
+}
+
+// issuanceAttempt represents the state of a finalized order's issuance, as far as it got.
+type issuanceAttempt struct {
+	client  auditor.Client
+	request auditor.Request
+	csr     *x509.CertificateRequest
+	certDER []byte
+	intent  *auditor.Event
+}
+
+func newIssuanceAttempt(c *gin.Context, client auditor.Client) issuanceAttempt {
+	return issuanceAttempt{
+		client:  client,
+		request: auditor.Request{Method: c.Request.Method, Path: c.Request.URL.Path},
+	}
+}
+
+// issue issues, records and audits a certificate for a finalized order, returning the PEM chain.
+// Failures are audited, and the problem returned must be sent to the client.
+func (s *Server) issue(attempt issuanceAttempt) ([]byte, *problem) {
+	csr := attempt.csr
+	if err := s.iss.CheckPublicKey(csr.PublicKey); err != nil {
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryRejectedKey, badPublicKey("%v", err))
+	}
+
+	if s.policy != nil {
+		if err := s.policy.Evaluate(csr); err != nil {
+			var denial *policy.DenialError
+			if errors.As(err, &denial) {
+				return nil, s.auditFailure(attempt, auditor.FailureCategoryPolicyDenied, rejectedIdentifier("%s", denial.Error()))
+			}
+			log.Printf("failed to evaluate issuance policy for ACME order: %v", err)
+			return nil, s.auditFailure(attempt, auditor.FailureCategoryPolicyError, serverInternal("failed to evaluate issuance policy"))
+		}
+	}
+
+	chain, err := s.iss.Chain()
+	if err != nil {
+		log.Printf("failed to retrieve issuer certificate chain: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategorySigningError, serverInternal("failed to retrieve issuer certificate chain"))
+	}
+
+	templateBuilder := s.templateBuilder
+	if templateBuilder == nil {
+		templateBuilder = s.iss.TemplateBuilder()
+	}
+	certTemplate, templateBuilder, err := template.Prebuild(templateBuilder, csr)
+	if err != nil {
+		log.Printf("failed to build certificate template for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryTemplateError, serverInternal("failed to issue certificate"))
+	}
+
+	// two-phase issuance, see service.ReconcileIntents
+	intent, err := auditor.NewIssuanceIntentEvent(attempt.client, csr, certTemplate, auditor.HTTPRequest{})
+	if err != nil {
+		log.Printf("failed to build issuance intent audit event for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryAuditError, serverInternal("failed to build audit event"))
+	}
+	if s.intents != nil {
+		if err = s.intents.Begin(intent); err != nil {
+			log.Printf("failed to record issuance intent for ACME order: %v", err)
+			return nil, s.auditFailure(attempt, auditor.FailureCategoryStorageError, serverInternal("failed to record issuance intent"))
+		}
+	}
+	attempt.intent = intent
+	if err = s.auditor.Audit(intent); err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		log.Printf("failed to emit issuance intent audit event for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryAuditError, serverInternal("failed to emit audit event"))
+	}
+
+	issueCertStart := time.Now()
+	certDER, err := s.iss.IssueCertificateWithBuilder(csr, templateBuilder)
+	if err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		log.Printf("failed to issue certificate for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategorySigningError, serverInternal("failed to issue certificate"))
+	}
+	issueCertDuration := time.Now().Sub(issueCertStart)
+	attempt.certDER = certDER
+
+	// from here on failures leave the intent pending, to be flagged on startup
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		log.Printf("failed to parse certificate issued for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategorySigningError, serverInternal("failed to issue certificate"))
+	}
+
+	if s.certificates != nil {
+		record, err := store.NewRecord(cert)
+		if err == nil {
+			err = s.certificates.Put(record)
+		}
+		if err != nil {
+			log.Printf("failed to record certificate issued for ACME order: %v", err)
+			return nil, s.auditFailure(attempt, auditor.FailureCategoryStorageError, serverInternal("failed to record issued certificate"))
+		}
+	}
+
+	event, err := auditor.NewIssuanceEvent(
+		attempt.client,
+		csr,
+		certDER,
+		auditor.HTTPRequest{IssueCertificateDuration: issueCertDuration.Milliseconds()},
+	)
+	if err != nil {
+		log.Printf("failed to build audit event for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryAuditError, serverInternal("failed to build audit event"))
+	}
+	event.IntentID = intent.EventID
+	if err = s.auditor.Audit(event); err != nil {
+		log.Printf("failed to emit audit event for ACME order: %v", err)
+		return nil, s.auditFailure(attempt, auditor.FailureCategoryAuditError, serverInternal("failed to emit audit event"))
+	}
+	s.resolveIntent(intent, auditor.ResolutionCompleted)
+
+	chainPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	for _, der := range chain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return chainPEM, nil
+}
+
+// failIssuance audits the failure of a finalize request (see auditFailure) and sends the problem.
+func (s *Server) failIssuance(c *gin.Context, attempt issuanceAttempt, category string, p *problem) {
+	s.fail(c, s.auditFailure(attempt, category, p))
+}
+
+// auditFailure emits an auditor.EventTypeIssuanceFailed event for a finalize request, and returns
+// the problem. Failures to audit it are only logged, since the request is being aborted either way.
+func (s *Server) auditFailure(attempt issuanceAttempt, category string, p *problem) *problem {
+	event := auditor.NewIssuanceFailedEvent(
+		attempt.client,
+		attempt.csr,
+		attempt.certDER,
+		auditor.HTTPRequest{},
+		attempt.request,
+		auditor.Failure{Category: category, StatusCode: p.Status, Detail: p.Detail},
+	)
+	if attempt.intent != nil {
+		event.IntentID = attempt.intent.EventID
+	}
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit issuance failure audit event for ACME order (%s): %v", category, err)
+	}
+	return p
+}
+
+// auditAuthenticationFailure emits an auditor.EventTypeAuthenticationFailed event for a request
+// which was denied because of its (lack of) external account binding. Failures are only logged.
+func (s *Server) auditAuthenticationFailure(c *gin.Context, p *problem) {
+	event := auditor.NewAuthenticationFailedEvent(
+		s.auditClient(c, nil),
+		auditor.Request{Method: c.Request.Method, Path: c.Request.URL.Path},
+		auditor.Failure{Category: auditor.FailureCategoryUnauthorized, StatusCode: p.Status, Detail: p.Detail},
+	)
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit authentication failure audit event for ACME account: %v", err)
+	}
+}
+
+// auditClient returns the audit description of the client of a request by an account, if known.
+func (s *Server) auditClient(c *gin.Context, acct *account) auditor.Client {
+	client := auditor.Client{
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		AuthMethod: "acme",
+	}
+	if acct != nil {
+		client.Principal = accountPrincipal(s.url(c, "/account/"+acct.id), acct)
+	}
+	return client
+}
+
+// failureCategory returns the audit failure category of a problem with a request's JWS.
+func failureCategory(p *problem) string {
+	if p.Status == http.StatusUnauthorized || p.Status == http.StatusForbidden {
+		return auditor.FailureCategoryUnauthorized
+	}
+	return auditor.FailureCategoryInvalidRequest
+}
+
+// resolveIntent records the resolution of an issuance intent, if intents are journaled.
+// Failures are only logged: an intent left pending is flagged on startup.
+func (s *Server) resolveIntent(intent *auditor.Event, resolution auditor.Resolution) {
+	if s.intents == nil {
+		return
+	}
+	if err := s.intents.Resolve(intent.EventID, resolution); err != nil {
+		log.Printf("failed to resolve issuance intent %s as %s: %v", intent.EventID, resolution, err)
+	}
+}
+
+// checkCSRIdentifiers checks that a CSR requests exactly the (DNS) identifiers of an order.
+func checkCSRIdentifiers(csr *x509.CertificateRequest, identifiers []identifier) *problem {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return badCSR("csr may only request dns names")
+	}
+
+	requested := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" && !requested[strings.ToLower(cn)] {
+		return badCSR("csr common name %q is not one of the csr's dns names", cn)
+	}
+
+	ordered := map[string]bool{}
+	for _, id := range identifiers {
+		ordered[id.Value] = true
+	}
+
+	if len(requested) != len(ordered) {
+		return badCSR("csr dns names do not match order identifiers")
+	}
+	for name := range requested {
+		if !ordered[name] {
+			return badCSR("csr dns name %q is not an order identifier", name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) certificateHandler(c *gin.Context) {
+	req, prob := s.verify(c, "/cert/"+c.Param("id"), false)
+	if prob != nil {
+		s.fail(c, prob)
+		return
+	}
+
+	s.mu.Lock()
+	cert, ok := s.issued[c.Param("id")]
+	s.mu.Unlock()
+	if !ok || cert.accountID != req.account.id {
+		s.fail(c, notFound("certificate does not exist"))
+		return
+	}
+
+	s.mu.Lock()
+	nonce := s.newNonce()
+	s.mu.Unlock()
+	c.Header("Replay-Nonce", nonce)
+	c.Header("Link", "<"+s.url(c, "/directory")+">;rel=\"index\"")
+	c.Data(http.StatusOK, "application/pem-certificate-chain", cert.chainPEM)
+}
+
+// reservedTLDs are the top-level domains of names which are never publicly resolvable.
+var reservedTLDs = map[string]bool{
+	"localhost":   true,
+	"local":       true,
+	"localdomain": true,
+	"internal":    true,
+	"invalid":     true,
+	"arpa":        true,
+}
+
+// checkDNSIdentifier returns an error unless a (lowercase, non-wildcard)
+// dns identifier is a fully qualified domain name under a public TLD.
+func checkDNSIdentifier(name string) error {
+	if net.ParseIP(name) != nil {
+		return errors.New("ip addresses are not supported")
+	}
+	if len(name) > 253 {
+		return errors.New("name is too long")
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return errors.New("name must be fully qualified")
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("invalid label %q", label)
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return fmt.Errorf("invalid label %q", label)
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	if reservedTLDs[tld] || strings.Trim(tld, "0123456789") == "" {
+		return fmt.Errorf("top-level domain %q is not public", tld)
+	}
+	return nil
+}
+
+// accountPrincipal returns the audited principal of an account: its
+// external account key id if it is bound to one, or its URL otherwise.
+func accountPrincipal(accountURL string, acct *account) string {
+	if acct.externalAccountID != "" {
+		return acct.externalAccountID
+	}
+	return accountURL
+}
@@ -0,0 +1,128 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+)
+
+const maxJWSSize = 1 << 16
+
+var supportedAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.EdDSA): true,
+}
+
+// verifiedRequest represents the verified contents of a JWS-signed ACME request.
+type verifiedRequest struct {
+	payload []byte
+	// account is set for requests identifying the account by key id
+	account *account
+	// jwk is set for requests carrying the account key (i.e. new-account)
+	jwk *jose.JSONWebKey
+}
+
+// verify verifies a JWS-signed ACME request (RFC 8555 section 6.2) addressed to the given path.
+// Requests must carry a jwk header if useJWK is true and a kid header of a valid account otherwise.
+func (s *Server) verify(c *gin.Context, path string, useJWK bool) (*verifiedRequest, *problem) {
+	if c.ContentType() != "application/jose+json" {
+		return nil, malformed("content type must be application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxJWSSize))
+	if err != nil {
+		return nil, malformed("failed to read request body: %v", err)
+	}
+
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, malformed("invalid JWS: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, malformed("JWS must have exactly one signature")
+	}
+	sig := jws.Signatures[0]
+	unprotected := sig.Unprotected
+	if unprotected.KeyID != "" || unprotected.JSONWebKey != nil || unprotected.Algorithm != "" ||
+		unprotected.Nonce != "" || len(unprotected.ExtraHeaders) > 0 {
+		return nil, malformed("JWS must not have unprotected headers")
+	}
+	header := sig.Protected
+
+	if !supportedAlgorithms[header.Algorithm] {
+		return nil, newProblem(http.StatusBadRequest, "badSignatureAlgorithm", "unsupported JWS algorithm %q", header.Algorithm)
+	}
+	if url, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); url != s.url(c, path) {
+		return nil, unauthorized("JWS url header %q does not match request url", url)
+	}
+
+	s.mu.Lock()
+	validNonce := s.consumeNonce(header.Nonce)
+	s.mu.Unlock()
+	if !validNonce {
+		return nil, badNonce("invalid or expired nonce")
+	}
+
+	req := &verifiedRequest{}
+	var key interface{}
+	if useJWK {
+		if header.JSONWebKey == nil || header.KeyID != "" {
+			return nil, malformed("JWS must have a jwk header and no kid header")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, malformed("JWS jwk header is not a valid public key")
+		}
+		req.jwk = header.JSONWebKey
+		key = header.JSONWebKey
+	} else {
+		if header.KeyID == "" || header.JSONWebKey != nil {
+			return nil, malformed("JWS must have a kid header and no jwk header")
+		}
+		s.mu.Lock()
+		acct, ok := s.accounts[s.accountIDFromKeyID(c, header.KeyID)]
+		if ok {
+			acct.lastSeen = time.Now()
+		}
+		s.mu.Unlock()
+		if !ok {
+			return nil, newProblem(http.StatusBadRequest, "accountDoesNotExist", "account %q does not exist", header.KeyID)
+		}
+		if acct.status != statusValid {
+			return nil, unauthorized("account is %s", acct.status)
+		}
+		req.account = acct
+		key = acct.key
+	}
+
+	if req.payload, err = jws.Verify(key); err != nil {
+		return nil, malformed("JWS signature verification failed")
+	}
+	return req, nil
+}
+
+func (s *Server) accountIDFromKeyID(c *gin.Context, keyID string) string {
+	prefix := s.url(c, "/account/")
+	if len(keyID) <= len(prefix) || keyID[:len(prefix)] != prefix {
+		return ""
+	}
+	return keyID[len(prefix):]
+}
+
+// keyAuthorization returns the key authorization (RFC 8555 section 8.1) for a token.
+func keyAuthorization(token string, thumbprint string) string {
+	return token + "." + thumbprint
+}
+
+func jwkThumbprint(jwk *jose.JSONWebKey) (string, error) {
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
@@ -0,0 +1,77 @@
+package acme
+
+import (
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// ACME object statuses (RFC 8555 section 7.1.6).
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+// ACME challenge types supported by the server.
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type account struct {
+	id         string
+	key        *jose.JSONWebKey
+	thumbprint string
+	status     string
+	contact    []string
+	orderIDs   []string
+	lastSeen   time.Time
+	// externalAccountID is the key id of the external account binding, if any
+	externalAccountID string
+}
+
+type order struct {
+	id               string
+	accountID        string
+	status           string
+	expires          time.Time
+	identifiers      []identifier
+	authorizationIDs []string
+	certificateID    string
+	err              *problem
+}
+
+type authorization struct {
+	id           string
+	accountID    string
+	identifier   identifier
+	status       string
+	expires      time.Time
+	wildcard     bool
+	challengeIDs []string
+}
+
+type challenge struct {
+	id              string
+	authorizationID string
+	typ             string
+	status          string
+	token           string
+	validated       time.Time
+	err             *problem
+}
+
+type certificate struct {
+	id        string
+	accountID string
+	chainPEM  []byte
+}
@@ -0,0 +1,64 @@
+package acme
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// problem represents an RFC 7807 problem document as used by ACME (RFC 8555 section 6.7).
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+// Error returns the problem detail.
+func (p *problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+const problemTypePrefix = "urn:ietf:params:acme:error:"
+
+func newProblem(status int, errType string, format string, args ...interface{}) *problem {
+	return &problem{
+		Type:   problemTypePrefix + errType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func malformed(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusBadRequest, "malformed", format, args...)
+}
+
+func unauthorized(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusForbidden, "unauthorized", format, args...)
+}
+
+func notFound(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusNotFound, "malformed", format, args...)
+}
+
+func badNonce(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusBadRequest, "badNonce", format, args...)
+}
+
+func orderNotReady(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusForbidden, "orderNotReady", format, args...)
+}
+
+func badCSR(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusBadRequest, "badCSR", format, args...)
+}
+
+func badPublicKey(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusBadRequest, "badPublicKey", format, args...)
+}
+
+func rejectedIdentifier(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusBadRequest, "rejectedIdentifier", format, args...)
+}
+
+func serverInternal(format string, args ...interface{}) *problem {
+	return newProblem(http.StatusInternalServerError, "serverInternal", format, args...)
+}
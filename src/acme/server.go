@@ -0,0 +1,312 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultOrderLifetime = time.Hour * 24
+	nonceLifetime        = time.Hour
+	maxOutstandingNonces = 10000
+
+	// expired orders (and their authorizations, challenges and certificates) are kept for
+	// orderRetention, and accounts without orders are forgotten after accountIdleLifetime.
+	garbageCollectionInterval = time.Minute
+	orderRetention            = time.Hour * 24
+	accountIdleLifetime       = time.Hour * 24 * 90
+)
+
+// Server is an RFC 8555 (ACME) front-end for a CertificateIssuer.
+//
+// Note that server state (accounts, orders, etc.) is only kept in memory,
+// so in-flight orders do not survive restarts. Issued certificates are
+// recorded in the certificate store (if one is configured) as usual.
+// Expired orders and idle accounts are garbage collected, and the number
+// of outstanding nonces is capped (dropping the oldest first).
+type Server struct {
+	iss             issuer.CertificateIssuer
+	auditor         auditor.Auditor
+	failureAuditor  auditor.Auditor
+	certificates    store.CertificateStore
+	intents         auditor.IntentJournal
+	policy          *policy.Policy
+	templateBuilder template.CertificateTemplateBuilder
+	validators      map[string]ChallengeValidator
+	baseURL         string
+	orderLifetime   time.Duration
+	// externalAccountKeys are the MAC keys (by key id) for external account bindings
+	externalAccountKeys map[string][]byte
+
+	mu                   sync.Mutex
+	nonces               map[string]time.Time
+	nonceQueue           []string
+	lastGarbageCollected time.Time
+	accounts             map[string]*account
+	accountsByThumbprint map[string]string
+	orders               map[string]*order
+	authorizations       map[string]*authorization
+	challenges           map[string]*challenge
+	issued               map[string]*certificate
+}
+
+// Option represents a configuration option for the ACME Server.
+type Option func(*Server)
+
+// WithCertificateStore enables recording certificates issued via ACME in an inventory.
+func WithCertificateStore(certificates store.CertificateStore) Option {
+	return func(s *Server) { s.certificates = certificates }
+}
+
+// WithIntentJournal enables keeping track of issuance intents until they are resolved,
+// such that those never completed can be flagged (see service.ReconcileIntents).
+func WithIntentJournal(intents auditor.IntentJournal) Option {
+	return func(s *Server) { s.intents = intents }
+}
+
+// WithFailureAuditor sets the Auditor for the events of denied and failed requests
+// instead of the server's auditor (see service.WithFailureAuditor).
+func WithFailureAuditor(failureAuditor auditor.Auditor) Option {
+	return func(s *Server) { s.failureAuditor = failureAuditor }
+}
+
+// WithPolicy enables enforcing an issuance policy on finalized orders.
+func WithPolicy(p *policy.Policy) Option {
+	return func(s *Server) { s.policy = p }
+}
+
+// WithTemplateBuilder sets the CertificateTemplateBuilder for certificates issued via
+// ACME (e.g. a server certificate profile) instead of the issuer's default builder.
+func WithTemplateBuilder(templateBuilder template.CertificateTemplateBuilder) Option {
+	return func(s *Server) { s.templateBuilder = templateBuilder }
+}
+
+// WithChallengeValidator sets the ChallengeValidator for a challenge type, replacing the default
+// (which is NewHTTP01Validator(nil, 0) for http-01 and NewDNS01Validator(nil) for dns-01).
+func WithChallengeValidator(challengeType string, validator ChallengeValidator) Option {
+	return func(s *Server) { s.validators[challengeType] = validator }
+}
+
+// WithBaseURL sets the external base URL (e.g. "https://ca.example.com") of the server. When
+// not set, it is derived from the Host (and X-Forwarded-Proto) header of every request.
+func WithBaseURL(baseURL string) Option {
+	return func(s *Server) { s.baseURL = baseURL }
+}
+
+// WithExternalAccountKeys requires new accounts to be bound to an external account
+// (RFC 8555 section 7.3.4) with one of the given MAC keys, by key identifier.
+func WithExternalAccountKeys(keys map[string][]byte) Option {
+	return func(s *Server) { s.externalAccountKeys = keys }
+}
+
+// WithOrderLifetime sets how long orders (and their authorizations) remain valid.
+func WithOrderLifetime(d time.Duration) Option {
+	return func(s *Server) { s.orderLifetime = d }
+}
+
+// NewServer returns a new ACME Server.
+func NewServer(
+	iss issuer.CertificateIssuer,
+	auditor auditor.Auditor,
+	opts ...Option,
+) *Server {
+	s := &Server{
+		iss:     iss,
+		auditor: auditor,
+		validators: map[string]ChallengeValidator{
+			ChallengeHTTP01: NewHTTP01Validator(nil, 0),
+			ChallengeDNS01:  NewDNS01Validator(nil),
+		},
+		orderLifetime:        defaultOrderLifetime,
+		nonces:               make(map[string]time.Time),
+		accounts:             make(map[string]*account),
+		accountsByThumbprint: make(map[string]string),
+		orders:               make(map[string]*order),
+		authorizations:       make(map[string]*authorization),
+		challenges:           make(map[string]*challenge),
+		issued:               make(map[string]*certificate),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.failureAuditor == nil {
+		s.failureAuditor = auditor
+	}
+	return s
+}
+
+// Register mounts the ACME endpoints (under /acme) on a gin router.
+func (s *Server) Register(r gin.IRouter) {
+	g := r.Group("/acme")
+
+	g.GET("/directory", s.directoryHandler)
+	g.HEAD("/new-nonce", s.newNonceHandler)
+	g.GET("/new-nonce", s.newNonceHandler)
+	g.POST("/new-account", s.newAccountHandler)
+	g.POST("/account/:id", s.accountHandler)
+	g.POST("/account/:id/orders", s.accountOrdersHandler)
+	g.POST("/new-order", s.newOrderHandler)
+	g.POST("/order/:id", s.orderHandler)
+	g.POST("/order/:id/finalize", s.finalizeHandler)
+	g.POST("/authz/:id", s.authorizationHandler)
+	g.POST("/challenge/:id", s.challengeHandler)
+	g.POST("/cert/:id", s.certificateHandler)
+}
+
+// baseURLFor returns the external base URL of the server for a request.
+func (s *Server) baseURLFor(c *gin.Context) string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func (s *Server) url(c *gin.Context, path string) string {
+	return s.baseURLFor(c) + "/acme" + path
+}
+
+// newNonce must be called with the lock held.
+func (s *Server) newNonce() string {
+	now := time.Now()
+	s.collectGarbage(now)
+
+	// nonces expire in the order they are issued, so the oldest are at the front of the
+	// queue: drop those which expired (or were consumed), and the oldest ones at capacity
+	for len(s.nonceQueue) > 0 {
+		oldest := s.nonceQueue[0]
+		if len(s.nonceQueue) < maxOutstandingNonces && now.Before(s.nonces[oldest]) {
+			break
+		}
+		delete(s.nonces, oldest)
+		s.nonceQueue = s.nonceQueue[1:]
+	}
+
+	nonce := newID()
+	s.nonces[nonce] = now.Add(nonceLifetime)
+	s.nonceQueue = append(s.nonceQueue, nonce)
+	return nonce
+}
+
+// collectGarbage forgets expired orders and idle accounts, at most once
+// every garbageCollectionInterval. It must be called with the lock held.
+func (s *Server) collectGarbage(now time.Time) {
+	if now.Sub(s.lastGarbageCollected) < garbageCollectionInterval {
+		return
+	}
+	s.lastGarbageCollected = now
+
+	for id, o := range s.orders {
+		if now.Before(o.expires.Add(orderRetention)) {
+			continue
+		}
+		for _, authorizationID := range o.authorizationIDs {
+			if authz, ok := s.authorizations[authorizationID]; ok {
+				for _, challengeID := range authz.challengeIDs {
+					delete(s.challenges, challengeID)
+				}
+				delete(s.authorizations, authorizationID)
+			}
+		}
+		delete(s.issued, o.certificateID)
+		delete(s.orders, id)
+	}
+
+	for id, acct := range s.accounts {
+		orderIDs := []string{}
+		for _, orderID := range acct.orderIDs {
+			if _, ok := s.orders[orderID]; ok {
+				orderIDs = append(orderIDs, orderID)
+			}
+		}
+		acct.orderIDs = orderIDs
+		if len(orderIDs) == 0 && now.Sub(acct.lastSeen) > accountIdleLifetime {
+			delete(s.accountsByThumbprint, acct.thumbprint)
+			delete(s.accounts, id)
+		}
+	}
+}
+
+// consumeNonce must be called with the lock held.
+func (s *Server) consumeNonce(nonce string) bool {
+	expires, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return time.Now().Before(expires)
+}
+
+// respond writes an ACME response, including the headers every response must carry.
+func (s *Server) respond(c *gin.Context, status int, body interface{}) {
+	s.mu.Lock()
+	nonce := s.newNonce()
+	s.mu.Unlock()
+
+	c.Header("Replay-Nonce", nonce)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Link", "<"+s.url(c, "/directory")+">;rel=\"index\"")
+	if body == nil {
+		c.Status(status)
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+// fail writes an ACME problem document response.
+func (s *Server) fail(c *gin.Context, p *problem) {
+	s.mu.Lock()
+	nonce := s.newNonce()
+	s.mu.Unlock()
+
+	c.Header("Replay-Nonce", nonce)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Link", "<"+s.url(c, "/directory")+">;rel=\"index\"")
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing is not recoverable
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Server) directoryHandler(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{
+		"newNonce":   s.url(c, "/new-nonce"),
+		"newAccount": s.url(c, "/new-account"),
+		"newOrder":   s.url(c, "/new-order"),
+		"meta": gin.H{
+			"externalAccountRequired": len(s.externalAccountKeys) > 0,
+		},
+	})
+}
+
+func (s *Server) newNonceHandler(c *gin.Context) {
+	status := http.StatusNoContent
+	if c.Request.Method == http.MethodHead {
+		status = http.StatusOK
+	}
+	s.respond(c, status, nil)
+}
@@ -0,0 +1,506 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+)
+
+const testBaseURL = "https://ca.example.com"
+
+// validatorFunc is a function implementation of the ChallengeValidator interface.
+type validatorFunc func(ctx context.Context, domain, token, keyAuthorization string) error
+
+func (f validatorFunc) Validate(ctx context.Context, domain, token, keyAuthorization string) error {
+	return f(ctx, domain, token, keyAuthorization)
+}
+
+// testClient is a minimal ACME client signing requests with an ECDSA account key.
+type testClient struct {
+	t       *testing.T
+	handler http.Handler
+	key     crypto.Signer
+	// kid is the account URL, once the account is created
+	kid   string
+	nonce string
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *testClient {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate account key: %v", err)
+	}
+	return &testClient{t: t, handler: handler, key: key}
+}
+
+// jwk returns the public JSON web key of the client's account key.
+func (tc *testClient) jwk() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: tc.key.Public()}
+}
+
+// newNonce returns a fresh nonce from the server.
+func (tc *testClient) newNonce() string {
+	w := httptest.NewRecorder()
+	tc.handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/acme/new-nonce", nil))
+	return w.Header().Get("Replay-Nonce")
+}
+
+// post signs a payload (a POST-as-GET if nil) for a path with the client's account key
+// (with a jwk header if the client has no account yet) and posts it to the server.
+func (tc *testClient) post(path string, payload interface{}) *httptest.ResponseRecorder {
+	tc.t.Helper()
+	if tc.nonce == "" {
+		tc.nonce = tc.newNonce()
+	}
+	headers := map[jose.HeaderKey]interface{}{"nonce": tc.nonce, "url": testBaseURL + "/acme" + path}
+	if tc.kid != "" {
+		headers["kid"] = tc.kid
+	}
+	w := tc.postSigned(path, jose.SigningKey{Algorithm: jose.ES256, Key: tc.key}, &jose.SignerOptions{EmbedJWK: tc.kid == "", ExtraHeaders: headers}, payload)
+	tc.nonce = w.Header().Get("Replay-Nonce")
+	return w
+}
+
+// postSigned signs a payload (a POST-as-GET if nil) as given and posts it to the server.
+func (tc *testClient) postSigned(path string, key jose.SigningKey, opts *jose.SignerOptions, payload interface{}) *httptest.ResponseRecorder {
+	tc.t.Helper()
+	data := []byte{}
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			tc.t.Fatalf("failed to json-encode payload: %v", err)
+		}
+	}
+	signer, err := jose.NewSigner(key, opts)
+	if err != nil {
+		tc.t.Fatalf("failed to create signer: %v", err)
+	}
+	jws, err := signer.Sign(data)
+	if err != nil {
+		tc.t.Fatalf("failed to sign payload: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/acme"+path, strings.NewReader(jws.FullSerialize()))
+	req.Header.Set("Content-Type", "application/jose+json")
+	w := httptest.NewRecorder()
+	tc.handler.ServeHTTP(w, req)
+	return w
+}
+
+// newAccount creates the client's account, with the given external account binding (if any).
+func (tc *testClient) newAccount(binding json.RawMessage) *httptest.ResponseRecorder {
+	tc.t.Helper()
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if binding != nil {
+		payload["externalAccountBinding"] = binding
+	}
+	w := tc.post("/new-account", payload)
+	if w.Code == http.StatusCreated {
+		tc.kid = w.Header().Get("Location")
+	}
+	return w
+}
+
+// externalAccountBinding returns an external account binding of the client's
+// account key, MACed with the given key under the given key identifier.
+func (tc *testClient) externalAccountBinding(keyID string, macKey []byte) json.RawMessage {
+	tc.t.Helper()
+	jwk, err := json.Marshal(tc.jwk())
+	if err != nil {
+		tc.t.Fatalf("failed to json-encode jwk: %v", err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: macKey},
+		&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{"kid": keyID, "url": testBaseURL + "/acme/new-account"}},
+	)
+	if err != nil {
+		tc.t.Fatalf("failed to create signer: %v", err)
+	}
+	jws, err := signer.Sign(jwk)
+	if err != nil {
+		tc.t.Fatalf("failed to sign external account binding: %v", err)
+	}
+	return json.RawMessage(jws.FullSerialize())
+}
+
+// newTestServer returns an ACME server (mounted on a gin router) for a new CA,
+// validating http-01 challenges with the given validator.
+func newTestServer(t *testing.T, validator ChallengeValidator, opts ...Option) http.Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	iss := issuer.New(caCert, key, template.New(time.Minute, time.Hour))
+
+	opts = append([]Option{WithBaseURL(testBaseURL), WithChallengeValidator(ChallengeHTTP01, validator)}, opts...)
+	router := gin.New()
+	NewServer(iss, auditor.NewSlog(io.Discard, nil), opts...).Register(router)
+	return router
+}
+
+// acceptAll is a ChallengeValidator which validates every challenge.
+var acceptAll = validatorFunc(func(context.Context, string, string, string) error { return nil })
+
+// problemType returns the (unprefixed) ACME problem type of a response.
+func problemType(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("failed to json-decode problem document: %v (%s)", err, w.Body.String())
+	}
+	return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:")
+}
+
+func TestNonces(t *testing.T) {
+	client := newTestClient(t, newTestServer(t, acceptAll))
+	nonce := client.newNonce()
+
+	client.nonce = nonce
+	if w := client.newAccount(nil); w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name  string
+		nonce string
+	}{
+		{name: "replayed nonce", nonce: nonce},
+		{name: "unknown nonce", nonce: "bm90LWEtbm9uY2U"},
+		{name: "missing nonce"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := client.postSigned(
+				"/new-order",
+				jose.SigningKey{Algorithm: jose.ES256, Key: client.key},
+				&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{
+					"nonce": test.nonce, "url": testBaseURL + "/acme/new-order", "kid": client.kid,
+				}},
+				&newOrderPayload{Identifiers: []identifier{{Type: "dns", Value: "a.example.com"}}},
+			)
+			if w.Code != http.StatusBadRequest || problemType(t, w) != "badNonce" {
+				t.Fatalf("expected a badNonce problem, got: %d (%s)", w.Code, w.Body.String())
+			}
+			if w.Header().Get("Replay-Nonce") == "" {
+				t.Fatal("expected a fresh nonce with the problem document")
+			}
+		})
+	}
+}
+
+func TestJWSVerification(t *testing.T) {
+	handler := newTestServer(t, acceptAll)
+	client := newTestClient(t, handler)
+	if w := client.newAccount(nil); w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+	}
+	other := newTestClient(t, handler)
+	if w := other.newAccount(nil); w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+	}
+	accountPath := strings.TrimPrefix(client.kid, testBaseURL+"/acme")
+
+	tests := []struct {
+		name string
+		path string
+		// key signs the request, with the given headers other than the nonce
+		key     crypto.Signer
+		headers map[jose.HeaderKey]interface{}
+		jwk     bool
+		// problem is the expected (unprefixed) ACME problem type
+		problem string
+	}{
+		{
+			name:    "url header of another endpoint",
+			path:    "/new-order",
+			key:     client.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme/new-account", "kid": client.kid},
+			problem: "unauthorized",
+		},
+		{
+			name:    "jwk header instead of kid header",
+			path:    "/new-order",
+			key:     client.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme/new-order"},
+			jwk:     true,
+			problem: "malformed",
+		},
+		{
+			name:    "kid header on new-account",
+			path:    "/new-account",
+			key:     client.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme/new-account", "kid": client.kid},
+			problem: "malformed",
+		},
+		{
+			name:    "kid header of an unknown account",
+			path:    "/new-order",
+			key:     client.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme/new-order", "kid": testBaseURL + "/acme/account/unknown"},
+			problem: "accountDoesNotExist",
+		},
+		{
+			name:    "kid header of another account",
+			path:    "/new-order",
+			key:     client.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme/new-order", "kid": other.kid},
+			problem: "malformed",
+		},
+		{
+			name:    "account url of another account",
+			path:    accountPath,
+			key:     other.key,
+			headers: map[jose.HeaderKey]interface{}{"url": testBaseURL + "/acme" + accountPath, "kid": other.kid},
+			problem: "unauthorized",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.headers["nonce"] = client.newNonce()
+			w := client.postSigned(
+				test.path,
+				jose.SigningKey{Algorithm: jose.ES256, Key: test.key},
+				&jose.SignerOptions{EmbedJWK: test.jwk, ExtraHeaders: test.headers},
+				&newOrderPayload{Identifiers: []identifier{{Type: "dns", Value: "a.example.com"}}},
+			)
+			if w.Code < 400 || problemType(t, w) != test.problem {
+				t.Fatalf("expected a %s problem, got: %d (%s)", test.problem, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("unsupported signature algorithm", func(t *testing.T) {
+		w := client.postSigned(
+			"/new-order",
+			jose.SigningKey{Algorithm: jose.HS256, Key: []byte("0123456789abcdef0123456789abcdef")},
+			&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{
+				"nonce": client.newNonce(), "url": testBaseURL + "/acme/new-order", "kid": client.kid,
+			}},
+			&newOrderPayload{Identifiers: []identifier{{Type: "dns", Value: "a.example.com"}}},
+		)
+		if w.Code != http.StatusBadRequest || problemType(t, w) != "badSignatureAlgorithm" {
+			t.Fatalf("expected a badSignatureAlgorithm problem, got: %d (%s)", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("content type other than application/jose+json", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/acme/new-account", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || problemType(t, w) != "malformed" {
+			t.Fatalf("expected a malformed problem, got: %d (%s)", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestExternalAccountBinding(t *testing.T) {
+	macKey := []byte("0123456789abcdef0123456789abcdef")
+	handler := newTestServer(t, acceptAll, WithExternalAccountKeys(map[string][]byte{"tenant-a": macKey}))
+
+	tests := []struct {
+		name    string
+		binding func(client, other *testClient) json.RawMessage
+		// problem is the expected (unprefixed) ACME problem type, empty if the account must be created
+		problem string
+	}{
+		{
+			name:    "valid binding",
+			binding: func(client, _ *testClient) json.RawMessage { return client.externalAccountBinding("tenant-a", macKey) },
+		},
+		{
+			name:    "missing binding",
+			binding: func(*testClient, *testClient) json.RawMessage { return nil },
+			problem: "externalAccountRequired",
+		},
+		{
+			name:    "unknown key id",
+			binding: func(client, _ *testClient) json.RawMessage { return client.externalAccountBinding("tenant-b", macKey) },
+			problem: "unauthorized",
+		},
+		{
+			name: "wrong mac key",
+			binding: func(client, _ *testClient) json.RawMessage {
+				return client.externalAccountBinding("tenant-a", []byte("fedcba9876543210fedcba9876543210"))
+			},
+			problem: "unauthorized",
+		},
+		{
+			name:    "binding of another account key",
+			binding: func(_, other *testClient) json.RawMessage { return other.externalAccountBinding("tenant-a", macKey) },
+			problem: "unauthorized",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, other := newTestClient(t, handler), newTestClient(t, handler)
+			w := client.newAccount(test.binding(client, other))
+			if test.problem == "" {
+				if w.Code != http.StatusCreated {
+					t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code < 400 || problemType(t, w) != test.problem {
+				t.Fatalf("expected a %s problem, got: %d (%s)", test.problem, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOrderFinalize(t *testing.T) {
+	handler := newTestServer(t, validatorFunc(func(_ context.Context, domain, _, _ string) error {
+		if domain == "invalid.example.com" {
+			return errors.New("challenge response does not match")
+		}
+		return nil
+	}))
+	client := newTestClient(t, handler)
+	if w := client.newAccount(nil); w.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name        string
+		identifiers []string
+		// validate is whether the http-01 challenges are validated before finalizing
+		validate bool
+		csrNames []string
+		// problem is the expected (unprefixed) ACME problem type of the new-order
+		// or finalize request, empty if the certificate must be issued
+		problem string
+	}{
+		{name: "valid order", identifiers: []string{"a.example.com", "b.example.com"}, validate: true, csrNames: []string{"a.example.com", "b.example.com"}},
+		{name: "unauthorized order", identifiers: []string{"a.example.com"}, csrNames: []string{"a.example.com"}, problem: "orderNotReady"},
+		{name: "failed challenge", identifiers: []string{"invalid.example.com"}, validate: true, csrNames: []string{"invalid.example.com"}, problem: "orderNotReady"},
+		{name: "csr with names not ordered", identifiers: []string{"a.example.com"}, validate: true, csrNames: []string{"a.example.com", "c.example.com"}, problem: "badCSR"},
+		{name: "csr missing ordered names", identifiers: []string{"a.example.com", "b.example.com"}, validate: true, csrNames: []string{"a.example.com"}, problem: "badCSR"},
+		{name: "non-public identifier", identifiers: []string{"ca.internal"}, problem: "rejectedIdentifier"},
+		{name: "ip address identifier", identifiers: []string{"10.0.0.1"}, problem: "rejectedIdentifier"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identifiers := []identifier{}
+			for _, name := range test.identifiers {
+				identifiers = append(identifiers, identifier{Type: "dns", Value: name})
+			}
+			w := client.post("/new-order", &newOrderPayload{Identifiers: identifiers})
+			if w.Code != http.StatusCreated {
+				if test.problem == "" || problemType(t, w) != test.problem {
+					t.Fatalf("expected status code %d, got: %d (%s)", http.StatusCreated, w.Code, w.Body.String())
+				}
+				return
+			}
+			var o struct {
+				Authorizations []string `json:"authorizations"`
+				Finalize       string   `json:"finalize"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+				t.Fatalf("failed to json-decode order: %v", err)
+			}
+
+			if test.validate {
+				for _, authzURL := range o.Authorizations {
+					w = client.post(strings.TrimPrefix(authzURL, testBaseURL+"/acme"), nil)
+					var authz struct {
+						Challenges []struct {
+							Type string `json:"type"`
+							URL  string `json:"url"`
+						} `json:"challenges"`
+					}
+					if err := json.Unmarshal(w.Body.Bytes(), &authz); err != nil {
+						t.Fatalf("failed to json-decode authorization: %v", err)
+					}
+					for _, chal := range authz.Challenges {
+						if chal.Type == ChallengeHTTP01 {
+							client.post(strings.TrimPrefix(chal.URL, testBaseURL+"/acme"), struct{}{})
+						}
+					}
+				}
+			}
+
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatalf("failed to generate ecdsa key: %v", err)
+			}
+			csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: test.csrNames}, key)
+			if err != nil {
+				t.Fatalf("failed to create csr: %v", err)
+			}
+			w = client.post(strings.TrimPrefix(o.Finalize, testBaseURL+"/acme"), &finalizePayload{CSR: base64.RawURLEncoding.EncodeToString(csrDER)})
+			if test.problem != "" {
+				if w.Code < 400 || problemType(t, w) != test.problem {
+					t.Fatalf("expected a %s problem, got: %d (%s)", test.problem, w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+			}
+			var finalized struct {
+				Status      string `json:"status"`
+				Certificate string `json:"certificate"`
+			}
+			if err = json.Unmarshal(w.Body.Bytes(), &finalized); err != nil {
+				t.Fatalf("failed to json-decode order: %v", err)
+			}
+			if finalized.Status != statusValid {
+				t.Fatalf("expected order status %s, got: %s", statusValid, finalized.Status)
+			}
+
+			w = client.post(strings.TrimPrefix(finalized.Certificate, testBaseURL+"/acme"), nil)
+			block, _ := pem.Decode(w.Body.Bytes())
+			if w.Code != http.StatusOK || block == nil {
+				t.Fatalf("expected a pem certificate chain, got: %d (%s)", w.Code, w.Body.String())
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+			if strings.Join(cert.DNSNames, ",") != strings.Join(test.csrNames, ",") {
+				t.Fatalf("expected certificate dns names %v, got: %v", test.csrNames, cert.DNSNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package auditor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncAuditor is an implementation of the Auditor interface which queues audit events
+// (up to a maximum number) and delivers them to another Auditor in the background, one
+// at a time. Events which do not fit in the queue are dropped, and the number dropped
+// is logged. It is meant for high-volume events which must not delay responses nor be
+// able to overload the underlying Auditor, such as those of denied requests.
+type AsyncAuditor struct {
+	auditor Auditor
+
+	// mu guards closing the queue, so that events are never sent on it once closed
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan *Event
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// ensure AsyncAuditor implements Auditor.
+var _ Auditor = (*AsyncAuditor)(nil)
+
+// NewAsyncAuditor returns an Auditor which delivers audit events to the given
+// Auditor in the background until closed, queueing up to queueSize events.
+func NewAsyncAuditor(auditor Auditor, queueSize int) *AsyncAuditor {
+	a := &AsyncAuditor{
+		auditor: auditor,
+		queue:   make(chan *Event, queueSize),
+		done:    make(chan struct{}),
+	}
+	go a.deliver()
+	return a
+}
+
+// Audit handles an audit event by queueing it, or dropping it if the queue is full.
+// Events audited after Close are rejected with an error.
+func (a *AsyncAuditor) Audit(e *Event) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return fmt.Errorf("failed to queue audit event %s (%s): auditor is closed", e.EventID, e.EventType)
+	}
+	select {
+	case a.queue <- e:
+	default:
+		a.dropped.Add(1)
+	}
+	return nil
+}
+
+// Close stops accepting audit events and waits until the queued ones are delivered (or the
+// context is done). It does not close the underlying Auditor.
+func (a *AsyncAuditor) Close(ctx context.Context) {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+	}
+}
+
+// deliver delivers queued audit events until the queue is closed and drained.
+func (a *AsyncAuditor) deliver() {
+	defer close(a.done)
+	for e := range a.queue {
+		if err := a.auditor.Audit(e); err != nil {
+			log.Printf("failed to deliver audit event %s (%s): %v", e.EventID, e.EventType, err)
+		}
+		if dropped := a.dropped.Swap(0); dropped > 0 {
+			log.Printf("dropped %d audit events: audit event queue full", dropped)
+		}
+	}
+	if dropped := a.dropped.Swap(0); dropped > 0 {
+		log.Printf("dropped %d audit events: audit event queue full", dropped)
+	}
+}
@@ -0,0 +1,81 @@
+package auditor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingAuditor is an implementation of the Auditor interface which counts
+// the audit events it receives, blocking on each until released.
+type blockingAuditor struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	audited int
+}
+
+func (b *blockingAuditor) Audit(e *Event) error {
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.audited++
+	return nil
+}
+
+func TestAsyncAuditorClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// timeout is the time Close waits for the queued events to be delivered
+		timeout time.Duration
+		// release is whether the underlying auditor delivers events before Close times out
+		release bool
+	}{
+		{name: "queue drained", timeout: time.Second, release: true},
+		{name: "close timed out", timeout: 10 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			underlying := &blockingAuditor{release: make(chan struct{})}
+			a := NewAsyncAuditor(underlying, 10)
+			for i := 0; i < 3; i++ {
+				if err := a.Audit(&Event{}); err != nil {
+					t.Fatalf("expected no error auditing before close, got: %v", err)
+				}
+			}
+			if test.release {
+				close(underlying.release)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+			a.Close(ctx)
+
+			// events audited after close (e.g. by handlers still running
+			// after a shutdown timed out) are rejected, not sent on the queue
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := a.Audit(&Event{}); err == nil {
+						t.Errorf("expected an error auditing after close, got: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if !test.release {
+				close(underlying.release)
+			}
+			// closing again is a no-op, other than waiting for the delivery
+			a.Close(context.Background())
+			underlying.mu.Lock()
+			defer underlying.mu.Unlock()
+			if underlying.audited != 3 {
+				t.Fatalf("expected %d delivered audit events, got: %d", 3, underlying.audited)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package auditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adrianosela/ca/src/signer"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// supported auditor backends.
+const (
+	TypeQLDB       = "qldb"
+	TypeCloudWatch = "cloudwatch"
+	TypeStdout     = "stdout"
+	TypeFile       = "file"
+)
+
+// Config represents the configuration of an Auditor, and of its Sink when composed
+// into a MultiAuditor (see NewMultiAuditorFromConfig). The name defaults to the type.
+type Config struct {
+	Type       string            `yaml:"type"`
+	Name       string            `yaml:"name"`
+	BestEffort bool              `yaml:"best_effort"`
+	Timeout    time.Duration     `yaml:"timeout"`
+	Spool      *SpoolConfig      `yaml:"spool"`
+	QLDB       *QLDBConfig       `yaml:"qldb"`
+	CloudWatch *CloudWatchConfig `yaml:"cloudwatch"`
+	File       *FileConfig       `yaml:"file"`
+}
+
+// SpoolConfig represents the configuration of a SpoolAuditor wrapping an Auditor.
+type SpoolConfig struct {
+	Directory  string        `yaml:"directory"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// QLDBConfig represents the configuration of an Amazon QLDB Auditor.
+type QLDBConfig struct {
+	Ledger string `yaml:"ledger"`
+	Table  string `yaml:"table"`
+}
+
+// CloudWatchConfig represents the configuration of an AWS CloudWatch Auditor.
+type CloudWatchConfig struct {
+	LogGroup  string `yaml:"log_group"`
+	LogStream string `yaml:"log_stream"`
+}
+
+// FileConfig represents the configuration of a hash-chained local file Auditor.
+type FileConfig struct {
+	Path               string        `yaml:"path"`
+	CheckpointEvery    int           `yaml:"checkpoint_every"`
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+	// Signer holds the key checkpoints are signed with.
+	Signer signer.Config `yaml:"signer"`
+}
+
+// Validate checks the configuration for errors without initializing the Auditor.
+func (c *Config) Validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.Spool != nil {
+		if c.Spool.Directory == "" {
+			return errors.New("spool requires a directory")
+		}
+		if c.Spool.MaxBackoff < 0 {
+			return errors.New("spool max_backoff must not be negative")
+		}
+	}
+	switch c.Type {
+	case TypeQLDB:
+		if c.QLDB == nil || c.QLDB.Ledger == "" || c.QLDB.Table == "" {
+			return errors.New("qldb auditor requires a ledger and table")
+		}
+	case TypeCloudWatch:
+		if c.CloudWatch == nil || c.CloudWatch.LogGroup == "" || c.CloudWatch.LogStream == "" {
+			return errors.New("cloudwatch auditor requires a log_group and log_stream")
+		}
+	case TypeFile:
+		if c.File == nil || c.File.Path == "" {
+			return errors.New("file auditor requires a path")
+		}
+		if c.File.CheckpointEvery < 0 || c.File.CheckpointInterval < 0 {
+			return errors.New("file auditor checkpoint_every and checkpoint_interval must not be negative")
+		}
+		if err := c.File.Signer.Validate(); err != nil {
+			return fmt.Errorf("invalid file auditor signer: %v", err)
+		}
+	case TypeStdout:
+	default:
+		return fmt.Errorf("unsupported auditor type %q, must be one of %q, %q, %q or %q", c.Type, TypeQLDB, TypeCloudWatch, TypeFile, TypeStdout)
+	}
+	return nil
+}
+
+// Auditor returns the Auditor selected by the configuration.
+func (c *Config) Auditor(cfg aws.Config) (Auditor, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case TypeQLDB:
+		return NewQLDBAuditor(cfg, c.QLDB.Ledger, c.QLDB.Table)
+	case TypeCloudWatch:
+		return NewCloudWatchAuditor(cfg, c.CloudWatch.LogGroup, c.CloudWatch.LogStream), nil
+	case TypeFile:
+		checkpointSigner, err := signer.New(context.Background(), &c.File.Signer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize checkpoint signer: %v", err)
+		}
+		opts := []FileOption{}
+		if c.File.CheckpointEvery > 0 {
+			opts = append(opts, WithCheckpointEvery(c.File.CheckpointEvery))
+		}
+		if c.File.CheckpointInterval > 0 {
+			opts = append(opts, WithCheckpointInterval(c.File.CheckpointInterval))
+		}
+		return NewFileAuditor(c.File.Path, checkpointSigner, opts...)
+	default:
+		return NewSlog(os.Stdout, nil), nil
+	}
+}
+
+// Sink returns the MultiAuditor Sink selected by the configuration,
+// which delivers asynchronously via a SpoolAuditor if a spool is configured.
+func (c *Config) Sink(cfg aws.Config) (Sink, error) {
+	auditor, err := c.Auditor(cfg)
+	if err != nil {
+		return Sink{}, err
+	}
+	if c.Spool != nil {
+		opts := []SpoolOption{}
+		if c.Spool.MaxBackoff > 0 {
+			opts = append(opts, WithSpoolBackoff(defaultSpoolInitialBackoff, c.Spool.MaxBackoff))
+		}
+		if auditor, err = NewSpoolAuditor(auditor, c.Spool.Directory, opts...); err != nil {
+			return Sink{}, err
+		}
+	}
+	name := c.Name
+	if name == "" {
+		name = c.Type
+	}
+	return Sink{Name: name, Auditor: auditor, BestEffort: c.BestEffort, Timeout: c.Timeout}, nil
+}
+
+// NewMultiAuditorFromConfig returns a MultiAuditor dispatching
+// to the sinks selected by the given configurations.
+func NewMultiAuditorFromConfig(cfg aws.Config, configs ...Config) (*MultiAuditor, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no auditors configured")
+	}
+	sinks := []Sink{}
+	for i := range configs {
+		sink, err := configs[i].Sink(cfg)
+		if err != nil {
+			NewMultiAuditor(sinks...).Close(context.Background())
+			return nil, fmt.Errorf("failed to initialize %s auditor: %v", configs[i].Type, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return NewMultiAuditor(sinks...), nil
+}
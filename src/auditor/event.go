@@ -1,10 +1,26 @@
 package auditor
 
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
 // Client represents the portion of an
 // audit event describing the client.
 type Client struct {
-	IPAddress string `json:"ip_address" ion:"ipAddress"`
-	UserAgent string `json:"user_agent" ion:"userAgent"`
+	IPAddress  string `json:"ip_address"  ion:"ipAddress"`
+	UserAgent  string `json:"user_agent"  ion:"userAgent"`
+	Principal  string `json:"principal"   ion:"principal"`
+	AuthMethod string `json:"auth_method" ion:"authMethod"`
 }
 
 // CertificateSigningRequest represents the portion of an
@@ -37,12 +53,358 @@ type HTTPRequest struct {
 	IssueCertificateDuration int64 `json:"issue_certificate_duration_ms"  ion:"issueCertificateDurationMs"`
 }
 
+const (
+	// EventTypeIssuanceIntent is the type of events recorded before a certificate is signed.
+	EventTypeIssuanceIntent = "issuance_intent"
+	// EventTypeCertificateIssued is the type of events for issued end-entity certificates.
+	EventTypeCertificateIssued = "certificate_issued"
+	// EventTypeCACertificateIssued is the type of events for issued subordinate CA certificates.
+	EventTypeCACertificateIssued = "ca_certificate_issued"
+	// EventTypeIssuanceUnresolved is the type of events flagging issuance intents which
+	// were never completed, i.e. a certificate may have been signed but not audited.
+	EventTypeIssuanceUnresolved = "issuance_unresolved"
+	// EventTypeIssuanceFailed is the type of events for certificate signing requests which
+	// were denied or failed, at any point before the certificate was returned to the client.
+	EventTypeIssuanceFailed = "issuance_failed"
+	// EventTypeAuthenticationFailed is the type of events for requests to authenticated
+	// endpoints which were denied because the client could not be authenticated.
+	EventTypeAuthenticationFailed = "authentication_failed"
+)
+
+const (
+	// OutcomePending is the outcome of issuance intent events.
+	OutcomePending = "pending"
+	// OutcomeSuccess is the outcome of issued certificate events.
+	OutcomeSuccess = "success"
+	// OutcomeUnknown is the outcome of unresolved issuance events.
+	OutcomeUnknown = "unknown"
+	// OutcomeDenied is the outcome of failed issuance events caused by the client (4xx).
+	OutcomeDenied = "denied"
+	// OutcomeError is the outcome of failed issuance events caused by the CA (5xx).
+	OutcomeError = "error"
+)
+
+// categories of failed issuance events.
+const (
+	FailureCategoryInvalidRequest   = "invalid_request"
+	FailureCategoryUnauthorized     = "unauthorized"
+	FailureCategoryRejectedKey      = "rejected_key"
+	FailureCategoryPolicyDenied     = "policy_denied"
+	FailureCategoryBoundNamesDenied = "bound_names_denied"
+	FailureCategoryPolicyError      = "policy_error"
+	FailureCategoryAuditError       = "audit_error"
+	FailureCategoryTemplateError    = "template_error"
+	FailureCategorySigningError     = "signing_error"
+	FailureCategoryStorageError     = "storage_error"
+	FailureCategoryResponseError    = "response_error"
+)
+
+// limits applied when sanitizing the request details of failed issuance events.
+const (
+	maxSanitizedStringLength = 256
+	maxSanitizedDetailLength = 1024
+	maxSanitizedListLength   = 100
+)
+
+// Request represents the portion of an audit event describing a failed
+// certificate signing request, as far as it could be parsed. Values
+// originating from the client are sanitized (see NewIssuanceFailedEvent).
+type Request struct {
+	Method         string   `json:"method"          ion:"method"`
+	Path           string   `json:"path"            ion:"path"`
+	Subject        string   `json:"subject"         ion:"subject"`
+	DNSNames       []string `json:"dns_names"       ion:"dnsNames"`
+	EmailAddresses []string `json:"email_addresses" ion:"emailAddresses"`
+	IPAddresses    []string `json:"ip_addresses"    ion:"ipAddresses"`
+	URIs           []string `json:"uris"            ion:"uris"`
+}
+
+// Failure represents the portion of an audit event describing
+// why a certificate signing request was denied or failed.
+type Failure struct {
+	Category   string `json:"category"    ion:"category"`
+	StatusCode int    `json:"status_code" ion:"statusCode"`
+	Detail     string `json:"detail"      ion:"detail"`
+}
+
 // Event represents an audit event.
 type Event struct {
 	EventID                   string                    `json:"event_id"           ion:"eventId"`
+	EventType                 string                    `json:"event_type"         ion:"eventType"`
+	Outcome                   string                    `json:"outcome"            ion:"outcome"`
+	IntentID                  string                    `json:"intent_id"          ion:"intentId"`
 	Timestamp                 int64                     `json:"timestamp"          ion:"timestamp"`
 	Client                    Client                    `json:"client"             ion:"client"`
 	CertificateSigningRequest CertificateSigningRequest `json:"csr"                ion:"csr"`
 	IssuedCertificate         IssuedCertificate         `json:"issued_certificate" ion:"issuedCertificate"`
 	HTTPRequest               HTTPRequest               `json:"http_request"       ion:"httpRequest"`
+	Request                   Request                   `json:"request"            ion:"request"`
+	Failure                   Failure                   `json:"failure"            ion:"failure"`
+}
+
+// NewIssuanceIntentEvent returns the audit event recording the intent to issue a certificate
+// for a CSR, which must be emitted before the certificate is signed. The issued certificate
+// portion describes the template the certificate will be signed from (notably its serial
+// number, see template.Prebuild), without the issuer and raw certificate. The event's ID
+// is the intent ID of the matching completion event (see NewIssuanceEvent).
+func NewIssuanceIntentEvent(
+	client Client,
+	csr *x509.CertificateRequest,
+	template *x509.Certificate,
+	httpRequest HTTPRequest,
+) (*Event, error) {
+	certificateSigningRequest, err := newCertificateSigningRequest(csr)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		EventID:                   uuid.New().String(),
+		EventType:                 EventTypeIssuanceIntent,
+		Outcome:                   OutcomePending,
+		Timestamp:                 time.Now().UnixMilli(),
+		Client:                    client,
+		CertificateSigningRequest: certificateSigningRequest,
+		IssuedCertificate:         newIssuedCertificate(template),
+		HTTPRequest:               httpRequest,
+	}, nil
+}
+
+// NewIssuanceEvent returns the audit event for a certificate (DER encoded) issued for a CSR.
+func NewIssuanceEvent(
+	client Client,
+	csr *x509.CertificateRequest,
+	certDER []byte,
+	httpRequest HTTPRequest,
+) (*Event, error) {
+	certificateSigningRequest, err := newCertificateSigningRequest(csr)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %v", err)
+	}
+
+	eventType := EventTypeCertificateIssued
+	if cert.IsCA {
+		eventType = EventTypeCACertificateIssued
+	}
+
+	return &Event{
+		EventID:                   uuid.New().String(),
+		EventType:                 eventType,
+		Outcome:                   OutcomeSuccess,
+		Timestamp:                 time.Now().UnixMilli(),
+		Client:                    client,
+		CertificateSigningRequest: certificateSigningRequest,
+		IssuedCertificate:         newIssuedCertificate(cert),
+		HTTPRequest:               httpRequest,
+	}, nil
+}
+
+// NewIssuanceUnresolvedEvent returns the audit event flagging an issuance intent which was
+// never completed, along with the certificate issued for it if known, or otherwise the
+// certificate the intent was for (which may or may not have been signed).
+func NewIssuanceUnresolvedEvent(intent *Event, cert *x509.Certificate) *Event {
+	event := &Event{
+		EventID:                   uuid.New().String(),
+		EventType:                 EventTypeIssuanceUnresolved,
+		Outcome:                   OutcomeUnknown,
+		IntentID:                  intent.EventID,
+		Timestamp:                 time.Now().UnixMilli(),
+		Client:                    intent.Client,
+		CertificateSigningRequest: intent.CertificateSigningRequest,
+		IssuedCertificate:         intent.IssuedCertificate,
+		HTTPRequest:               intent.HTTPRequest,
+	}
+	if cert != nil {
+		event.IssuedCertificate = newIssuedCertificate(cert)
+	}
+	return event
+}
+
+// NewIssuanceFailedEvent returns the audit event for a certificate signing request aborted with
+// the given (HTTP) status code, whose outcome is OutcomeDenied for client errors and OutcomeError
+// otherwise. The CSR and the issued certificate (DER encoded) may be nil if the request did not
+// get that far. The failure detail and the request details taken from the CSR are sanitized:
+// control characters are replaced and strings and lists are truncated.
+func NewIssuanceFailedEvent(
+	client Client,
+	csr *x509.CertificateRequest,
+	certDER []byte,
+	httpRequest HTTPRequest,
+	request Request,
+	failure Failure,
+) *Event {
+	outcome := OutcomeError
+	if failure.StatusCode >= 400 && failure.StatusCode < 500 {
+		outcome = OutcomeDenied
+	}
+
+	request.Method = sanitize(request.Method, maxSanitizedStringLength)
+	request.Path = sanitize(request.Path, maxSanitizedStringLength)
+	request.DNSNames = []string{}
+	request.EmailAddresses = []string{}
+	request.IPAddresses = []string{}
+	request.URIs = []string{}
+	failure.Detail = sanitize(failure.Detail, maxSanitizedDetailLength)
+
+	event := &Event{
+		EventID:     uuid.New().String(),
+		EventType:   EventTypeIssuanceFailed,
+		Outcome:     outcome,
+		Timestamp:   time.Now().UnixMilli(),
+		Client:      client,
+		HTTPRequest: httpRequest,
+		Failure:     failure,
+	}
+
+	if csr != nil {
+		// the public key may be the reason for the failure, in which case it is left out
+		if certificateSigningRequest, err := newCertificateSigningRequest(csr); err == nil {
+			event.CertificateSigningRequest = certificateSigningRequest
+		}
+		request.Subject = sanitize(csr.Subject.String(), maxSanitizedStringLength)
+		request.DNSNames = sanitizeList(csr.DNSNames)
+		request.EmailAddresses = sanitizeList(csr.EmailAddresses)
+		for _, ip := range csr.IPAddresses {
+			request.IPAddresses = append(request.IPAddresses, ip.String())
+		}
+		request.IPAddresses = sanitizeList(request.IPAddresses)
+		for _, uri := range csr.URIs {
+			request.URIs = append(request.URIs, uri.String())
+		}
+		request.URIs = sanitizeList(request.URIs)
+	}
+	event.Request = request
+
+	if certDER != nil {
+		if cert, err := x509.ParseCertificate(certDER); err == nil {
+			event.IssuedCertificate = newIssuedCertificate(cert)
+		}
+	}
+	return event
+}
+
+// NewAuthenticationFailedEvent returns an auditor.EventTypeAuthenticationFailed event for a request
+// which could not be authenticated, whose outcome is OutcomeDenied. The request method and path
+// and the failure detail are sanitized (see NewIssuanceFailedEvent).
+func NewAuthenticationFailedEvent(client Client, request Request, failure Failure) *Event {
+	return &Event{
+		EventID:   uuid.New().String(),
+		EventType: EventTypeAuthenticationFailed,
+		Outcome:   OutcomeDenied,
+		Timestamp: time.Now().UnixMilli(),
+		Client:    client,
+		Request: Request{
+			Method:         sanitize(request.Method, maxSanitizedStringLength),
+			Path:           sanitize(request.Path, maxSanitizedStringLength),
+			DNSNames:       []string{},
+			EmailAddresses: []string{},
+			IPAddresses:    []string{},
+			URIs:           []string{},
+		},
+		Failure: Failure{
+			Category:   failure.Category,
+			StatusCode: failure.StatusCode,
+			Detail:     sanitize(failure.Detail, maxSanitizedDetailLength),
+		},
+	}
+}
+
+// sanitize replaces control and invalid characters and truncates a string to max bytes.
+func sanitize(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, strings.ToValidUTF8(s, "?"))
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+}
+
+// sanitizeList sanitizes the strings of a list truncated to maxSanitizedListLength entries.
+func sanitizeList(list []string) []string {
+	sanitized := []string{}
+	for i, s := range list {
+		if i == maxSanitizedListLength {
+			break
+		}
+		sanitized = append(sanitized, sanitize(s, maxSanitizedStringLength))
+	}
+	return sanitized
+}
+
+func newCertificateSigningRequest(csr *x509.CertificateRequest) (CertificateSigningRequest, error) {
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return CertificateSigningRequest{}, fmt.Errorf("failed to marshal PKIX public key: %v", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKeyDER,
+	})
+	hash := sha256.Sum256(publicKeyDER)
+
+	return CertificateSigningRequest{
+		PublicKey:            string(publicKeyPEM),
+		PublicKeyFingerprint: hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+// newIssuedCertificate returns the issued certificate portion of an audit
+// event for a certificate, or a certificate template (which has no raw bytes).
+func newIssuedCertificate(cert *x509.Certificate) IssuedCertificate {
+	var certPEM []byte
+	if len(cert.Raw) > 0 {
+		certPEM = pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})
+	}
+
+	ipAddresses := []string{}
+	for _, ip := range cert.IPAddresses {
+		ipAddresses = append(ipAddresses, ip.String())
+	}
+
+	emails := []string{}
+	if cert.EmailAddresses != nil {
+		emails = cert.EmailAddresses
+	}
+
+	dnsNames := []string{}
+	if cert.DNSNames != nil {
+		dnsNames = cert.DNSNames
+	}
+
+	uris := []string{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	// validity times of templates are not yet truncated to seconds (and
+	// in UTC) like those encoded in (and parsed from) certificates are
+	notBefore := cert.NotBefore.UTC().Truncate(time.Second)
+	notAfter := cert.NotAfter.UTC().Truncate(time.Second)
+
+	return IssuedCertificate{
+		SerialNumber:   cert.SerialNumber.String(),
+		Issuer:         cert.Issuer.String(),
+		Subject:        cert.Subject.String(),
+		NotBefore:      notBefore.String(),
+		NotAfter:       notAfter.String(),
+		IPAddresses:    ipAddresses,
+		DNSNames:       dnsNames,
+		EmailAddresses: emails,
+		URIs:           uris,
+		Raw:            string(certPEM),
+	}
 }
@@ -0,0 +1,317 @@
+package auditor
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/ca/src/signer"
+)
+
+const (
+	defaultCheckpointEvery    = 100
+	defaultCheckpointInterval = time.Hour
+)
+
+// genesisHash is the previous entry hash of the first entry of a hash-chained audit log.
+var genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// FileLogEntry represents a line of a hash-chained audit log: either an audit event or a
+// signed checkpoint. Every entry carries the hex-encoded SHA-256 of the previous line (as
+// written, without the trailing newline) and a sequence number incremented by one per line.
+type FileLogEntry struct {
+	Seq        uint64      `json:"seq"`
+	PrevHash   string      `json:"prev_hash"`
+	Event      *Event      `json:"event,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// Checkpoint represents a signature over the sequence number and previous
+// entry hash of a checkpoint entry, and therefore over the whole log before it.
+type Checkpoint struct {
+	Timestamp          int64  `json:"timestamp"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	Signature          []byte `json:"signature"`
+}
+
+// FileAuditor is an implementation of the Auditor interface which appends audit events to
+// a local hash-chained JSON lines file, for a tamper-evident audit trail outside of AWS (see
+// VerifyFileLog). Checkpoints signed with the given signer are appended every given number of
+// events, periodically if there are unsigned events, and when the FileAuditor is closed.
+type FileAuditor struct {
+	mu                 sync.Mutex
+	file               *os.File
+	seq                uint64
+	prevHash           string
+	unsigned           int
+	signer             crypto.Signer
+	signatureAlgorithm x509.SignatureAlgorithm
+	checkpointEvery    int
+	checkpointInterval time.Duration
+
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+// ensure FileAuditor implements Auditor.
+var _ Auditor = (*FileAuditor)(nil)
+
+// FileOption represents a configuration option for the FileAuditor.
+type FileOption func(*FileAuditor)
+
+// WithCheckpointEvery sets the number of audit events after which a checkpoint is appended.
+func WithCheckpointEvery(n int) FileOption {
+	return func(f *FileAuditor) { f.checkpointEvery = n }
+}
+
+// WithCheckpointInterval sets the maximum time audit events remain without a checkpoint.
+func WithCheckpointInterval(d time.Duration) FileOption {
+	return func(f *FileAuditor) { f.checkpointInterval = d }
+}
+
+// NewFileAuditor returns a hash-chained local file implementation of the Auditor interface,
+// resuming the chain of an existing file (after discarding any partially written last entry).
+func NewFileAuditor(path string, checkpointSigner crypto.Signer, opts ...FileOption) (*FileAuditor, error) {
+	signatureAlgorithm, err := checkpointSignatureAlgorithm(checkpointSigner)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileAuditor{
+		prevHash:           genesisHash,
+		signer:             checkpointSigner,
+		signatureAlgorithm: signatureAlgorithm,
+		checkpointEvery:    defaultCheckpointEvery,
+		checkpointInterval: defaultCheckpointInterval,
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if f.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return nil, fmt.Errorf("failed to open audit log file: %v", err)
+	}
+	if err = f.resume(); err != nil {
+		f.file.Close()
+		return nil, err
+	}
+
+	go f.checkpointPeriodically()
+	return f, nil
+}
+
+// resume reads the existing entries (if any) to continue their chain.
+func (f *FileAuditor) resume() error {
+	reader := bufio.NewReader(f.file)
+	var size int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// entries are written (and synced) one line at a time, so only the last line can be
+			// partially written, by a crash while writing it. Its write never returned, so the
+			// event was never acknowledged, and it is truncated for the chain to continue.
+			if len(data) > 0 {
+				log.Printf("discarding partially written entry at line %d of audit log file", line)
+				if err = f.file.Truncate(size); err != nil {
+					return fmt.Errorf("failed to truncate partially written audit log entry: %v", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log file: %v", err)
+		}
+		size += int64(len(data))
+		data = data[:len(data)-1]
+		var entry FileLogEntry
+		if err = json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("failed to json-decode line %d of audit log file: %v", line, err)
+		}
+		f.seq = entry.Seq + 1
+		f.prevHash = hashEntry(data)
+		if entry.Checkpoint != nil {
+			f.unsigned = 0
+		} else {
+			f.unsigned++
+		}
+	}
+}
+
+// Audit appends an audit event to the log, followed by a checkpoint if one is due.
+func (f *FileAuditor) Audit(e *Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.append(&FileLogEntry{Event: e}); err != nil {
+		return err
+	}
+	f.unsigned++
+	if f.checkpointEvery > 0 && f.unsigned >= f.checkpointEvery {
+		// the event is durably recorded, the checkpoint is retried on the next event
+		if err := f.checkpoint(); err != nil {
+			log.Printf("failed to checkpoint audit log: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close appends a final checkpoint (if there are unsigned events) and closes the underlying file.
+func (f *FileAuditor) Close(ctx context.Context) {
+	f.closed.Do(func() { close(f.stop) })
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unsigned > 0 {
+		if err := f.checkpoint(); err != nil {
+			log.Printf("failed to checkpoint audit log: %v", err)
+		}
+	}
+	if err := f.file.Close(); err != nil {
+		log.Printf("failed to close audit log file: %v", err)
+	}
+}
+
+// checkpointPeriodically appends checkpoints until the FileAuditor is closed.
+func (f *FileAuditor) checkpointPeriodically() {
+	defer close(f.done)
+
+	if f.checkpointInterval <= 0 {
+		<-f.stop
+		return
+	}
+	ticker := time.NewTicker(f.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			if f.unsigned > 0 {
+				if err := f.checkpoint(); err != nil {
+					log.Printf("failed to checkpoint audit log: %v", err)
+				}
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+// checkpoint must be called with the lock held.
+func (f *FileAuditor) checkpoint() error {
+	timestamp := time.Now().UnixMilli()
+	signature, err := signCheckpoint(f.signer, f.signatureAlgorithm, checkpointSignedData(f.seq, f.prevHash, timestamp))
+	if err != nil {
+		return fmt.Errorf("failed to sign checkpoint: %v", err)
+	}
+	err = f.append(&FileLogEntry{Checkpoint: &Checkpoint{
+		Timestamp:          timestamp,
+		SignatureAlgorithm: f.signatureAlgorithm.String(),
+		Signature:          signature,
+	}})
+	if err != nil {
+		return err
+	}
+	f.unsigned = 0
+	return nil
+}
+
+// append must be called with the lock held.
+func (f *FileAuditor) append(entry *FileLogEntry) error {
+	entry.Seq = f.seq
+	entry.PrevHash = f.prevHash
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode audit log entry: %v", err)
+	}
+	if _, err = f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log entry: %v", err)
+	}
+	if err = f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log file: %v", err)
+	}
+	f.seq++
+	f.prevHash = hashEntry(data)
+	return nil
+}
+
+func hashEntry(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func checkpointSignedData(seq uint64, prevHash string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("audit log checkpoint\nseq: %d\nprev_hash: %s\ntimestamp: %d\n", seq, prevHash, timestamp))
+}
+
+// checkpointSignatureAlgorithm returns the signature algorithm a signer is bound
+// to, or the customary signature algorithm for the type of its public key.
+func checkpointSignatureAlgorithm(s crypto.Signer) (x509.SignatureAlgorithm, error) {
+	if algorithm := signer.SignatureAlgorithm(s); algorithm != x509.UnknownSignatureAlgorithm {
+		return algorithm, nil
+	}
+	switch pub := s.Public().(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384, nil
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512, nil
+		default:
+			return x509.ECDSAWithSHA256, nil
+		}
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported checkpoint signer public key type %T", pub)
+	}
+}
+
+func signCheckpoint(s crypto.Signer, algorithm x509.SignatureAlgorithm, data []byte) ([]byte, error) {
+	var hash crypto.Hash
+	switch algorithm {
+	case x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.ECDSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	case x509.PureEd25519:
+		return s.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported checkpoint signature algorithm %s", algorithm)
+	}
+	h := hash.New()
+	h.Write(data)
+	var opts crypto.SignerOpts = hash
+	switch algorithm {
+	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	return s.Sign(rand.Reader, h.Sum(nil), opts)
+}
@@ -0,0 +1,105 @@
+package auditor
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// checkpointSignatureAlgorithms are the signature algorithms checkpoints may be signed with.
+var checkpointSignatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+	x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+	x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512,
+	x509.PureEd25519,
+}
+
+// FileLogError represents the first inconsistency found in a hash-chained audit log.
+type FileLogError struct {
+	Line   int
+	Reason string
+}
+
+// Error returns the error message.
+func (e *FileLogError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// FileLogReport represents the outcome of the verification of a hash-chained audit log.
+type FileLogReport struct {
+	Entries     int
+	Events      int
+	Checkpoints int
+	// LastCheckpointLine is the line of the last valid checkpoint (zero if none), entries
+	// after it are only protected by the hash chain until the next checkpoint is appended.
+	LastCheckpointLine int
+	// SignaturesVerified is false if no public key was given to verify checkpoints with.
+	SignaturesVerified bool
+}
+
+// VerifyFileLog walks a hash-chained audit log written by a FileAuditor and returns a
+// *FileLogError describing the first broken link, missing entry, or invalid checkpoint.
+// Checkpoint signatures are verified against the given public key, if not nil.
+func VerifyFileLog(r io.Reader, pub crypto.PublicKey) (*FileLogReport, error) {
+	report := &FileLogReport{SignaturesVerified: pub != nil}
+	reader := bufio.NewReader(r)
+	prevHash := genesisHash
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				return report, &FileLogError{Line: line, Reason: "partially written entry"}
+			}
+			return report, nil
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		data = data[:len(data)-1]
+
+		var entry FileLogEntry
+		if err = json.Unmarshal(data, &entry); err != nil {
+			return report, &FileLogError{Line: line, Reason: fmt.Sprintf("malformed entry: %v", err)}
+		}
+		if expected := uint64(report.Entries); entry.Seq != expected {
+			return report, &FileLogError{Line: line, Reason: fmt.Sprintf("missing entry: expected seq %d but found %d", expected, entry.Seq)}
+		}
+		if entry.PrevHash != prevHash {
+			return report, &FileLogError{Line: line, Reason: fmt.Sprintf("broken link: prev_hash %s does not match the previous entry hash %s", entry.PrevHash, prevHash)}
+		}
+		switch {
+		case entry.Event != nil && entry.Checkpoint == nil:
+			report.Events++
+		case entry.Checkpoint != nil && entry.Event == nil:
+			if pub != nil {
+				if err = verifyCheckpoint(pub, &entry); err != nil {
+					return report, &FileLogError{Line: line, Reason: fmt.Sprintf("invalid checkpoint: %v", err)}
+				}
+			}
+			report.Checkpoints++
+			report.LastCheckpointLine = line
+		default:
+			return report, &FileLogError{Line: line, Reason: "entry must have either an event or a checkpoint"}
+		}
+		report.Entries++
+		prevHash = hashEntry(data)
+	}
+}
+
+func verifyCheckpoint(pub crypto.PublicKey, entry *FileLogEntry) error {
+	algorithm := x509.UnknownSignatureAlgorithm
+	for _, candidate := range checkpointSignatureAlgorithms {
+		if candidate.String() == entry.Checkpoint.SignatureAlgorithm {
+			algorithm = candidate
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %q", entry.Checkpoint.SignatureAlgorithm)
+	}
+	signed := checkpointSignedData(entry.Seq, entry.PrevHash, entry.Checkpoint.Timestamp)
+	return (&x509.Certificate{PublicKey: pub}).CheckSignature(algorithm, signed, entry.Checkpoint.Signature)
+}
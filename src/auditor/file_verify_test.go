@@ -0,0 +1,223 @@
+package auditor
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFileLog(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+
+	// lines: event 0, event 1, checkpoint, event 2, event 3, checkpoint
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := NewFileAuditor(path, key, WithCheckpointEvery(2), WithCheckpointInterval(0))
+	if err != nil {
+		t.Fatalf("failed to create file auditor: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err = f.Audit(NewAuthenticationFailedEvent(Client{Principal: fmt.Sprintf("client-%d", i)}, Request{}, Failure{})); err != nil {
+			t.Fatalf("failed to audit event: %v", err)
+		}
+	}
+	f.Close(context.Background())
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines = lines[:len(lines)-1]
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 audit log lines, got: %d", len(lines))
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		pub    crypto.PublicKey
+		// the line and a substring of the reason of the expected *FileLogError, zero if none
+		line   int
+		reason string
+	}{
+		{
+			name:   "intact",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+			pub:    &key.PublicKey,
+		},
+		{
+			name: "modified event",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("client-1"), []byte("client-9"), 1)
+				return lines
+			},
+			pub:    &key.PublicKey,
+			line:   3,
+			reason: "broken link",
+		},
+		{
+			name: "modified event with the chain rewritten",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("client-1"), []byte("client-9"), 1)
+				return rechain(t, lines)
+			},
+			pub:    &key.PublicKey,
+			line:   3,
+			reason: "invalid checkpoint",
+		},
+		{
+			// only checkpoint signatures protect against a rewritten chain
+			name: "modified event with the chain rewritten without a key",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("client-1"), []byte("client-9"), 1)
+				return rechain(t, lines)
+			},
+		},
+		{
+			name: "deleted entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1:1], lines[2:]...)
+			},
+			pub:    &key.PublicKey,
+			line:   2,
+			reason: "missing entry",
+		},
+		{
+			// truncation at a checkpoint is indistinguishable from a log which ends there
+			name: "deleted last entries",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:4]
+			},
+			pub: &key.PublicKey,
+		},
+		{
+			name: "reordered entries",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+			pub:    &key.PublicKey,
+			line:   1,
+			reason: "missing entry",
+		},
+		{
+			name:   "checkpoint signed with another key",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+			pub:    &otherKey.PublicKey,
+			line:   3,
+			reason: "invalid checkpoint",
+		},
+		{
+			name: "partially written entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[5] = lines[5][:len(lines[5])/2]
+				return lines
+			},
+			pub:    &key.PublicKey,
+			line:   6,
+			reason: "partially written entry",
+		},
+		{
+			name: "malformed entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[3] = []byte("not json\n")
+				return lines
+			},
+			pub:    &key.PublicKey,
+			line:   4,
+			reason: "malformed entry",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			copied := make([][]byte, len(lines))
+			for i, line := range lines {
+				copied[i] = bytes.Clone(line)
+			}
+			_, err := VerifyFileLog(bytes.NewReader(bytes.Join(test.tamper(copied), nil)), test.pub)
+			if test.line == 0 {
+				if err != nil {
+					t.Fatalf("expected audit log to verify, got: %v", err)
+				}
+				return
+			}
+			var logErr *FileLogError
+			if !errors.As(err, &logErr) {
+				t.Fatalf("expected a *FileLogError, got: %v", err)
+			}
+			if logErr.Line != test.line || !strings.Contains(logErr.Reason, test.reason) {
+				t.Fatalf("expected %q at line %d, got: %v", test.reason, test.line, err)
+			}
+		})
+	}
+}
+
+func TestVerifyFileLogReport(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+
+	// lines: event 0, event 1, checkpoint, event 2 (unsigned until the auditor is closed)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := NewFileAuditor(path, key, WithCheckpointEvery(2), WithCheckpointInterval(0))
+	if err != nil {
+		t.Fatalf("failed to create file auditor: %v", err)
+	}
+	defer f.Close(context.Background())
+	for i := 0; i < 3; i++ {
+		if err = f.Audit(NewAuthenticationFailedEvent(Client{}, Request{}, Failure{})); err != nil {
+			t.Fatalf("failed to audit event: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+
+	report, err := VerifyFileLog(bytes.NewReader(data), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("expected audit log to verify, got: %v", err)
+	}
+	expected := FileLogReport{Entries: 4, Events: 3, Checkpoints: 1, LastCheckpointLine: 3, SignaturesVerified: true}
+	if *report != expected {
+		t.Fatalf("expected report %+v, got: %+v", expected, *report)
+	}
+}
+
+// rechain rewrites the previous entry hashes of audit log lines, as someone without
+// the checkpoint signing key could after tampering with an entry.
+func rechain(t *testing.T, lines [][]byte) [][]byte {
+	t.Helper()
+	prevHash := genesisHash
+	for i, line := range lines {
+		var entry FileLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("failed to json-decode audit log entry: %v", err)
+		}
+		entry.PrevHash = prevHash
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			t.Fatalf("failed to json-encode audit log entry: %v", err)
+		}
+		lines[i] = append(data, '\n')
+		prevHash = hashEntry(data)
+	}
+	return lines
+}
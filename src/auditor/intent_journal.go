@@ -0,0 +1,185 @@
+package auditor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resolution represents the outcome of an issuance intent.
+type Resolution string
+
+const (
+	// ResolutionCompleted resolves intents whose certificate was issued and audited.
+	ResolutionCompleted Resolution = "completed"
+	// ResolutionAborted resolves intents for which no certificate was signed.
+	ResolutionAborted Resolution = "aborted"
+	// ResolutionFlagged resolves intents which were never completed once
+	// flagged with an EventTypeIssuanceUnresolved audit event.
+	ResolutionFlagged Resolution = "flagged"
+)
+
+// IntentJournal represents an entity capable of keeping track of issuance
+// intents (see NewIssuanceIntentEvent) until they are resolved, such that
+// intents which never completed (e.g. the process crashed after signing a
+// certificate, or the completion could not be audited) can be found.
+type IntentJournal interface {
+	Begin(intent *Event) error
+	Resolve(intentID string, resolution Resolution) error
+	// Pending returns the unresolved intents, ordered by time.
+	Pending() ([]*Event, error)
+}
+
+// intentRecord represents a line of the intent journal file.
+type intentRecord struct {
+	IntentID   string     `json:"intent_id"`
+	Resolution Resolution `json:"resolution,omitempty"`
+	Timestamp  int64      `json:"timestamp"`
+	Intent     *Event     `json:"intent,omitempty"`
+}
+
+// FileIntentJournal is a local file implementation of the IntentJournal interface.
+// Unresolved intents are kept in memory and every change is appended to a JSON lines
+// file, which is replayed and compacted (to the unresolved intents only) on startup.
+type FileIntentJournal struct {
+	mu      sync.Mutex
+	pending map[string]*Event
+	file    *os.File
+}
+
+// ensure FileIntentJournal implements IntentJournal.
+var _ IntentJournal = (*FileIntentJournal)(nil)
+
+// NewFileIntentJournal returns a local file implementation of the IntentJournal interface.
+func NewFileIntentJournal(path string) (*FileIntentJournal, error) {
+	pending := make(map[string]*Event)
+
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open intents file: %v", err)
+	}
+	if err == nil {
+		defer existing.Close()
+		reader := bufio.NewReader(existing)
+		for line := 1; ; line++ {
+			data, err := reader.ReadBytes('\n')
+			if errors.Is(err, io.EOF) {
+				// records are written (and synced) one line at a time, so only the last line can
+				// be partially written, by a crash while writing it. Its write never returned, so
+				// it was either a Begin which never proceeded to sign, or a Resolve which leaves
+				// its intent pending (to be flagged), and it is dropped on compaction below.
+				if len(data) > 0 {
+					log.Printf("discarding partially written line %d of intents file", line)
+				}
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read intents file: %v", err)
+			}
+			var r intentRecord
+			if err = json.Unmarshal(data, &r); err != nil {
+				return nil, fmt.Errorf("failed to json-decode line %d of intents file: %v", line, err)
+			}
+			if r.Resolution == "" && r.Intent != nil {
+				pending[r.IntentID] = r.Intent
+			} else {
+				delete(pending, r.IntentID)
+			}
+		}
+	}
+
+	j := &FileIntentJournal{pending: pending}
+	compacted := []byte{}
+	for _, intent := range j.sorted() {
+		data, err := json.Marshal(&intentRecord{IntentID: intent.EventID, Timestamp: intent.Timestamp, Intent: intent})
+		if err != nil {
+			return nil, fmt.Errorf("failed to json-encode intent record: %v", err)
+		}
+		compacted = append(compacted, append(data, '\n')...)
+	}
+	if err = writeFileSync(path, compacted); err != nil {
+		return nil, fmt.Errorf("failed to compact intents file: %v", err)
+	}
+
+	if j.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return nil, fmt.Errorf("failed to open intents file for writing: %v", err)
+	}
+	return j, nil
+}
+
+// Close closes the underlying file.
+func (j *FileIntentJournal) Close() error {
+	return j.file.Close()
+}
+
+// Begin durably records an issuance intent.
+func (j *FileIntentJournal) Begin(intent *Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[intent.EventID]; ok {
+		return fmt.Errorf("intent %s already in journal", intent.EventID)
+	}
+	if err := j.append(&intentRecord{IntentID: intent.EventID, Timestamp: intent.Timestamp, Intent: intent}); err != nil {
+		return err
+	}
+	j.pending[intent.EventID] = intent
+	return nil
+}
+
+// Resolve durably records the resolution of an issuance intent.
+func (j *FileIntentJournal) Resolve(intentID string, resolution Resolution) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[intentID]; !ok {
+		return fmt.Errorf("intent %s is not pending", intentID)
+	}
+	if err := j.append(&intentRecord{IntentID: intentID, Resolution: resolution, Timestamp: time.Now().UnixMilli()}); err != nil {
+		return err
+	}
+	delete(j.pending, intentID)
+	return nil
+}
+
+// Pending returns the unresolved intents, ordered by time.
+func (j *FileIntentJournal) Pending() ([]*Event, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.sorted(), nil
+}
+
+// sorted must be called with the lock held (or before the journal is shared).
+func (j *FileIntentJournal) sorted() []*Event {
+	intents := make([]*Event, 0, len(j.pending))
+	for _, intent := range j.pending {
+		intents = append(intents, intent)
+	}
+	sort.Slice(intents, func(a, b int) bool {
+		return intents[a].Timestamp < intents[b].Timestamp
+	})
+	return intents
+}
+
+// append must be called with the lock held.
+func (j *FileIntentJournal) append(r *intentRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode intent record: %v", err)
+	}
+	if _, err = j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write intent record: %v", err)
+	}
+	if err = j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync intents file: %v", err)
+	}
+	return nil
+}
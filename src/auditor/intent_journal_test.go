@@ -0,0 +1,138 @@
+package auditor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileIntentJournalReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		// journal runs against the journal (with intents a, b and c begun in order)
+		// before it is reopened, and returns anything to append to the file after
+		journal func(t *testing.T, j *FileIntentJournal, a, b, c *Event) []byte
+		// the indexes of the intents (a, b, c) expected to be pending after reopening
+		pending []int
+	}{
+		{
+			name:    "unresolved intents",
+			journal: func(t *testing.T, j *FileIntentJournal, a, b, c *Event) []byte { return nil },
+			pending: []int{0, 1, 2},
+		},
+		{
+			name: "resolved intents",
+			journal: func(t *testing.T, j *FileIntentJournal, a, b, c *Event) []byte {
+				mustResolve(t, j, a.EventID, ResolutionCompleted)
+				mustResolve(t, j, c.EventID, ResolutionAborted)
+				return nil
+			},
+			pending: []int{1},
+		},
+		{
+			name: "partially written begin",
+			journal: func(t *testing.T, j *FileIntentJournal, a, b, c *Event) []byte {
+				mustResolve(t, j, b.EventID, ResolutionFlagged)
+				return []byte(`{"intent_id":"d","timestamp":1,"intent":{"event_id":"d"`)
+			},
+			pending: []int{0, 2},
+		},
+		{
+			name: "partially written resolve",
+			journal: func(t *testing.T, j *FileIntentJournal, a, b, c *Event) []byte {
+				return []byte(`{"intent_id":"` + a.EventID + `","resolution":"compl`)
+			},
+			pending: []int{0, 1, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "intents.jsonl")
+			j, err := NewFileIntentJournal(path)
+			if err != nil {
+				t.Fatalf("failed to create intent journal: %v", err)
+			}
+			intents := []*Event{}
+			for i := 0; i < 3; i++ {
+				intent := NewAuthenticationFailedEvent(Client{}, Request{}, Failure{})
+				intent.Timestamp = int64(i + 1)
+				if err = j.Begin(intent); err != nil {
+					t.Fatalf("failed to begin intent: %v", err)
+				}
+				intents = append(intents, intent)
+			}
+			torn := test.journal(t, j, intents[0], intents[1], intents[2])
+			if err = j.Close(); err != nil {
+				t.Fatalf("failed to close intent journal: %v", err)
+			}
+			if len(torn) > 0 {
+				file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+				if err != nil {
+					t.Fatalf("failed to open intents file: %v", err)
+				}
+				if _, err = file.Write(torn); err != nil {
+					t.Fatalf("failed to write intents file: %v", err)
+				}
+				file.Close()
+			}
+
+			// reopened twice, for the replay of the compacted file to be checked too
+			for reopen := 0; reopen < 2; reopen++ {
+				j, err = NewFileIntentJournal(path)
+				if err != nil {
+					t.Fatalf("failed to reopen intent journal: %v", err)
+				}
+				pending, err := j.Pending()
+				if err != nil {
+					t.Fatalf("failed to get pending intents: %v", err)
+				}
+				expected := []*Event{}
+				for _, i := range test.pending {
+					expected = append(expected, intents[i])
+				}
+				if !reflect.DeepEqual(pending, expected) {
+					t.Fatalf("expected pending intents %v, got: %v", eventIDs(expected), eventIDs(pending))
+				}
+				if err = j.Close(); err != nil {
+					t.Fatalf("failed to close intent journal: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileIntentJournal(t *testing.T) {
+	j, err := NewFileIntentJournal(filepath.Join(t.TempDir(), "intents.jsonl"))
+	if err != nil {
+		t.Fatalf("failed to create intent journal: %v", err)
+	}
+	defer j.Close()
+
+	intent := NewAuthenticationFailedEvent(Client{}, Request{}, Failure{})
+	if err = j.Begin(intent); err != nil {
+		t.Fatalf("failed to begin intent: %v", err)
+	}
+	if err = j.Begin(intent); err == nil {
+		t.Fatal("expected an intent to be begun only once")
+	}
+	mustResolve(t, j, intent.EventID, ResolutionCompleted)
+	if err = j.Resolve(intent.EventID, ResolutionCompleted); err == nil {
+		t.Fatal("expected an intent to be resolved only once")
+	}
+}
+
+func mustResolve(t *testing.T, j *FileIntentJournal, intentID string, resolution Resolution) {
+	t.Helper()
+	if err := j.Resolve(intentID, resolution); err != nil {
+		t.Fatalf("failed to resolve intent: %v", err)
+	}
+}
+
+func eventIDs(events []*Event) []string {
+	ids := []string{}
+	for _, e := range events {
+		ids = append(ids, e.EventID)
+	}
+	return ids
+}
@@ -0,0 +1,118 @@
+package auditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Sink represents an Auditor composed into a MultiAuditor.
+type Sink struct {
+	// Name identifies the sink in errors and logs.
+	Name    string
+	Auditor Auditor
+	// BestEffort sinks' failures are logged rather than failing the audit.
+	BestEffort bool
+	// Timeout bounds how long to wait for the sink to handle an event, if positive.
+	Timeout time.Duration
+}
+
+// SinkError represents the failure of a sink to handle an audit event.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+// Error returns a human-readable description of the failure.
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("auditor %s: %v", e.Sink, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// ErrSinkTimeout is the error of a SinkError for a sink which did not handle an event in time.
+var ErrSinkTimeout = errors.New("timed out handling audit event")
+
+// MultiAuditor is an implementation of the Auditor interface
+// which dispatches every audit event to multiple sinks.
+type MultiAuditor struct {
+	sinks []Sink
+}
+
+// ensure MultiAuditor implements Auditor.
+var _ Auditor = (*MultiAuditor)(nil)
+
+// NewMultiAuditor returns an Auditor which dispatches every audit event to all of the given sinks.
+func NewMultiAuditor(sinks ...Sink) *MultiAuditor {
+	return &MultiAuditor{sinks: sinks}
+}
+
+// Audit handles an audit event by dispatching it to every sink concurrently. It returns the
+// (joined) *SinkError of every required sink which failed, while failures of best-effort sinks
+// are only logged. Sinks which time out keep running in the background, since the Auditor
+// interface does not support cancellation.
+func (m *MultiAuditor) Audit(e *Event) error {
+	results := make([]chan error, len(m.sinks))
+	for i, sink := range m.sinks {
+		results[i] = make(chan error, 1)
+		go func(sink Sink, result chan<- error) {
+			result <- sink.Auditor.Audit(e)
+		}(sink, results[i])
+	}
+
+	var errs []error
+	for i, sink := range m.sinks {
+		err := wait(results[i], sink.Timeout)
+		if err == nil {
+			continue
+		}
+		sinkErr := &SinkError{Sink: sink.Name, Err: err}
+		if sink.BestEffort {
+			log.Printf("best-effort %v (event %s)", sinkErr, e.EventID)
+			continue
+		}
+		errs = append(errs, sinkErr)
+	}
+	return errors.Join(errs...)
+}
+
+// wait returns the result of a sink, or ErrSinkTimeout if it does not return within a (positive) timeout.
+func wait(result <-chan error, timeout time.Duration) error {
+	if timeout <= 0 {
+		return <-result
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return ErrSinkTimeout
+	}
+}
+
+// Backlog returns the number of audit events yet to be delivered by sinks
+// which deliver asynchronously (see SpoolAuditor).
+func (m *MultiAuditor) Backlog() int {
+	backlog := 0
+	for _, sink := range m.sinks {
+		if b, ok := sink.Auditor.(interface{ Backlog() int }); ok {
+			backlog += b.Backlog()
+		}
+	}
+	return backlog
+}
+
+// Close closes every sink which can be closed.
+func (m *MultiAuditor) Close(ctx context.Context) {
+	for _, sink := range m.sinks {
+		if closer, ok := sink.Auditor.(interface{ Close(context.Context) }); ok {
+			closer.Close(ctx)
+		}
+	}
+}
@@ -27,9 +27,14 @@ func NewSlog(w io.Writer, opts *slog.HandlerOptions) *SlogAuditor {
 // Audit handles an audit event.
 func (a *SlogAuditor) Audit(e *Event) error {
 	a.logger.Info(
-		"issued certificate",
+		e.EventType,
+		"event_id", e.EventID,
+		"outcome", e.Outcome,
+		"intent_id", e.IntentID,
 		"client.ip_address", e.Client.IPAddress,
 		"client.user_agent", e.Client.UserAgent,
+		"client.principal", e.Client.Principal,
+		"client.auth_method", e.Client.AuthMethod,
 		"csr.public_key", e.CertificateSigningRequest.PublicKey,
 		"csr.public_key_fingerprint", e.CertificateSigningRequest.PublicKeyFingerprint,
 		"issued_certificate.serial_number", e.IssuedCertificate.SerialNumber,
@@ -41,6 +46,16 @@ func (a *SlogAuditor) Audit(e *Event) error {
 		"issued_certificate.email_addresses", e.IssuedCertificate.EmailAddresses,
 		"issued_certificate.uris", e.IssuedCertificate.URIs,
 		"issued_certificate.raw", e.IssuedCertificate.Raw,
+		"request.method", e.Request.Method,
+		"request.path", e.Request.Path,
+		"request.subject", e.Request.Subject,
+		"request.dns_names", e.Request.DNSNames,
+		"request.email_addresses", e.Request.EmailAddresses,
+		"request.ip_addresses", e.Request.IPAddresses,
+		"request.uris", e.Request.URIs,
+		"failure.category", e.Failure.Category,
+		"failure.status_code", e.Failure.StatusCode,
+		"failure.detail", e.Failure.Detail,
 	)
 	return nil
 }
@@ -0,0 +1,273 @@
+package auditor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSpoolInitialBackoff = time.Second
+	defaultSpoolMaxBackoff     = time.Minute * 5
+
+	spoolFileSuffix    = ".json"
+	spoolCorruptSuffix = ".corrupt"
+	spoolTmpPattern    = ".tmp-*"
+)
+
+// SpoolAuditor is an implementation of the Auditor interface which persists audit events
+// to a local spool directory before acknowledging them, and delivers them to another
+// Auditor in the background (in order, retrying with exponential backoff). Events which
+// are not delivered before the SpoolAuditor is closed are delivered after it is restarted
+// with the same spool directory.
+type SpoolAuditor struct {
+	auditor        Auditor
+	directory      string
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	backlog atomic.Int64
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// ensure SpoolAuditor implements Auditor.
+var _ Auditor = (*SpoolAuditor)(nil)
+
+// SpoolOption represents a configuration option for the SpoolAuditor.
+type SpoolOption func(*SpoolAuditor)
+
+// WithSpoolBackoff sets the initial and maximum delay between delivery attempts.
+func WithSpoolBackoff(initial, max time.Duration) SpoolOption {
+	return func(s *SpoolAuditor) {
+		s.initialBackoff = initial
+		s.maxBackoff = max
+	}
+}
+
+// NewSpoolAuditor returns an Auditor which spools audit events to a directory
+// and delivers them to the given Auditor in the background until closed.
+func NewSpoolAuditor(auditor Auditor, directory string, opts ...SpoolOption) (*SpoolAuditor, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	s := &SpoolAuditor{
+		auditor:        auditor,
+		directory:      directory,
+		initialBackoff: defaultSpoolInitialBackoff,
+		maxBackoff:     defaultSpoolMaxBackoff,
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// remove temporary files left behind by writes interrupted by a crash
+	tmpFiles, err := filepath.Glob(filepath.Join(directory, spoolTmpPattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %v", err)
+	}
+	for _, tmpFile := range tmpFiles {
+		os.Remove(tmpFile)
+	}
+
+	pending, err := s.pending()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %v", err)
+	}
+	s.backlog.Store(int64(len(pending)))
+
+	go s.deliver()
+	return s, nil
+}
+
+// Audit handles an audit event by durably writing it to the spool directory.
+func (s *SpoolAuditor) Audit(e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode audit event: %v", err)
+	}
+
+	// spool file names sort in the order events were spooled
+	name := fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), e.EventID, spoolFileSuffix)
+	s.backlog.Add(1)
+	if err = writeFileSync(filepath.Join(s.directory, name), data); err != nil {
+		s.backlog.Add(-1)
+		return fmt.Errorf("failed to spool audit event: %v", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Backlog returns the number of spooled audit events which are yet to be delivered.
+func (s *SpoolAuditor) Backlog() int {
+	return int(s.backlog.Load())
+}
+
+// Close stops delivering audit events (leaving any backlog in the spool directory)
+// and closes the underlying Auditor, if it can be closed.
+func (s *SpoolAuditor) Close(ctx context.Context) {
+	s.closed.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+	if closer, ok := s.auditor.(interface{ Close(context.Context) }); ok {
+		closer.Close(ctx)
+	}
+}
+
+// deliver delivers spooled audit events until the SpoolAuditor is closed.
+func (s *SpoolAuditor) deliver() {
+	defer close(s.done)
+
+	backoff := s.initialBackoff
+	for {
+		delivered, err := s.deliverPending()
+		if err == nil {
+			backoff = s.initialBackoff
+			if !s.sleep(0) {
+				return
+			}
+			continue
+		}
+		if delivered > 0 {
+			backoff = s.initialBackoff
+		}
+		log.Printf("failed to deliver spooled audit events (backlog %d), retrying in %s: %v", s.Backlog(), backoff, err)
+		if !s.sleep(backoff) {
+			return
+		}
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
+
+// deliverPending delivers every spooled audit event in order, stopping at the first failure.
+func (s *SpoolAuditor) deliverPending() (int, error) {
+	pending, err := s.pending()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read spool directory: %v", err)
+	}
+	for i, name := range pending {
+		select {
+		case <-s.stop:
+			return i, nil
+		default:
+		}
+
+		path := filepath.Join(s.directory, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return i, fmt.Errorf("failed to read spooled audit event: %v", err)
+		}
+		var e Event
+		if err = json.Unmarshal(data, &e); err != nil {
+			// set corrupt events aside rather than blocking delivery forever
+			log.Printf("setting aside corrupt spooled audit event %s: %v", name, err)
+			if err = os.Rename(path, path+spoolCorruptSuffix); err != nil {
+				return i, fmt.Errorf("failed to set aside corrupt spooled audit event: %v", err)
+			}
+			s.backlog.Add(-1)
+			continue
+		}
+		if err = s.auditor.Audit(&e); err != nil {
+			return i, err
+		}
+		if err = os.Remove(path); err != nil {
+			return i, fmt.Errorf("failed to remove delivered audit event from spool: %v", err)
+		}
+		s.backlog.Add(-1)
+	}
+	return len(pending), nil
+}
+
+// sleep waits for the given delay (or for a new event, if zero) and
+// returns false if the SpoolAuditor was closed in the meantime.
+func (s *SpoolAuditor) sleep(delay time.Duration) bool {
+	var timeout <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-s.stop:
+		return false
+	case <-timeout:
+		return true
+	case <-s.wake:
+		// a new event does not cut a backoff short
+		if delay > 0 {
+			select {
+			case <-s.stop:
+				return false
+			case <-timeout:
+			}
+		}
+		return true
+	}
+}
+
+// pending returns the names of the spooled audit events in order.
+func (s *SpoolAuditor) pending() ([]string, error) {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// writeFileSync atomically and durably writes a file by
+// syncing a temporary file and renaming it into place.
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), spoolTmpPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
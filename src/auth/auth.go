@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// Principal represents an authenticated caller.
+type Principal struct {
+	Name   string
+	Method string
+	// BoundNames is set by authenticators which bind issued certificates
+	// to the caller's identity (e.g. OIDC), and nil otherwise.
+	BoundNames *BoundNames
+}
+
+// BoundNames represents the only subject alternative names which may be issued to a
+// principal. Certificates issued with bound names have an empty subject (see
+// template.WithBoundNames), so the subject requested in the CSR is not checked.
+type BoundNames struct {
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+// Check returns an error if a certificate signing request asks for any names which are not bound names.
+func (b *BoundNames) Check(csr *x509.CertificateRequest) error {
+	if len(csr.IPAddresses) > 0 {
+		return fmt.Errorf("ip addresses cannot be bound to the caller's identity")
+	}
+	for _, name := range csr.DNSNames {
+		if !slices.Contains(b.DNSNames, name) {
+			return fmt.Errorf("dns name %q is not bound to the caller's identity", name)
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if !slices.Contains(b.EmailAddresses, email) {
+			return fmt.Errorf("email address %q is not bound to the caller's identity", email)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !slices.ContainsFunc(b.URIs, func(bound *url.URL) bool { return bound.String() == uri.String() }) {
+			return fmt.Errorf("uri %q is not bound to the caller's identity", uri)
+		}
+	}
+	return nil
+}
+
+// Authenticator represents an entity capable of authenticating HTTP requests.
+type Authenticator interface {
+	// Authenticate returns the principal which made a request. Implementations return
+	// ErrNoCredentials when the request carries no credentials of the kind they handle.
+	Authenticate(*http.Request) (*Principal, error)
+}
+
+// ErrNoCredentials is returned by an Authenticator when
+// a request carries no credentials it can verify.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrRequestTooLarge is returned by an Authenticator when a request
+// body it must authenticate exceeds the size it is willing to read.
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// chainAuthenticator is an internal-only implementation of the Authenticator
+// interface which tries multiple authenticators in order.
+type chainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// ensure chainAuthenticator implements Authenticator.
+var _ Authenticator = (*chainAuthenticator)(nil)
+
+// Chain returns an Authenticator which authenticates requests with the first of the
+// given authenticators for which the request carries credentials. Note that requests
+// with invalid credentials of one kind are rejected even if they carry valid credentials
+// of another.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return &chainAuthenticator{authenticators: authenticators}
+}
+
+// Authenticate returns the principal which made a request.
+func (a *chainAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrNoCredentials
+}
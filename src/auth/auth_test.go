@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestBoundNamesCheck(t *testing.T) {
+	bound := &BoundNames{
+		DNSNames:       []string{"a.example.com"},
+		EmailAddresses: []string{"someone@example.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/someone"}},
+	}
+
+	tests := []struct {
+		name    string
+		csr     x509.CertificateRequest
+		wantErr bool
+	}{
+		{
+			name: "no names",
+			csr:  x509.CertificateRequest{},
+		},
+		{
+			name: "bound names",
+			csr: x509.CertificateRequest{
+				DNSNames:       []string{"a.example.com"},
+				EmailAddresses: []string{"someone@example.com"},
+				URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/someone"}},
+			},
+		},
+		{
+			name:    "dns name not bound",
+			csr:     x509.CertificateRequest{DNSNames: []string{"a.example.com", "b.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "dns names compared exactly",
+			csr:     x509.CertificateRequest{DNSNames: []string{"A.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "email address not bound",
+			csr:     x509.CertificateRequest{EmailAddresses: []string{"someone-else@example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "uri not bound",
+			csr:     x509.CertificateRequest{URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/someone-else"}}},
+			wantErr: true,
+		},
+		{
+			name:    "ip addresses never bound",
+			csr:     x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := bound.Check(&test.csr); (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BearerToken represents the configuration of a static bearer token.
+// Only the (hex encoded) SHA-256 hash of the token is ever configured.
+type BearerToken struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// BearerTokenAuthenticator is a static bearer token
+// implementation of the Authenticator interface.
+type BearerTokenAuthenticator struct {
+	tokens []tokenHash
+}
+
+type tokenHash struct {
+	name string
+	hash []byte
+}
+
+// ensure BearerTokenAuthenticator implements Authenticator.
+var _ Authenticator = (*BearerTokenAuthenticator)(nil)
+
+// NewBearerTokenAuthenticator returns a static bearer token implementation of the Authenticator interface.
+func NewBearerTokenAuthenticator(tokens ...BearerToken) (*BearerTokenAuthenticator, error) {
+	a := &BearerTokenAuthenticator{}
+	for _, token := range tokens {
+		hash, err := hex.DecodeString(token.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash for bearer token %q", token.Name)
+		}
+		a.tokens = append(a.tokens, tokenHash{name: token.Name, hash: hash})
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal which made a request.
+func (a *BearerTokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	// compare against every token to not leak which one matched through timing
+	var principal *Principal
+	for _, allowed := range a.tokens {
+		if subtle.ConstantTimeCompare(hash[:], allowed.hash) == 1 {
+			principal = &Principal{Name: allowed.name, Method: "bearer"}
+		}
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	return principal, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import "fmt"
+
+// AuthenticatorConfig represents the configuration
+// of every accepted authentication method for a role.
+type AuthenticatorConfig struct {
+	BearerTokens []BearerToken `yaml:"bearer_tokens"`
+	HMACKeys     []HMACKey     `yaml:"hmac_keys"`
+	MTLS         *MTLSConfig   `yaml:"mtls"`
+	OIDC         *OIDCConfig   `yaml:"oidc"`
+}
+
+// Config represents the authentication configuration of the service.
+type Config struct {
+	// Clients may request certificates.
+	Clients AuthenticatorConfig `yaml:"clients"`
+	// Admins may perform privileged operations e.g. revocation.
+	Admins AuthenticatorConfig `yaml:"admins"`
+}
+
+// Authenticator returns an Authenticator accepting every configured
+// authentication method, or nil if no methods are configured.
+func (c *AuthenticatorConfig) Authenticator() (Authenticator, error) {
+	authenticators := []Authenticator{}
+
+	if c.MTLS != nil {
+		mtls, err := NewMTLSAuthenticatorFromConfig(c.MTLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mtls config: %v", err)
+		}
+		authenticators = append(authenticators, mtls)
+	}
+
+	// must precede static bearer tokens, which would reject id tokens
+	if c.OIDC != nil {
+		oidc, err := NewOIDCAuthenticator(*c.OIDC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oidc config: %v", err)
+		}
+		authenticators = append(authenticators, oidc)
+	}
+
+	if len(c.BearerTokens) > 0 {
+		bearer, err := NewBearerTokenAuthenticator(c.BearerTokens...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bearer tokens config: %v", err)
+		}
+		authenticators = append(authenticators, bearer)
+	}
+
+	if len(c.HMACKeys) > 0 {
+		hmac, err := NewHMACAuthenticator(c.HMACKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hmac keys config: %v", err)
+		}
+		authenticators = append(authenticators, hmac)
+	}
+
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return Chain(authenticators...), nil
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newHMACRequest returns a request signed with the given key at the given time.
+func newHMACRequest(keyID, secret string, timestamp time.Time, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/certificates/sign?profile=client", bytes.NewReader(body))
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(r.Method, r.URL.RequestURI(), unix, body)))
+	r.Header.Set(hmacTimestampHeader, unix)
+	r.Header.Set("Authorization", hmacAuthorizationScheme+" "+keyID+":"+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	authenticator, err := NewHMACAuthenticator(HMACKey{ID: "ci", Secret: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("failed to create hmac authenticator: %v", err)
+	}
+	body := []byte(`{"asn1data":"MIIB"}`)
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		// err is the expected error (matched with errors.Is) if it is a sentinel,
+		// or any error if wantErr is set
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "valid signature",
+			request: func() *http.Request { return newHMACRequest("ci", "s3cr3t", time.Now(), body) },
+		},
+		{
+			name:    "timestamp within the clock skew",
+			request: func() *http.Request { return newHMACRequest("ci", "s3cr3t", time.Now().Add(-4*time.Minute), body) },
+		},
+		{
+			name:    "no credentials",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodPost, "/certificates/sign", nil) },
+			err:     ErrNoCredentials,
+		},
+		{
+			name:    "unknown key id",
+			request: func() *http.Request { return newHMACRequest("other", "s3cr3t", time.Now(), body) },
+			wantErr: true,
+		},
+		{
+			name:    "wrong key",
+			request: func() *http.Request { return newHMACRequest("ci", "other", time.Now(), body) },
+			wantErr: true,
+		},
+		{
+			name: "tampered body",
+			request: func() *http.Request {
+				r := newHMACRequest("ci", "s3cr3t", time.Now(), body)
+				r.Body = io.NopCloser(bytes.NewReader([]byte(`{"asn1data":"MIIC"}`)))
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "tampered query",
+			request: func() *http.Request {
+				r := newHMACRequest("ci", "s3cr3t", time.Now(), body)
+				r.URL.RawQuery = "profile=codesign"
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name:    "expired timestamp",
+			request: func() *http.Request { return newHMACRequest("ci", "s3cr3t", time.Now().Add(-6*time.Minute), body) },
+			wantErr: true,
+		},
+		{
+			name:    "future timestamp",
+			request: func() *http.Request { return newHMACRequest("ci", "s3cr3t", time.Now().Add(6*time.Minute), body) },
+			wantErr: true,
+		},
+		{
+			name: "missing timestamp",
+			request: func() *http.Request {
+				r := newHMACRequest("ci", "s3cr3t", time.Now(), body)
+				r.Header.Del(hmacTimestampHeader)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "body over the size limit",
+			request: func() *http.Request {
+				return newHMACRequest("ci", "s3cr3t", time.Now(), make([]byte, maxHMACBodySize+1))
+			},
+			err: ErrRequestTooLarge,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := authenticator.Authenticate(test.request())
+			switch {
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v, got: %v", test.err, err)
+				}
+			case test.wantErr:
+				if err == nil || errors.Is(err, ErrNoCredentials) || errors.Is(err, ErrRequestTooLarge) {
+					t.Fatalf("expected an authentication error, got: %v", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				if principal.Name != "ci" || principal.Method != "hmac" {
+					t.Fatalf("expected principal hmac:ci, got: %s:%s", principal.Method, principal.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticatorBodyRestored(t *testing.T) {
+	authenticator, err := NewHMACAuthenticator(HMACKey{ID: "ci", Secret: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("failed to create hmac authenticator: %v", err)
+	}
+	body := []byte(`{"asn1data":"MIIB"}`)
+	r := newHMACRequest("ci", "s3cr3t", time.Now(), body)
+	if _, err = authenticator.Authenticate(r); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// handlers must be able to read the authenticated body
+	restored, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("failed to read request body: %v", err)
+	}
+	if !bytes.Equal(restored, body) {
+		t.Fatalf("expected request body %q, got: %q", body, restored)
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Minute * 10
+	minJWKSRefreshInterval     = time.Minute
+	jwksFetchTimeout           = time.Second * 5
+	maxJWKSSize                = 1 << 20
+	jwtClockSkewLeeway         = time.Minute
+)
+
+// OIDCConfig represents the configuration of OIDC (JWT) authentication.
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// exactly one of JWKSFile and JWKSURL must be set
+	JWKSFile string `yaml:"jwks_file"`
+	JWKSURL  string `yaml:"jwks_url"`
+	// claims from which to derive subject alternative names
+	// for issued certificates, empty claims are not used
+	URIClaim   string `yaml:"uri_claim"`
+	URIPrefix  string `yaml:"uri_prefix"`
+	EmailClaim string `yaml:"email_claim"`
+	DNSClaim   string `yaml:"dns_claim"`
+}
+
+// OIDCAuthenticator is an OIDC ID token (JWT) implementation of the Authenticator
+// interface. Principals it authenticates carry the names certificates issued
+// to them are bound to, as derived from their token's claims.
+type OIDCAuthenticator struct {
+	config  OIDCConfig
+	httpClt *http.Client
+
+	mu          sync.Mutex
+	keys        *jose.JSONWebKeySet
+	refreshedAt time.Time
+}
+
+// ensure OIDCAuthenticator implements Authenticator.
+var _ Authenticator = (*OIDCAuthenticator)(nil)
+
+// NewOIDCAuthenticator returns an OIDC ID token (JWT) implementation of the Authenticator interface.
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, fmt.Errorf("issuer and audience must be set")
+	}
+	if (config.JWKSFile == "") == (config.JWKSURL == "") {
+		return nil, fmt.Errorf("exactly one of jwks_file and jwks_url must be set")
+	}
+	if config.URIClaim == "" && config.EmailClaim == "" && config.DNSClaim == "" {
+		return nil, fmt.Errorf("at least one of uri_claim, email_claim and dns_claim must be set")
+	}
+
+	a := &OIDCAuthenticator{
+		config:  config,
+		httpClt: &http.Client{Timeout: jwksFetchTimeout},
+	}
+	if err := a.refreshKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal which made a request.
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed id token: %v", err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, fmt.Errorf("id token must have exactly one signature")
+	}
+	header := parsed.Headers[0]
+
+	key, err := a.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("id token algorithm %q does not match key algorithm %q", header.Algorithm, key.Algorithm)
+	}
+
+	var registered jwt.Claims
+	claims := map[string]interface{}{}
+	if err = parsed.Claims(key.Key, &registered, &claims); err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	if err = registered.ValidateWithLeeway(jwt.Expected{
+		Issuer:   a.config.Issuer,
+		Audience: jwt.Audience{a.config.Audience},
+		Time:     time.Now(),
+	}, jwtClockSkewLeeway); err != nil {
+		return nil, fmt.Errorf("invalid id token claims: %v", err)
+	}
+	if registered.Expiry == nil {
+		return nil, fmt.Errorf("id token has no expiry")
+	}
+
+	names, err := a.boundNames(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Name:       registered.Subject,
+		Method:     "oidc",
+		BoundNames: names,
+	}, nil
+}
+
+func (a *OIDCAuthenticator) boundNames(claims map[string]interface{}) (*BoundNames, error) {
+	names := &BoundNames{}
+
+	if a.config.URIClaim != "" {
+		value, err := stringClaim(claims, a.config.URIClaim)
+		if err != nil {
+			return nil, err
+		}
+		uri, err := url.Parse(a.config.URIPrefix + value)
+		if err != nil || !uri.IsAbs() {
+			return nil, fmt.Errorf("claim %q does not map to an absolute uri", a.config.URIClaim)
+		}
+		names.URIs = append(names.URIs, uri)
+	}
+
+	if a.config.EmailClaim != "" {
+		value, err := stringClaim(claims, a.config.EmailClaim)
+		if err != nil {
+			return nil, err
+		}
+		// only honor the email if the provider says it's verified (when it says anything at all)
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return nil, fmt.Errorf("claim %q is not verified", a.config.EmailClaim)
+		}
+		names.EmailAddresses = append(names.EmailAddresses, value)
+	}
+
+	if a.config.DNSClaim != "" {
+		value, err := stringClaim(claims, a.config.DNSClaim)
+		if err != nil {
+			return nil, err
+		}
+		names.DNSNames = append(names.DNSNames, value)
+	}
+
+	return names, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) (string, error) {
+	value, ok := claims[name].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("id token is missing claim %q", name)
+	}
+	return value, nil
+}
+
+// key returns the key with the given key id, refreshing the key set
+// (at most once per minimum refresh interval) if the key is unknown.
+func (a *OIDCAuthenticator) key(keyID string) (*jose.JSONWebKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stale := time.Since(a.refreshedAt) > defaultJWKSRefreshInterval
+	keys := a.keys.Key(keyID)
+	if (len(keys) == 0 || stale) && time.Since(a.refreshedAt) > minJWKSRefreshInterval {
+		if err := a.refreshKeysLocked(); err != nil {
+			return nil, err
+		}
+		keys = a.keys.Key(keyID)
+	}
+
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("no unique key with id %q in the key set", keyID)
+	}
+	return &keys[0], nil
+}
+
+func (a *OIDCAuthenticator) refreshKeys() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.refreshKeysLocked()
+}
+
+// refreshKeysLocked must be called with the lock held.
+func (a *OIDCAuthenticator) refreshKeysLocked() error {
+	var data []byte
+	var err error
+	if a.config.JWKSFile != "" {
+		if data, err = os.ReadFile(a.config.JWKSFile); err != nil {
+			return fmt.Errorf("failed to read jwks file: %v", err)
+		}
+	} else {
+		resp, err := a.httpClt.Get(a.config.JWKSURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch jwks: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch jwks: unexpected status code %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize)); err != nil {
+			return fmt.Errorf("failed to read jwks: %v", err)
+		}
+	}
+
+	var keys jose.JSONWebKeySet
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to json-decode jwks: %v", err)
+	}
+	for _, key := range keys.Keys {
+		if !key.IsPublic() {
+			return fmt.Errorf("jwks key %q is not a public key", key.KeyID)
+		}
+	}
+
+	a.keys = &keys
+	a.refreshedAt = time.Now()
+	return nil
+}
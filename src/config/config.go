@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/auth"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/keys"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/scep"
+	"github.com/adrianosela/ca/src/signer"
+	"github.com/adrianosela/ca/src/template"
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the configuration of the CA service.
+type Config struct {
+	Server     ServerConfig            `yaml:"server"`
+	Issuer     IssuerConfig            `yaml:"issuer"`
+	Signer     signer.Config           `yaml:"signer"`
+	Storage    StorageConfig           `yaml:"storage"`
+	Revocation RevocationConfig        `yaml:"revocation"`
+	Auditors   []auditor.Config        `yaml:"auditors"`
+	Profiles   template.ProfilesConfig `yaml:"profiles"`
+	Policy     *policy.Policy          `yaml:"policy"`
+	Auth       auth.Config             `yaml:"auth"`
+	ACME       ACMEConfig              `yaml:"acme"`
+	EST        ESTConfig               `yaml:"est"`
+	SCEP       SCEPConfig              `yaml:"scep"`
+}
+
+// ServerConfig represents the configuration of the HTTP server.
+type ServerConfig struct {
+	ListenAddress string     `yaml:"listen_address"`
+	TLS           *TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig represents the TLS configuration of the HTTP server. When TLS is enabled, client
+// certificates are requested (but verified by the service itself) for mTLS authentication
+// and EST re-enrollment.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// IssuerConfig represents the configuration of the issuing CA certificate.
+type IssuerConfig struct {
+	CertificateFile string `yaml:"certificate_file"`
+	// ChainFile holds the certificates above the issuer certificate, only
+	// required when the issuer is an intermediate CA. See issuer.LoadChain.
+	ChainFile string `yaml:"chain_file"`
+	// DebianWeakKeysFiles are Debian openssl-blacklist files of weak RSA
+	// subject keys to reject (see keys.LoadDebianWeakKeys).
+	DebianWeakKeysFiles []string `yaml:"debian_weak_keys_files"`
+}
+
+// StorageConfig represents the configuration of the certificate and revocation
+// stores, and of the journal of issuance intents (see auditor.IntentJournal).
+type StorageConfig struct {
+	CertificatesFile string `yaml:"certificates_file"`
+	RevocationsFile  string `yaml:"revocations_file"`
+	IntentsFile      string `yaml:"intents_file"`
+	// CRLNumberFile persists the last issued CRL number (see revocation.WithNumberFile).
+	CRLNumberFile string `yaml:"crl_number_file"`
+}
+
+// RevocationConfig represents the configuration of CRL and OCSP responses.
+type RevocationConfig struct {
+	CRLNextUpdate         time.Duration `yaml:"crl_next_update"`
+	CRLRegenerateInterval time.Duration `yaml:"crl_regenerate_interval"`
+	OCSPResponseValidity  time.Duration `yaml:"ocsp_response_validity"`
+	OCSPCacheTTL          time.Duration `yaml:"ocsp_cache_ttl"`
+	OCSPCacheSize         int           `yaml:"ocsp_cache_size"`
+	OCSPDelegateLifespan  time.Duration `yaml:"ocsp_delegate_lifespan"`
+}
+
+// ACMEConfig represents the configuration of the ACME (RFC 8555) server.
+type ACMEConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Profile string `yaml:"profile"`
+	BaseURL string `yaml:"base_url"`
+	// ExternalAccountKeys, if any, are required to create accounts (RFC 8555 section 7.3.4).
+	ExternalAccountKeys []ACMEExternalAccountKey `yaml:"external_account_keys"`
+}
+
+// ACMEExternalAccountKey represents an ACME external account binding MAC key,
+// which is given to ACME clients as the key id and (base64url encoded) key.
+type ACMEExternalAccountKey struct {
+	ID  string `yaml:"id"`
+	Key string `yaml:"key"`
+}
+
+// ExternalAccountKeyMap returns the decoded external account binding MAC keys by key id.
+func (c *ACMEConfig) ExternalAccountKeyMap() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, key := range c.ExternalAccountKeys {
+		if key.ID == "" {
+			return nil, errors.New("external account keys must have a non-empty id")
+		}
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate external account key id %q", key.ID)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.Key, "="))
+		if err != nil {
+			return nil, fmt.Errorf("external account key %q is not base64url encoded: %v", key.ID, err)
+		}
+		if len(decoded) < 16 {
+			return nil, fmt.Errorf("external account key %q must be at least 128 bits", key.ID)
+		}
+		keys[key.ID] = decoded
+	}
+	return keys, nil
+}
+
+// ESTConfig represents the configuration of the EST (RFC 7030) endpoints.
+type ESTConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// SCEPConfig represents the configuration of the SCEP (RFC 8894) server.
+type SCEPConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	RALifespan  time.Duration `yaml:"ra_lifespan"`
+	scep.Config `yaml:",inline"`
+}
+
+// Default returns the default configuration, which configuration files override.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress: ":80",
+		},
+		Storage: StorageConfig{
+			CertificatesFile: "certificates.jsonl",
+			RevocationsFile:  "revocations.json",
+			IntentsFile:      "intents.jsonl",
+			CRLNumberFile:    "crl_number",
+		},
+		Revocation: RevocationConfig{
+			CRLNextUpdate:         time.Hour * 24,
+			CRLRegenerateInterval: time.Hour,
+			OCSPResponseValidity:  time.Hour,
+			OCSPCacheTTL:          time.Minute * 5,
+			OCSPCacheSize:         10000,
+			OCSPDelegateLifespan:  time.Hour * 24,
+		},
+		ACME: ACMEConfig{
+			Profile: "server",
+		},
+		SCEP: SCEPConfig{
+			RALifespan: time.Hour * 24 * 30,
+		},
+	}
+}
+
+// Load reads the configuration from a YAML file on top of the defaults,
+// and then applies overrides from (CA_ prefixed) environment variables.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config := Default()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to yaml-decode config file: %v", err)
+	}
+
+	if err = applyEnvOverrides(config, EnvPrefix, os.Environ()); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %v", err)
+	}
+	return config, nil
+}
+
+// Validate checks the configuration (and the files it references) for errors, returning
+// every error found. It does not connect to external services e.g. AWS or PKCS#11 tokens.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(section string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", section, err))
+		}
+	}
+
+	if c.Server.ListenAddress == "" {
+		add("server.listen_address", errors.New("must not be empty"))
+	}
+	if c.Server.TLS != nil {
+		if c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "" {
+			add("server.tls", errors.New("both cert_file and key_file are required"))
+		} else if _, err := tls.LoadX509KeyPair(c.Server.TLS.CertFile, c.Server.TLS.KeyFile); err != nil {
+			add("server.tls", err)
+		}
+	}
+
+	var issuerCert *x509.Certificate
+	var err error
+	if c.Issuer.CertificateFile == "" {
+		add("issuer.certificate_file", errors.New("must not be empty"))
+	} else if issuerCert, err = issuer.LoadCertificate(c.Issuer.CertificateFile); err != nil {
+		add("issuer.certificate_file", err)
+	} else if c.Issuer.ChainFile != "" {
+		_, err = issuer.LoadChain(c.Issuer.ChainFile, issuerCert)
+		add("issuer.chain_file", err)
+	}
+	if _, err := keys.LoadDebianWeakKeys(c.Issuer.DebianWeakKeysFiles...); err != nil {
+		add("issuer.debian_weak_keys_files", err)
+	}
+
+	add("signer", c.Signer.Validate())
+
+	if c.Storage.CertificatesFile == "" {
+		add("storage.certificates_file", errors.New("must not be empty"))
+	}
+	if c.Storage.RevocationsFile == "" {
+		add("storage.revocations_file", errors.New("must not be empty"))
+	}
+	if c.Storage.IntentsFile == "" {
+		add("storage.intents_file", errors.New("must not be empty"))
+	}
+	if c.Storage.CRLNumberFile == "" {
+		add("storage.crl_number_file", errors.New("must not be empty"))
+	}
+
+	for name, d := range map[string]time.Duration{
+		"crl_next_update":         c.Revocation.CRLNextUpdate,
+		"crl_regenerate_interval": c.Revocation.CRLRegenerateInterval,
+		"ocsp_response_validity":  c.Revocation.OCSPResponseValidity,
+		"ocsp_cache_ttl":          c.Revocation.OCSPCacheTTL,
+		"ocsp_delegate_lifespan":  c.Revocation.OCSPDelegateLifespan,
+	} {
+		if d <= 0 {
+			add("revocation."+name, errors.New("must be positive"))
+		}
+	}
+	if c.Revocation.OCSPCacheSize < 0 {
+		add("revocation.ocsp_cache_size", errors.New("must not be negative"))
+	}
+
+	if len(c.Auditors) == 0 {
+		add("auditors", errors.New("at least one auditor must be configured"))
+	}
+	spoolDirectories := map[string]bool{}
+	for i := range c.Auditors {
+		add(fmt.Sprintf("auditors[%d]", i), c.Auditors[i].Validate())
+		if spool := c.Auditors[i].Spool; spool != nil && spool.Directory != "" {
+			if spoolDirectories[filepath.Clean(spool.Directory)] {
+				add(fmt.Sprintf("auditors[%d].spool.directory", i), errors.New("must not be shared with another auditor"))
+			}
+			spoolDirectories[filepath.Clean(spool.Directory)] = true
+		}
+	}
+
+	profiles, err := template.NewRegistry(&c.Profiles)
+	add("profiles", err)
+	if err == nil {
+		if defaultProfile, _ := profiles.Resolve(""); template.IsCA(defaultProfile) {
+			add("profiles.default", errors.New("must not be a CA profile"))
+		}
+		for _, name := range profiles.Names() {
+			if profile := c.Profiles.Profiles[name]; profile.CA != nil && issuerCert != nil {
+				add(fmt.Sprintf("profiles.%s.ca.max_path_len", name), issuer.CheckPathLen(issuerCert, profile.CA.MaxPathLen))
+			}
+		}
+	}
+	if err == nil && c.ACME.Enabled {
+		acmeProfile, err := profiles.Resolve(c.ACME.Profile)
+		add("acme.profile", err)
+		if err == nil && template.IsCA(acmeProfile) {
+			add("acme.profile", errors.New("must not be a CA profile"))
+		}
+	}
+
+	if c.Policy != nil {
+		if err = c.Policy.Compile(); err != nil {
+			add("policy", err)
+		} else if c.Profiles.HasCAProfile() {
+			// name constraints are only derived for CA profiles (see policy.NameConstraints)
+			_, err = c.Policy.NameConstraints()
+			add("policy", err)
+		}
+	}
+
+	clientAuthenticator, err := c.Auth.Clients.Authenticator()
+	add("auth.clients", err)
+	if c.ACME.Enabled {
+		externalAccountKeys, err := c.ACME.ExternalAccountKeyMap()
+		add("acme.external_account_keys", err)
+		if err == nil && len(externalAccountKeys) == 0 && clientAuthenticator != nil {
+			add("acme.external_account_keys", errors.New(
+				"must be configured when auth.clients is, since ACME accounts would otherwise bypass client authentication",
+			))
+		}
+	}
+	_, err = c.Auth.Admins.Authenticator()
+	add("auth.admins", err)
+
+	if c.SCEP.Enabled {
+		if c.SCEP.RALifespan <= 0 {
+			add("scep.ra_lifespan", errors.New("must be positive"))
+		}
+		_, err = c.SCEP.ChallengeStore()
+		add("scep.challenge_passwords", err)
+	}
+
+	return errors.Join(errs...)
+}
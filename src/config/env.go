@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of environment variables overriding configuration values.
+//
+// Every scalar (string, bool, number or duration) value can be overridden by the
+// environment variable named after its path in the YAML file, upper-cased and
+// joined with underscores e.g. CA_SERVER_LISTEN_ADDRESS or CA_SIGNER_KMS_KEY_ID.
+// Lists and maps (e.g. auditors and profiles) can only be set in the file, and
+// environment variables naming them are rejected rather than ignored.
+const EnvPrefix = "CA"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnvOverrides sets the fields of a (pointer to a) struct from
+// environment variables, given in the "key=value" form of os.Environ.
+func applyEnvOverrides(config interface{}, prefix string, environ []string) error {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+	return applyEnv(reflect.ValueOf(config).Elem(), prefix, env)
+}
+
+func applyEnv(v reflect.Value, prefix string, env map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || tag[0] == "-" {
+			continue
+		}
+
+		value := v.Field(i)
+		if len(tag) > 1 && tag[1] == "inline" {
+			if err := applyEnv(value, prefix, env); err != nil {
+				return err
+			}
+			continue
+		}
+		if tag[0] == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag[0])
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if err := applyEnv(value, name, env); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct:
+			// only allocate optional sections which are actually overridden
+			if !hasKeyWithPrefix(env, name+"_") {
+				continue
+			}
+			if value.IsNil() {
+				value.Set(reflect.New(field.Type.Elem()))
+			}
+			if err := applyEnv(value.Elem(), name, env); err != nil {
+				return err
+			}
+		default:
+			raw, ok := env[name]
+			if !ok {
+				continue
+			}
+			if err := setScalar(value, raw); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setScalar(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setScalar(v.Elem(), raw)
+	default:
+		return fmt.Errorf("%s values cannot be overridden by environment variables, only set in the configuration file", v.Kind())
+	}
+	return nil
+}
+
+func hasKeyWithPrefix(env map[string]string, prefix string) bool {
+	for key := range env {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
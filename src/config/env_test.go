@@ -0,0 +1,50 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	type section struct {
+		Address string        `yaml:"address"`
+		Timeout time.Duration `yaml:"timeout"`
+		Names   []string      `yaml:"names"`
+	}
+	type testConfig struct {
+		Section  section           `yaml:"section"`
+		Optional *section          `yaml:"optional"`
+		Labels   map[string]string `yaml:"labels"`
+	}
+
+	tests := []struct {
+		name    string
+		environ []string
+		// err is a substring of the expected error, if any
+		err string
+	}{
+		{name: "scalars", environ: []string{"CA_SECTION_ADDRESS=:8443", "CA_OPTIONAL_TIMEOUT=5s"}},
+		{name: "invalid duration", environ: []string{"CA_SECTION_TIMEOUT=soon"}, err: "CA_SECTION_TIMEOUT"},
+		{name: "slice", environ: []string{"CA_SECTION_NAMES=a,b"}, err: "CA_SECTION_NAMES"},
+		{name: "map", environ: []string{"CA_LABELS=a=b"}, err: "CA_LABELS"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config testConfig
+			err := applyEnvOverrides(&config, EnvPrefix, test.environ)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				if config.Section.Address != ":8443" || config.Optional == nil || config.Optional.Timeout != 5*time.Second {
+					t.Fatalf("expected overridden values, got: %+v (optional: %+v)", config.Section, config.Optional)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error naming %s, got: %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package issuer
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadCertificate reads a (PEM encoded) issuer certificate from a file.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuer certificate file: %v", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM certificate found in issuer certificate file %s", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse issuer certificate: %v", err)
+		}
+		return cert, nil
+	}
+}
+
+// LoadChain reads the (PEM encoded) chain above an issuer certificate from a file,
+// and verifies that every certificate in it was issued by the one following it.
+func LoadChain(path string, issuerCert *x509.Certificate) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chain file: %v", err)
+	}
+
+	chain := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d in chain file: %v", len(chain), err)
+		}
+		chain = append(chain, cert)
+	}
+
+	child := issuerCert
+	for n, parent := range chain {
+		if err := child.CheckSignatureFrom(parent); err != nil {
+			return nil, fmt.Errorf("certificate %d in chain file did not issue the certificate before it: %v", n, err)
+		}
+		child = parent
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,119 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a CA certificate along with its key.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA returns a CA certificate issued by the given parent CA, or a self-signed one if nil.
+func newTestCA(t *testing.T, commonName string, parent *testCA) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	issuerCert, issuerKey := template, key
+	if parent != nil {
+		issuerCert, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func TestLoadChain(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	issuing := newTestCA(t, "issuing", intermediate)
+	other := newTestCA(t, "other root", nil)
+
+	tests := []struct {
+		name   string
+		blocks []*pem.Block
+		// length is the expected chain length, -1 if an error is expected
+		length int
+	}{
+		{name: "intermediate and root", blocks: certBlocks(intermediate, root), length: 2},
+		{name: "intermediate only", blocks: certBlocks(intermediate), length: 1},
+		{
+			name:   "other pem blocks are skipped",
+			blocks: append([]*pem.Block{{Type: "PRIVATE KEY", Bytes: []byte("ignored")}}, certBlocks(intermediate)...),
+			length: 1,
+		},
+		{name: "wrong order", blocks: certBlocks(root, intermediate), length: -1},
+		{name: "missing intermediate", blocks: certBlocks(root), length: -1},
+		{name: "unrelated root", blocks: certBlocks(intermediate, other), length: -1},
+		{name: "invalid certificate", blocks: []*pem.Block{{Type: "CERTIFICATE", Bytes: []byte("not a certificate")}}, length: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chain.pem")
+			data := []byte{}
+			for _, block := range test.blocks {
+				data = append(data, pem.EncodeToMemory(block)...)
+			}
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatalf("failed to write chain file: %v", err)
+			}
+
+			chain, err := LoadChain(path, issuing.cert)
+			if test.length < 0 {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if len(chain) != test.length {
+				t.Fatalf("expected a chain of %d certificates, got: %d", test.length, len(chain))
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadChain(filepath.Join(t.TempDir(), "chain.pem"), issuing.cert); err == nil {
+			t.Fatal("expected an error, got none")
+		}
+	})
+}
+
+// certBlocks returns the PEM blocks of the certificates of the given CAs, in order.
+func certBlocks(cas ...*testCA) []*pem.Block {
+	blocks := []*pem.Block{}
+	for _, ca := range cas {
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	}
+	return blocks
+}
@@ -4,23 +4,82 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/adrianosela/ca/src/keys"
 	"github.com/adrianosela/ca/src/template"
+	"golang.org/x/crypto/ocsp"
 )
 
-// CertificateIssuer represents an entity capable of
-// issuing (DER encoded) signed x509 certificates.
+// oidOCSPNoCheck is the id-pkix-ocsp-nocheck extension (RFC 6960 section 4.2.2.2.1).
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// CertificateIssuer represents an entity capable of issuing (DER encoded)
+// signed x509 certificates, certificate revocation lists and OCSP responses.
 type CertificateIssuer interface {
 	IssuerCertificate() ([]byte, error)
+	Chain() ([][]byte, error)
+	TemplateBuilder() template.CertificateTemplateBuilder
+	CheckPublicKey(crypto.PublicKey) error
 	IssueCertificate(*x509.CertificateRequest) ([]byte, error)
+	IssueCertificateWithBuilder(*x509.CertificateRequest, template.CertificateTemplateBuilder) ([]byte, error)
+	IssueRevocationList(*x509.RevocationList) ([]byte, error)
+	IssueOCSPResponse(ocsp.Response) ([]byte, error)
+	IssueOCSPSigningCertificate(crypto.PublicKey, time.Duration) ([]byte, error)
 }
 
 // issuer is an internal-only implementation of the CertificateIssuer interface.
 type issuer struct {
 	issuerCert      *x509.Certificate
+	chain           []*x509.Certificate
 	signer          crypto.Signer
 	templateBuilder template.CertificateTemplateBuilder
+	keyChecker      *keys.Checker
+
+	signatureAlgorithm x509.SignatureAlgorithm
+}
+
+// Option represents a configuration option for the default CertificateIssuer.
+type Option func(*issuer)
+
+// WithChain sets the certificates above the issuer certificate (i.e. any
+// intermediates and optionally the root) in order, starting with the
+// certificate which issued the issuer certificate. See LoadChain.
+func WithChain(chain ...*x509.Certificate) Option {
+	return func(i *issuer) { i.chain = chain }
+}
+
+// WithSignatureAlgorithm sets the signature algorithm of everything the issuer
+// signs, which must be set for signers bound to a single signature algorithm
+// (see signer.SignatureAlgorithm). Defaults to the one Go picks for the key.
+func WithSignatureAlgorithm(signatureAlgorithm x509.SignatureAlgorithm) Option {
+	return func(i *issuer) { i.signatureAlgorithm = signatureAlgorithm }
+}
+
+// WithKeyChecker sets the keys.Checker for subject public keys, e.g. one
+// with a blocklist of weak Debian keys. Defaults to keys.NewChecker().
+func WithKeyChecker(keyChecker *keys.Checker) Option {
+	return func(i *issuer) { i.keyChecker = keyChecker }
+}
+
+// TemplateError is returned when no valid certificate template can be built for
+// a CSR (as opposed to when the certificate could not be signed).
+type TemplateError struct {
+	Err error
+}
+
+// Error returns a human-readable description of the failure.
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TemplateError) Unwrap() error {
+	return e.Err
 }
 
 // ensure issuer implements CertificateIssuer.
@@ -31,12 +90,18 @@ func New(
 	issuerCert *x509.Certificate,
 	signer crypto.Signer,
 	templateBuilder template.CertificateTemplateBuilder,
+	opts ...Option,
 ) CertificateIssuer {
-	return &issuer{
+	i := &issuer{
 		issuerCert:      issuerCert,
 		signer:          signer,
 		templateBuilder: templateBuilder,
+		keyChecker:      keys.NewChecker(),
 	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
 }
 
 // IssuerCertificate returns the (DER encoded) issuer x509 certificate.
@@ -44,15 +109,57 @@ func (i *issuer) IssuerCertificate() ([]byte, error) {
 	return i.issuerCert.Raw, nil
 }
 
-// IssueCertificate issues a (DER encoded) signed x509 certificate.
+// Chain returns the (DER encoded) issuer x509 certificate followed by the
+// certificates above it, i.e. the chain of every certificate it issues.
+func (i *issuer) Chain() ([][]byte, error) {
+	chain := [][]byte{i.issuerCert.Raw}
+	for _, cert := range i.chain {
+		chain = append(chain, cert.Raw)
+	}
+	return chain, nil
+}
+
+// TemplateBuilder returns the issuer's default CertificateTemplateBuilder.
+func (i *issuer) TemplateBuilder() template.CertificateTemplateBuilder {
+	return i.templateBuilder
+}
+
+// CheckPublicKey returns a *keys.RejectedKeyError if the issuer
+// will not certify a subject public key (see keys.Checker).
+func (i *issuer) CheckPublicKey(pub crypto.PublicKey) error {
+	return i.keyChecker.Check(pub)
+}
+
+// IssueCertificate issues a (DER encoded) signed x509 certificate
+// using the issuer's default CertificateTemplateBuilder.
 func (i *issuer) IssueCertificate(csr *x509.CertificateRequest) ([]byte, error) {
+	return i.IssueCertificateWithBuilder(csr, i.templateBuilder)
+}
+
+// IssueCertificateWithBuilder issues a (DER encoded) signed x509 certificate using the
+// given CertificateTemplateBuilder, or the issuer's default one if the given one is nil.
+// Failures to build the certificate template are returned as a *TemplateError.
+func (i *issuer) IssueCertificateWithBuilder(
+	csr *x509.CertificateRequest,
+	templateBuilder template.CertificateTemplateBuilder,
+) ([]byte, error) {
+	if templateBuilder == nil {
+		templateBuilder = i.templateBuilder
+	}
 	if err := csr.CheckSignature(); err != nil {
 		return nil, fmt.Errorf("failed to verify signature on CSR: %v", err)
 	}
-	template, err := i.templateBuilder.BuildTemplate(csr)
+	if err := i.CheckPublicKey(csr.PublicKey); err != nil {
+		return nil, err
+	}
+	template, err := templateBuilder.BuildTemplate(csr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build x509 certificate template from CSR: %v", err)
+		return nil, &TemplateError{Err: fmt.Errorf("failed to build x509 certificate template from CSR: %v", err)}
 	}
+	if err = i.checkPathLen(template); err != nil {
+		return nil, &TemplateError{Err: err}
+	}
+	template.SignatureAlgorithm = i.signatureAlgorithm
 	derEncodedCert, err := x509.CreateCertificate(
 		rand.Reader,
 		template,
@@ -65,3 +172,113 @@ func (i *issuer) IssueCertificate(csr *x509.CertificateRequest) ([]byte, error)
 	}
 	return derEncodedCert, nil
 }
+
+// IssueRevocationList issues a (DER encoded) signed x509 certificate revocation list.
+func (i *issuer) IssueRevocationList(template *x509.RevocationList) ([]byte, error) {
+	crl := *template
+	crl.SignatureAlgorithm = i.signatureAlgorithm
+	derEncodedCRL, err := x509.CreateRevocationList(
+		rand.Reader,
+		&crl,
+		i.issuerCert,
+		i.signer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create x509 certificate revocation list: %v", err)
+	}
+	return derEncodedCRL, nil
+}
+
+// IssueOCSPResponse issues a (DER encoded) OCSP response signed directly by the issuer.
+func (i *issuer) IssueOCSPResponse(template ocsp.Response) ([]byte, error) {
+	if isRSAPSS(i.signatureAlgorithm) {
+		// golang.org/x/crypto/ocsp does not support RSA-PSS signatures
+		return nil, errors.New("OCSP responses cannot be signed with RSA-PSS, use delegated OCSP signing instead")
+	}
+	template.SignatureAlgorithm = i.signatureAlgorithm
+	derEncodedResponse, err := ocsp.CreateResponse(
+		i.issuerCert,
+		i.issuerCert,
+		template,
+		i.signer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP response: %v", err)
+	}
+	return derEncodedResponse, nil
+}
+
+// IssueOCSPSigningCertificate issues a (DER encoded) delegated OCSP
+// signing certificate (RFC 6960 section 4.2.2.2) for the given public key.
+func (i *issuer) IssueOCSPSigningCertificate(pub crypto.PublicKey, lifespan time.Duration) ([]byte, error) {
+	serialNumber, err := template.NewRandomSerialNumberGenerator().SerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("%s OCSP Responder", i.issuerCert.Subject.CommonName),
+			Organization: i.issuerCert.Subject.Organization,
+			Country:      i.issuerCert.Subject.Country,
+		},
+		NotBefore:   now.Add(-1 * time.Minute),
+		NotAfter:    now.Add(lifespan),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOCSPNoCheck, Value: asn1.NullBytes},
+		},
+		SignatureAlgorithm: i.signatureAlgorithm,
+	}
+	derEncodedCert, err := x509.CreateCertificate(
+		rand.Reader,
+		template,
+		i.issuerCert,
+		pub,
+		i.signer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP signing certificate: %v", err)
+	}
+	return derEncodedCert, nil
+}
+
+// checkPathLen returns an error if a CA certificate template would
+// violate the path length constraint of the issuer certificate.
+func (i *issuer) checkPathLen(template *x509.Certificate) error {
+	if !template.IsCA {
+		return nil
+	}
+	maxPathLen := template.MaxPathLen
+	if maxPathLen == 0 && !template.MaxPathLenZero {
+		maxPathLen = -1
+	}
+	return CheckPathLen(i.issuerCert, maxPathLen)
+}
+
+// CheckPathLen returns an error if the path length constraint of an issuer certificate does
+// not allow issuing CA certificates with the given max path length (negative for no limit).
+func CheckPathLen(issuerCert *x509.Certificate, maxPathLen int) error {
+	limit := issuerCert.MaxPathLen
+	if limit < 0 || (limit == 0 && !issuerCert.MaxPathLenZero) {
+		return nil
+	}
+	if limit == 0 {
+		return errors.New("issuer certificate path length constraint does not allow issuing CA certificates")
+	}
+	if maxPathLen < 0 || maxPathLen >= limit {
+		return fmt.Errorf("CA certificate max path length must be less than the issuer certificate's (%d)", limit)
+	}
+	return nil
+}
+
+func isRSAPSS(signatureAlgorithm x509.SignatureAlgorithm) bool {
+	switch signatureAlgorithm {
+	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return true
+	default:
+		return false
+	}
+}
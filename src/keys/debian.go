@@ -0,0 +1,72 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// debianFingerprintSize is the size of the truncated SHA-1 fingerprints in the
+// Debian openssl-blacklist files i.e. the last 80 bits of the SHA-1 digest.
+const debianFingerprintSize = 10
+
+// DebianWeakKeys is a blocklist of RSA keys generated with the Debian
+// OpenSSL random number generator bug (CVE-2008-0166).
+type DebianWeakKeys map[[debianFingerprintSize]byte]struct{}
+
+// LoadDebianWeakKeys reads blocklist files in the format of the Debian
+// openssl-blacklist package (e.g. /usr/share/openssl-blacklist/blacklist.RSA-2048)
+// i.e. one hex-encoded truncated SHA-1 fingerprint of "Modulus=<HEX>\n" per line.
+func LoadDebianWeakKeys(paths ...string) (DebianWeakKeys, error) {
+	weakKeys := make(DebianWeakKeys)
+	for _, path := range paths {
+		if err := weakKeys.load(path); err != nil {
+			return nil, fmt.Errorf("failed to load debian weak keys file %s: %v", path, err)
+		}
+	}
+	return weakKeys, nil
+}
+
+func (d DebianWeakKeys) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		decoded, err := hex.DecodeString(text)
+		if err != nil || len(decoded) != debianFingerprintSize {
+			return fmt.Errorf("line %d: invalid fingerprint %q", line, text)
+		}
+		var fingerprint [debianFingerprintSize]byte
+		copy(fingerprint[:], decoded)
+		d[fingerprint] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Contains returns true if an RSA public key is in the blocklist.
+func (d DebianWeakKeys) Contains(pub *rsa.PublicKey) bool {
+	if len(d) == 0 {
+		return false
+	}
+	_, ok := d[debianFingerprint(pub)]
+	return ok
+}
+
+func debianFingerprint(pub *rsa.PublicKey) [debianFingerprintSize]byte {
+	digest := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", pub.N)))
+	var fingerprint [debianFingerprintSize]byte
+	copy(fingerprint[:], digest[sha1.Size-debianFingerprintSize:])
+	return fingerprint
+}
@@ -0,0 +1,100 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+)
+
+const (
+	// MinRSABits is the minimum size of RSA subject keys.
+	MinRSABits = 2048
+	// MinRSAExponent is the minimum public exponent of RSA subject keys.
+	MinRSAExponent = 65537
+)
+
+// RejectedKeyError is returned by Checker.Check for unsupported or weak public keys.
+type RejectedKeyError struct {
+	Reason string
+}
+
+// Error returns a human-readable description of why the key was rejected.
+func (e *RejectedKeyError) Error() string {
+	return fmt.Sprintf("public key rejected: %s", e.Reason)
+}
+
+func rejected(format string, args ...interface{}) error {
+	return &RejectedKeyError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Checker rejects subject public keys which are not supported for
+// issuance or are known to be weak. Supported keys are RSA keys of
+// at least MinRSABits, ECDSA keys on P-256, P-384 or P-521 and Ed25519 keys.
+type Checker struct {
+	debianWeakKeys DebianWeakKeys
+}
+
+// Option represents a configuration option for a Checker.
+type Option func(*Checker)
+
+// WithDebianWeakKeys sets the blocklist of RSA keys generated with the
+// Debian OpenSSL random number generator bug (CVE-2008-0166).
+func WithDebianWeakKeys(debianWeakKeys DebianWeakKeys) Option {
+	return func(c *Checker) { c.debianWeakKeys = debianWeakKeys }
+}
+
+// NewChecker returns a new Checker.
+func NewChecker(opts ...Option) *Checker {
+	c := &Checker{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Check returns a *RejectedKeyError if a public key must not be certified.
+func (c *Checker) Check(pub crypto.PublicKey) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return c.checkRSA(pub)
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return rejected("unsupported ecdsa curve %s", pub.Curve.Params().Name)
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return rejected("ecdsa public key is not on curve %s", pub.Curve.Params().Name)
+		}
+		return nil
+	case ed25519.PublicKey:
+		if len(pub) != ed25519.PublicKeySize {
+			return rejected("invalid ed25519 public key size %d", len(pub))
+		}
+		return nil
+	default:
+		return rejected("unsupported public key type %T", pub)
+	}
+}
+
+func (c *Checker) checkRSA(pub *rsa.PublicKey) error {
+	if bits := pub.N.BitLen(); bits < MinRSABits {
+		return rejected("rsa key size %d is smaller than the minimum of %d bits", bits, MinRSABits)
+	}
+	if pub.E < MinRSAExponent || pub.E%2 == 0 {
+		return rejected("rsa public exponent %d must be odd and at least %d", pub.E, MinRSAExponent)
+	}
+	if pub.N.Bit(0) == 0 {
+		return rejected("rsa modulus is even")
+	}
+	if c.debianWeakKeys.Contains(pub) {
+		return rejected("rsa key is a known weak Debian key (CVE-2008-0166)")
+	}
+	if isROCAVulnerable(pub) {
+		return rejected("rsa key is vulnerable to ROCA (CVE-2017-15361)")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckerCheck(t *testing.T) {
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	debianWeak, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	ed25519Public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	// the blocklist is loaded from a file in the openssl-blacklist format
+	fingerprint := debianFingerprint(&debianWeak.PublicKey)
+	blocklist := filepath.Join(t.TempDir(), "blacklist.RSA-2048")
+	if err = os.WriteFile(blocklist, []byte("# comment\n"+hex.EncodeToString(fingerprint[:])+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+	debianWeakKeys, err := LoadDebianWeakKeys(blocklist)
+	if err != nil {
+		t.Fatalf("failed to load blocklist: %v", err)
+	}
+	checker := NewChecker(WithDebianWeakKeys(debianWeakKeys))
+
+	// a modulus congruent to 65537 modulo every ROCA prime has the ROCA fingerprint
+	primorial := big.NewInt(1)
+	for _, p := range rocaPrimes {
+		primorial.Mul(primorial, big.NewInt(p))
+	}
+	rocaModulus := new(big.Int).Lsh(big.NewInt(1), 2048)
+	rocaModulus.Div(rocaModulus, primorial)
+	rocaModulus.SetBit(rocaModulus, 0, 0) // even, such that the modulus is odd
+	rocaModulus.Mul(rocaModulus, primorial)
+	rocaModulus.Add(rocaModulus, big.NewInt(65537))
+
+	offCurve := p256.PublicKey
+	offCurve.Y = new(big.Int).Add(offCurve.Y, big.NewInt(1))
+
+	tests := []struct {
+		name      string
+		publicKey crypto.PublicKey
+		// a substring of the reason the key is rejected for, empty if it must be accepted
+		reason string
+	}{
+		{name: "rsa 2048", publicKey: &rsa2048.PublicKey},
+		{name: "rsa too small", publicKey: &rsa1024.PublicKey, reason: "smaller than the minimum"},
+		{name: "rsa small exponent", publicKey: &rsa.PublicKey{N: rsa2048.N, E: 3}, reason: "exponent"},
+		{name: "rsa even exponent", publicKey: &rsa.PublicKey{N: rsa2048.N, E: 65538}, reason: "exponent"},
+		{name: "rsa even modulus", publicKey: &rsa.PublicKey{N: new(big.Int).Add(rsa2048.N, big.NewInt(1)), E: 65537}, reason: "modulus is even"},
+		{name: "rsa debian weak key", publicKey: &debianWeak.PublicKey, reason: "CVE-2008-0166"},
+		{name: "rsa roca", publicKey: &rsa.PublicKey{N: rocaModulus, E: 65537}, reason: "CVE-2017-15361"},
+		{name: "ecdsa p256", publicKey: &p256.PublicKey},
+		{name: "ecdsa unsupported curve", publicKey: &p224.PublicKey, reason: "unsupported ecdsa curve"},
+		{name: "ecdsa point not on curve", publicKey: &offCurve, reason: "not on curve"},
+		{name: "ed25519", publicKey: ed25519Public},
+		{name: "ed25519 invalid size", publicKey: ed25519.PublicKey(ed25519Public[:16]), reason: "invalid ed25519 public key size"},
+		{name: "unsupported type", publicKey: "not a key", reason: "unsupported public key type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checker.Check(test.publicKey)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatalf("expected key to be accepted, got: %v", err)
+				}
+				return
+			}
+			var rejectedKey *RejectedKeyError
+			if !errors.As(err, &rejectedKey) {
+				t.Fatalf("expected a *RejectedKeyError, got: %v", err)
+			}
+			if !strings.Contains(rejectedKey.Reason, test.reason) {
+				t.Fatalf("expected key to be rejected for %q, got: %v", test.reason, err)
+			}
+		})
+	}
+}
+
+func TestLoadDebianWeakKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "empty", content: ""},
+		{name: "comments and blank lines", content: "# comment\n\n"},
+		{name: "fingerprint", content: "0123456789abcdef0123\n"},
+		{name: "invalid hex", content: "not hex\n", wantErr: true},
+		{name: "invalid length", content: "0123456789\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "blacklist")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatalf("failed to write blocklist: %v", err)
+			}
+			if _, err := LoadDebianWeakKeys(path); (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package keys
+
+import (
+	"crypto/rsa"
+	"math/big"
+)
+
+// rocaPrimes are the small primes of the ROCA (CVE-2017-15361) fingerprint.
+var rocaPrimes = []int64{
+	3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71,
+	73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149,
+	151, 157, 163, 167,
+}
+
+// rocaResidues holds, for each of the rocaPrimes p, the set of residues
+// modulo p of the subgroup generated by 65537, as a bit mask.
+var rocaResidues = func() []*big.Int {
+	residues := make([]*big.Int, len(rocaPrimes))
+	for i, p := range rocaPrimes {
+		mask := new(big.Int)
+		for r := int64(1); mask.Bit(int(r)) == 0; r = r * 65537 % p {
+			mask.SetBit(mask, int(r), 1)
+		}
+		residues[i] = mask
+	}
+	return residues
+}()
+
+// isROCAVulnerable returns true if an RSA modulus was generated by the vulnerable
+// Infineon RSALib (CVE-2017-15361), whose primes are of the form k*M + (65537^a mod M)
+// for a primorial M, such that the modulus is in the subgroup generated by 65537
+// modulo every small prime. The odds of a random modulus matching are negligible.
+func isROCAVulnerable(pub *rsa.PublicKey) bool {
+	residue := new(big.Int)
+	for i, p := range rocaPrimes {
+		residue.Mod(pub.N, big.NewInt(p))
+		if rocaResidues[i].Bit(int(residue.Int64())) == 0 {
+			return false
+		}
+	}
+	return true
+}
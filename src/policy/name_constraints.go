@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adrianosela/ca/src/template"
+)
+
+// NameConstraints returns the name constraints equivalent to the policy's dns_names,
+// ip_addresses, email_domains and uris.hosts rules, such that subordinate CA certificates
+// cannot be used to issue names the policy does not allow, or nil if there are no such rules.
+//
+// Patterns must be either exact domains or of the form "*.<domain>" since name
+// constraints cannot express other patterns. Note that name constraints are not
+// limited to a number of labels i.e. a DNS name constraint "example.com" also matches
+// all of its subdomains and "*.example.com" matches subdomains at any depth, so some
+// allowed patterns permit more as name constraints (see NameConstraintsWidenings).
+// The policy must be compiled.
+func (p *Policy) NameConstraints() (*template.NameConstraints, error) {
+	var err error
+	nameConstraints := &template.NameConstraints{
+		PermittedIPRanges: p.ipAllow,
+		ExcludedIPRanges:  p.ipDeny,
+	}
+	if nameConstraints.PermittedDNSDomains, err = domainConstraints(p.DNSNames.Allow); err != nil {
+		return nil, fmt.Errorf("invalid dns_names.allow name constraint: %v", err)
+	}
+	if nameConstraints.ExcludedDNSDomains, err = domainConstraints(p.DNSNames.Deny); err != nil {
+		return nil, fmt.Errorf("invalid dns_names.deny name constraint: %v", err)
+	}
+	if nameConstraints.PermittedEmailAddresses, err = domainConstraints(p.EmailDomains.Allow); err != nil {
+		return nil, fmt.Errorf("invalid email_domains.allow name constraint: %v", err)
+	}
+	if nameConstraints.ExcludedEmailAddresses, err = domainConstraints(p.EmailDomains.Deny); err != nil {
+		return nil, fmt.Errorf("invalid email_domains.deny name constraint: %v", err)
+	}
+	if nameConstraints.PermittedURIDomains, err = domainConstraints(p.URIs.Hosts.Allow); err != nil {
+		return nil, fmt.Errorf("invalid uris.hosts.allow name constraint: %v", err)
+	}
+	if nameConstraints.ExcludedURIDomains, err = domainConstraints(p.URIs.Hosts.Deny); err != nil {
+		return nil, fmt.Errorf("invalid uris.hosts.deny name constraint: %v", err)
+	}
+
+	if len(nameConstraints.PermittedDNSDomains)+len(nameConstraints.ExcludedDNSDomains)+
+		len(nameConstraints.PermittedIPRanges)+len(nameConstraints.ExcludedIPRanges)+
+		len(nameConstraints.PermittedEmailAddresses)+len(nameConstraints.ExcludedEmailAddresses)+
+		len(nameConstraints.PermittedURIDomains)+len(nameConstraints.ExcludedURIDomains) == 0 {
+		return nil, nil
+	}
+	return nameConstraints, nil
+}
+
+// NameConstraintsWidenings describes the allowed patterns which permit more names as
+// name constraints (see NameConstraints) than they do in the policy: exact domains also
+// permit their subdomains (as verifiers such as Go's crypto/x509 match them), and
+// "*.<domain>" patterns also permit subdomains more than one label below the domain.
+// Denied patterns only ever exclude more, so subordinate CAs may issue names the
+// policy does not allow only for these.
+func (p *Policy) NameConstraintsWidenings() []string {
+	widenings := []string{}
+	for _, rules := range []struct {
+		name     string
+		patterns []string
+	}{
+		{name: "dns_names.allow", patterns: p.DNSNames.Allow},
+		{name: "email_domains.allow", patterns: p.EmailDomains.Allow},
+		{name: "uris.hosts.allow", patterns: p.URIs.Hosts.Allow},
+	} {
+		for _, pattern := range rules.patterns {
+			if strings.HasPrefix(pattern, "*.") {
+				widenings = append(widenings, fmt.Sprintf("%s %q also permits names more than one label below %q", rules.name, pattern, pattern[2:]))
+			} else {
+				widenings = append(widenings, fmt.Sprintf("%s %q also permits all of its subdomains", rules.name, pattern))
+			}
+		}
+	}
+	return widenings
+}
+
+// domainConstraints converts domain patterns to name constraints,
+// where a leading "." constrains subdomains only (RFC 5280 section 4.2.1.10).
+func domainConstraints(patterns []string) ([]string, error) {
+	constraints := []string{}
+	for _, pattern := range patterns {
+		domain := strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if strings.HasPrefix(domain, "*.") {
+			domain = domain[1:]
+		}
+		if strings.ContainsAny(domain, "*?[]\\") {
+			return nil, fmt.Errorf("pattern %q cannot be expressed as a name constraint", pattern)
+		}
+		constraints = append(constraints, domain)
+	}
+	return constraints, nil
+}
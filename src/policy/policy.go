@@ -0,0 +1,387 @@
+package policy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NameRules represents allow and deny lists for a kind of name. A name
+// is denied if it matches any deny entry or, when the allow list is
+// not empty, if it does not match any allow entry.
+type NameRules struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny"  json:"deny"`
+}
+
+// URIRules represents the rules for URI subject alternative names.
+type URIRules struct {
+	Schemes []string  `yaml:"schemes" json:"schemes"`
+	Hosts   NameRules `yaml:"hosts"   json:"hosts"`
+}
+
+// KeyRules represents the rules for subject public keys.
+type KeyRules struct {
+	Types        []string `yaml:"types"          json:"types"`
+	MinRSABits   int      `yaml:"min_rsa_bits"   json:"min_rsa_bits"`
+	MinECDSABits int      `yaml:"min_ecdsa_bits" json:"min_ecdsa_bits"`
+}
+
+// Policy represents a declarative certificate issuance policy.
+type Policy struct {
+	DNSNames     NameRules            `yaml:"dns_names"     json:"dns_names"`
+	IPAddresses  NameRules            `yaml:"ip_addresses"  json:"ip_addresses"`
+	EmailDomains NameRules            `yaml:"email_domains" json:"email_domains"`
+	URIs         URIRules             `yaml:"uris"          json:"uris"`
+	Subject      map[string]NameRules `yaml:"subject"       json:"subject"`
+	Keys         KeyRules             `yaml:"keys"          json:"keys"`
+
+	ipAllow []*net.IPNet
+	ipDeny  []*net.IPNet
+}
+
+// Violation represents a policy rule denying a certificate signing request.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// DenialError is returned by Evaluate when a certificate signing request violates the policy.
+type DenialError struct {
+	Violations []Violation
+}
+
+// Error returns a human-readable description of all violations.
+func (e *DenialError) Error() string {
+	messages := []string{}
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return fmt.Sprintf("certificate signing request denied by policy: %s", strings.Join(messages, "; "))
+}
+
+var subjectAttributes = map[string]func(pkix.Name) []string{
+	"common_name":         func(subject pkix.Name) []string { return nonEmpty(subject.CommonName) },
+	"organization":        func(subject pkix.Name) []string { return subject.Organization },
+	"organizational_unit": func(subject pkix.Name) []string { return subject.OrganizationalUnit },
+	"country":             func(subject pkix.Name) []string { return subject.Country },
+	"province":            func(subject pkix.Name) []string { return subject.Province },
+	"locality":            func(subject pkix.Name) []string { return subject.Locality },
+}
+
+// names represents the subject and subject alternative names evaluated against the policy.
+type names struct {
+	subject        pkix.Name
+	dnsNames       []string
+	ipAddresses    []net.IP
+	emailAddresses []string
+	uris           []*url.URL
+}
+
+// Compile validates the policy and prepares it for evaluation.
+// It must be called before the policy is evaluated.
+func (p *Policy) Compile() error {
+	var err error
+	if p.ipAllow, err = parseCIDRs(p.IPAddresses.Allow); err != nil {
+		return fmt.Errorf("invalid ip_addresses.allow: %v", err)
+	}
+	if p.ipDeny, err = parseCIDRs(p.IPAddresses.Deny); err != nil {
+		return fmt.Errorf("invalid ip_addresses.deny: %v", err)
+	}
+	for attribute := range p.Subject {
+		if _, ok := subjectAttributes[attribute]; !ok {
+			return fmt.Errorf("unknown subject attribute %q", attribute)
+		}
+	}
+	for _, keyType := range p.Keys.Types {
+		switch keyType {
+		case "rsa", "ecdsa", "ed25519":
+		default:
+			return fmt.Errorf("unknown key type %q, must be one of \"rsa\", \"ecdsa\" or \"ed25519\"", keyType)
+		}
+	}
+	patterns := [][]string{
+		p.DNSNames.Allow, p.DNSNames.Deny,
+		p.EmailDomains.Allow, p.EmailDomains.Deny,
+		p.URIs.Hosts.Allow, p.URIs.Hosts.Deny,
+	}
+	for _, rules := range p.Subject {
+		patterns = append(patterns, rules.Allow, rules.Deny)
+	}
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if _, err = path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate checks a certificate signing request against the policy,
+// returning a *DenialError listing every violated rule (if any).
+func (p *Policy) Evaluate(csr *x509.CertificateRequest) error {
+	return p.evaluate(&names{
+		subject:        csr.Subject,
+		dnsNames:       csr.DNSNames,
+		ipAddresses:    csr.IPAddresses,
+		emailAddresses: csr.EmailAddresses,
+		uris:           csr.URIs,
+	}, csr.PublicKey)
+}
+
+// EvaluateTemplate checks the subject and names of a certificate template, i.e. those the
+// certificate is actually issued with (which may not be those requested, see
+// template.WithBoundNames), and the public key of the CSR it was built from against the
+// policy, returning a *DenialError listing every violated rule (if any).
+func (p *Policy) EvaluateTemplate(template *x509.Certificate, csr *x509.CertificateRequest) error {
+	return p.evaluate(&names{
+		subject:        template.Subject,
+		dnsNames:       template.DNSNames,
+		ipAddresses:    template.IPAddresses,
+		emailAddresses: template.EmailAddresses,
+		uris:           template.URIs,
+	}, csr.PublicKey)
+}
+
+func (p *Policy) evaluate(n *names, pub crypto.PublicKey) error {
+	violations := []Violation{}
+
+	for _, name := range n.dnsNames {
+		violations = append(violations, checkNames("dns_names", name, p.DNSNames, matchDNSName)...)
+	}
+
+	for _, ip := range n.ipAddresses {
+		violations = append(violations, p.checkIP(ip)...)
+	}
+
+	// clients which still match host names against the common name would accept
+	// it as a name of the certificate, so it must satisfy the same rules
+	if commonName := n.subject.CommonName; commonName != "" {
+		if ip := net.ParseIP(commonName); ip != nil {
+			if !containsIP(n.ipAddresses, ip) {
+				violations = append(violations, p.checkIP(ip)...)
+			}
+		} else if isHostName(commonName) && !containsFold(n.dnsNames, commonName) {
+			violations = append(violations, checkNames("dns_names", commonName, p.DNSNames, matchDNSName)...)
+		}
+	}
+
+	for _, email := range n.emailAddresses {
+		at := strings.LastIndex(email, "@")
+		if at < 0 {
+			violations = append(violations, Violation{
+				Rule:    "email_domains",
+				Value:   email,
+				Message: fmt.Sprintf("email address %q is malformed", email),
+			})
+			continue
+		}
+		violations = append(violations, checkNames("email_domains", email[at+1:], p.EmailDomains, matchDNSName)...)
+	}
+
+	for _, uri := range n.uris {
+		if len(p.URIs.Schemes) > 0 && !containsFold(p.URIs.Schemes, uri.Scheme) {
+			violations = append(violations, Violation{
+				Rule:    "uris.schemes",
+				Value:   uri.String(),
+				Message: fmt.Sprintf("uri %q has scheme %q which is not allowed", uri, uri.Scheme),
+			})
+		}
+		violations = append(violations, checkNames("uris.hosts", uri.Hostname(), p.URIs.Hosts, matchDNSName)...)
+	}
+
+	attributes := make([]string, 0, len(p.Subject))
+	for attribute := range p.Subject {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	for _, attribute := range attributes {
+		for _, value := range subjectAttributes[attribute](n.subject) {
+			violations = append(violations, checkNames("subject."+attribute, value, p.Subject[attribute], matchGlob)...)
+		}
+	}
+
+	violations = append(violations, p.checkKey(pub)...)
+
+	if len(violations) > 0 {
+		return &DenialError{Violations: violations}
+	}
+	return nil
+}
+
+func (p *Policy) checkIP(ip net.IP) []Violation {
+	for _, ipNet := range p.ipDeny {
+		if ipNet.Contains(ip) {
+			return []Violation{{
+				Rule:    "ip_addresses.deny",
+				Value:   ip.String(),
+				Message: fmt.Sprintf("ip address %s is in denied range %s", ip, ipNet),
+			}}
+		}
+	}
+	if len(p.ipAllow) == 0 {
+		return nil
+	}
+	for _, ipNet := range p.ipAllow {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return []Violation{{
+		Rule:    "ip_addresses.allow",
+		Value:   ip.String(),
+		Message: fmt.Sprintf("ip address %s is not in any allowed range", ip),
+	}}
+}
+
+func (p *Policy) checkKey(pub crypto.PublicKey) []Violation {
+	var keyType string
+	var bits int
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		keyType, bits = "rsa", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		keyType, bits = "ecdsa", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		keyType, bits = "ed25519", 256
+	default:
+		keyType = fmt.Sprintf("%T", pub)
+	}
+
+	if len(p.Keys.Types) > 0 && !containsFold(p.Keys.Types, keyType) {
+		return []Violation{{
+			Rule:    "keys.types",
+			Value:   keyType,
+			Message: fmt.Sprintf("key type %s is not allowed", keyType),
+		}}
+	}
+	if keyType == "rsa" && bits < p.Keys.MinRSABits {
+		return []Violation{{
+			Rule:    "keys.min_rsa_bits",
+			Value:   fmt.Sprintf("%d", bits),
+			Message: fmt.Sprintf("rsa key size %d is smaller than the minimum of %d bits", bits, p.Keys.MinRSABits),
+		}}
+	}
+	if keyType == "ecdsa" && bits < p.Keys.MinECDSABits {
+		return []Violation{{
+			Rule:    "keys.min_ecdsa_bits",
+			Value:   fmt.Sprintf("%d", bits),
+			Message: fmt.Sprintf("ecdsa key size %d is smaller than the minimum of %d bits", bits, p.Keys.MinECDSABits),
+		}}
+	}
+	return nil
+}
+
+func checkNames(rule string, value string, rules NameRules, match func(pattern, value string) bool) []Violation {
+	for _, pattern := range rules.Deny {
+		if match(pattern, value) {
+			return []Violation{{
+				Rule:    rule + ".deny",
+				Value:   value,
+				Message: fmt.Sprintf("%s value %q matches denied pattern %q", rule, value, pattern),
+			}}
+		}
+	}
+	if len(rules.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range rules.Allow {
+		if match(pattern, value) {
+			return nil
+		}
+	}
+	return []Violation{{
+		Rule:    rule + ".allow",
+		Value:   value,
+		Message: fmt.Sprintf("%s value %q does not match any allowed pattern", rule, value),
+	}}
+}
+
+// matchDNSName matches a DNS name against a pattern label by label, such that
+// e.g. "*.example.com" matches "a.example.com" but not "a.b.example.com".
+func matchDNSName(pattern, name string) bool {
+	patternLabels := strings.Split(strings.ToLower(strings.TrimSuffix(pattern, ".")), ".")
+	nameLabels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if len(patternLabels) != len(nameLabels) {
+		return false
+	}
+	for i := range patternLabels {
+		if ok, _ := path.Match(patternLabels[i], nameLabels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchGlob(pattern, value string) bool {
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, item := range list {
+		if item.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostName returns true if a value looks like a (multi-label) DNS name, as opposed
+// to e.g. a person's or service's name, which no client would match as a host name.
+func isHostName(value string) bool {
+	labels := strings.Split(strings.TrimSuffix(value, "."), ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '*') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
@@ -0,0 +1,218 @@
+package policy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	ecdsaP256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	ecdsaP224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	ed25519Public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	p := &Policy{
+		DNSNames:     NameRules{Allow: []string{"*.example.com", "web-*.example.org"}, Deny: []string{"admin.example.com"}},
+		IPAddresses:  NameRules{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/24"}},
+		EmailDomains: NameRules{Allow: []string{"example.com"}},
+		URIs:         URIRules{Schemes: []string{"spiffe"}, Hosts: NameRules{Allow: []string{"*.example.com"}}},
+		Subject: map[string]NameRules{
+			"organization": {Allow: []string{"Example*"}},
+		},
+		Keys: KeyRules{Types: []string{"rsa", "ecdsa"}, MinRSABits: 2048, MinECDSABits: 256},
+	}
+	if err = p.Compile(); err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		csr  x509.CertificateRequest
+		// the rules violated, nil if the csr must be allowed
+		violations []string
+	}{
+		{
+			name: "allowed names",
+			csr: x509.CertificateRequest{
+				Subject:        pkix.Name{CommonName: "a.example.com", Organization: []string{"Example Inc"}},
+				DNSNames:       []string{"a.example.com", "web-1.example.org", "B.EXAMPLE.COM."},
+				IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+				EmailAddresses: []string{"someone@example.com"},
+				URIs:           []*url.URL{{Scheme: "spiffe", Host: "td.example.com", Path: "/workload"}},
+			},
+		},
+		{
+			name:       "dns name not allowed",
+			csr:        x509.CertificateRequest{DNSNames: []string{"a.example.net"}},
+			violations: []string{"dns_names.allow"},
+		},
+		{
+			name:       "dns name wildcard matches one label only",
+			csr:        x509.CertificateRequest{DNSNames: []string{"a.b.example.com"}},
+			violations: []string{"dns_names.allow"},
+		},
+		{
+			name:       "dns name denied",
+			csr:        x509.CertificateRequest{DNSNames: []string{"admin.example.com"}},
+			violations: []string{"dns_names.deny"},
+		},
+		{
+			name:       "ip address denied before allowed",
+			csr:        x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			violations: []string{"ip_addresses.deny"},
+		},
+		{
+			name:       "ip address not allowed",
+			csr:        x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}},
+			violations: []string{"ip_addresses.allow"},
+		},
+		{
+			name:       "email domain not allowed",
+			csr:        x509.CertificateRequest{EmailAddresses: []string{"someone@example.net"}},
+			violations: []string{"email_domains.allow"},
+		},
+		{
+			name:       "malformed email address",
+			csr:        x509.CertificateRequest{EmailAddresses: []string{"someone"}},
+			violations: []string{"email_domains"},
+		},
+		{
+			name:       "uri scheme and host not allowed",
+			csr:        x509.CertificateRequest{URIs: []*url.URL{{Scheme: "https", Host: "example.net"}}},
+			violations: []string{"uris.schemes", "uris.hosts.allow"},
+		},
+		{
+			name:       "subject attribute not allowed",
+			csr:        x509.CertificateRequest{Subject: pkix.Name{Organization: []string{"Other Inc"}}},
+			violations: []string{"subject.organization.allow"},
+		},
+		{
+			name:       "host name common name checked as dns name",
+			csr:        x509.CertificateRequest{Subject: pkix.Name{CommonName: "forbidden.example.net"}},
+			violations: []string{"dns_names.allow"},
+		},
+		{
+			name:       "ip address common name checked as ip address",
+			csr:        x509.CertificateRequest{Subject: pkix.Name{CommonName: "192.168.0.1"}},
+			violations: []string{"ip_addresses.allow"},
+		},
+		{
+			name: "other common name not checked as a name",
+			csr:  x509.CertificateRequest{Subject: pkix.Name{CommonName: "build agent"}},
+		},
+		{
+			name: "every violation is reported",
+			csr: x509.CertificateRequest{
+				DNSNames:    []string{"a.example.net", "admin.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("192.168.0.1")},
+			},
+			violations: []string{"dns_names.allow", "dns_names.deny", "ip_addresses.allow"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.csr.PublicKey = &ecdsaP256.PublicKey
+			assertViolations(t, p.Evaluate(&test.csr), test.violations)
+		})
+	}
+
+	keyTests := []struct {
+		name       string
+		publicKey  crypto.PublicKey
+		violations []string
+	}{
+		{name: "allowed key", publicKey: &ecdsaP256.PublicKey},
+		{name: "key type not allowed", publicKey: ed25519Public, violations: []string{"keys.types"}},
+		{name: "rsa key too small", publicKey: &rsa1024.PublicKey, violations: []string{"keys.min_rsa_bits"}},
+		{name: "ecdsa key too small", publicKey: &ecdsaP224.PublicKey, violations: []string{"keys.min_ecdsa_bits"}},
+	}
+	for _, test := range keyTests {
+		t.Run(test.name, func(t *testing.T) {
+			csr := &x509.CertificateRequest{DNSNames: []string{"a.example.com"}, PublicKey: test.publicKey}
+			assertViolations(t, p.Evaluate(csr), test.violations)
+		})
+	}
+}
+
+func TestEvaluateTemplate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	p := &Policy{DNSNames: NameRules{Allow: []string{"*.example.com"}}}
+	if err = p.Compile(); err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+
+	// the template's names are evaluated rather than those requested
+	csr := &x509.CertificateRequest{DNSNames: []string{"a.example.com"}, PublicKey: &key.PublicKey}
+	template := &x509.Certificate{DNSNames: []string{"a.example.com", "b.example.net"}}
+	assertViolations(t, p.EvaluateTemplate(template, csr), []string{"dns_names.allow"})
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "empty policy", policy: Policy{}},
+		{name: "invalid cidr", policy: Policy{IPAddresses: NameRules{Allow: []string{"10.0.0.0"}}}, wantErr: true},
+		{name: "unknown subject attribute", policy: Policy{Subject: map[string]NameRules{"title": {}}}, wantErr: true},
+		{name: "unknown key type", policy: Policy{Keys: KeyRules{Types: []string{"dsa"}}}, wantErr: true},
+		{name: "invalid pattern", policy: Policy{DNSNames: NameRules{Deny: []string{"[a.example.com"}}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.Compile(); (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+// assertViolations fails the test unless err is a *DenialError with violations of exactly
+// the given rules (in order), or nil if no rules are given.
+func assertViolations(t *testing.T, err error, rules []string) {
+	t.Helper()
+	if rules == nil {
+		if err != nil {
+			t.Fatalf("expected no violations, got: %v", err)
+		}
+		return
+	}
+	var denial *DenialError
+	if !errors.As(err, &denial) {
+		t.Fatalf("expected a *DenialError, got: %v", err)
+	}
+	got := []string{}
+	for _, violation := range denial.Violations {
+		got = append(got, violation.Rule)
+	}
+	if !reflect.DeepEqual(got, rules) {
+		t.Fatalf("expected violations of %v, got: %v", rules, got)
+	}
+}
@@ -0,0 +1,190 @@
+package revocation
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/ca/src/issuer"
+)
+
+const (
+	defaultCRLNextUpdate           = time.Hour * 24
+	defaultCRLRegenerationInterval = time.Hour
+)
+
+// CRLBuilder builds (and caches) certificate revocation lists
+// signed by a CertificateIssuer off of the contents of a Store.
+type CRLBuilder struct {
+	mu sync.Mutex
+
+	iss                  issuer.CertificateIssuer
+	store                Store
+	nextUpdate           time.Duration
+	regenerationInterval time.Duration
+	numberFile           string
+
+	number      *big.Int // the last CRL number issued, nil until loaded
+	crl         []byte
+	generatedAt time.Time
+}
+
+// CRLOption represents a configuration option for the CRLBuilder.
+type CRLOption func(*CRLBuilder)
+
+// WithNextUpdate sets the duration after which published CRLs are
+// considered stale by relying parties (i.e. the nextUpdate field).
+func WithNextUpdate(d time.Duration) CRLOption {
+	return func(b *CRLBuilder) { b.nextUpdate = d }
+}
+
+// WithRegenerationInterval sets the maximum age of the cached CRL
+// before a new one is generated (and signed) to serve requests.
+func WithRegenerationInterval(d time.Duration) CRLOption {
+	return func(b *CRLBuilder) { b.regenerationInterval = d }
+}
+
+// WithNumberFile persists the last issued CRL number to a file, such
+// that CRL numbers keep increasing across service restarts even if
+// CRLs were regenerated faster than once per second.
+func WithNumberFile(path string) CRLOption {
+	return func(b *CRLBuilder) { b.numberFile = path }
+}
+
+// NewCRLBuilder returns a new CRLBuilder.
+func NewCRLBuilder(iss issuer.CertificateIssuer, store Store, opts ...CRLOption) *CRLBuilder {
+	b := &CRLBuilder{
+		iss:                  iss,
+		store:                store,
+		nextUpdate:           defaultCRLNextUpdate,
+		regenerationInterval: defaultCRLRegenerationInterval,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// CRL returns the current (DER encoded) certificate revocation list,
+// generating a new one if the cached one is older than the regeneration
+// interval or has been invalidated by a revocation.
+func (b *CRLBuilder) CRL() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.crl != nil && time.Since(b.generatedAt) < b.regenerationInterval {
+		return b.crl, nil
+	}
+
+	entries, err := b.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revocations: %v", err)
+	}
+
+	revoked := []x509.RevocationListEntry{}
+	for _, entry := range entries {
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   entry.SerialNumber,
+			RevocationTime: entry.RevokedAt,
+			ReasonCode:     int(entry.Reason),
+		})
+	}
+
+	if b.number == nil {
+		if b.number, err = b.loadNumber(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the current time keeps CRL numbers increasing across restarts even
+	// without a number file (unless CRLs were regenerated faster than once
+	// per second), the persisted number covers the remaining cases
+	now := time.Now()
+	number := new(big.Int).Add(b.number, big.NewInt(1))
+	if seconds := big.NewInt(now.Unix()); seconds.Cmp(number) > 0 {
+		number = seconds
+	}
+	// the number is persisted before it is used, such that it is never reused
+	if err = b.persistNumber(number); err != nil {
+		return nil, err
+	}
+	b.number = number
+
+	crl, err := b.iss.IssueRevocationList(&x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(b.nextUpdate),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate revocation list: %v", err)
+	}
+
+	b.crl = crl
+	b.generatedAt = now
+
+	return b.crl, nil
+}
+
+// Invalidate discards the cached CRL such that the next call to CRL regenerates it.
+func (b *CRLBuilder) Invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.crl = nil
+}
+
+// loadNumber returns the last persisted CRL number, or zero if there is none.
+func (b *CRLBuilder) loadNumber() (*big.Int, error) {
+	if b.numberFile == "" {
+		return new(big.Int), nil
+	}
+	data, err := os.ReadFile(b.numberFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return new(big.Int), nil
+		}
+		return nil, fmt.Errorf("failed to read CRL number file: %v", err)
+	}
+	number, ok := new(big.Int).SetString(strings.TrimSpace(string(data)), 10)
+	if !ok || number.Sign() < 0 {
+		return nil, fmt.Errorf("invalid CRL number in CRL number file %s", b.numberFile)
+	}
+	return number, nil
+}
+
+// persistNumber atomically replaces the contents of the CRL number file (if any).
+func (b *CRLBuilder) persistNumber(number *big.Int) error {
+	if b.numberFile == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.numberFile), filepath.Base(b.numberFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary CRL number file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(number.String() + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary CRL number file: %v", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary CRL number file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary CRL number file: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), b.numberFile); err != nil {
+		return fmt.Errorf("failed to replace CRL number file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package revocation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileStore is a local file implementation of the Store interface.
+// Revocations are kept in memory and the full set is persisted
+// (atomically) to a JSON file on every revocation.
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]*Entry
+}
+
+// ensure FileStore implements Store.
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore returns a local file implementation of the Store interface.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read revocations file: %v", err)
+	}
+
+	var entries []*Entry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to json-decode revocations file: %v", err)
+	}
+	for _, entry := range entries {
+		s.entries[entry.SerialNumber.String()] = entry
+	}
+
+	return s, nil
+}
+
+// Revoke records the revocation of a certificate.
+func (s *FileStore) Revoke(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := e.SerialNumber.String()
+	if _, ok := s.entries[key]; ok {
+		return ErrAlreadyRevoked
+	}
+
+	s.entries[key] = e
+	if err := s.persist(); err != nil {
+		delete(s.entries, key)
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the revocation entry for a given certificate serial number.
+func (s *FileStore) Get(serialNumber *big.Int) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.entries[serialNumber.String()], nil
+}
+
+// List returns all revocation entries, ordered by revocation time.
+func (s *FileStore) List() ([]*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sorted(), nil
+}
+
+func (s *FileStore) sorted() []*Entry {
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RevokedAt.Before(entries[j].RevokedAt)
+	})
+	return entries
+}
+
+// persist must be called with the write lock held.
+func (s *FileStore) persist() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to json-encode revocations: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary revocations file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary revocations file: %v", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary revocations file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary revocations file: %v", err)
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace revocations file: %v", err)
+	}
+
+	return nil
+}
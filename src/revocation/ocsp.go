@@ -0,0 +1,397 @@
+package revocation
+
+import (
+	"bytes"
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/store"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultOCSPResponseValidity     = time.Hour
+	defaultOCSPCacheTTL             = time.Minute * 5
+	defaultOCSPCacheSize            = 10000
+	defaultOCSPDelegateCertLifespan = time.Hour * 24
+	// unknownOCSPCacheTTL caps how long "unknown" responses are cached, such
+	// that certificates issued since are soon answered for (see WithCacheTTL).
+	unknownOCSPCacheTTL = time.Minute
+	// ocspResponderPrincipal is the audited principal of delegated OCSP signing certificates.
+	ocspResponderPrincipal = "ocsp-responder"
+)
+
+// OCSPResponder answers RFC 6960 OCSP requests for certificates
+// issued by a CertificateIssuer off of the contents of a Store.
+//
+// Signed responses are cached (in a bounded LRU), and concurrent requests for
+// the same serial number share a single signature, which is made without
+// blocking requests for others.
+type OCSPResponder struct {
+	iss        issuer.CertificateIssuer
+	issuerCert *x509.Certificate
+	store      Store
+	issued     store.CertificateStore
+	auditor    auditor.Auditor
+
+	responseValidity     time.Duration
+	cacheTTL             time.Duration
+	cacheSize            int
+	delegated            bool
+	delegateCertLifespan time.Duration
+
+	signing singleflight.Group
+
+	mu sync.Mutex
+	// cache holds the list elements of the LRU list, most recently used first
+	cache map[string]*list.Element
+	lru   *list.List
+	// generation is incremented on invalidation, such that responses being
+	// built concurrently with an invalidation are neither cached nor shared
+	generation uint64
+
+	delegateMu    sync.Mutex
+	delegateKey   crypto.Signer
+	delegateCert  *x509.Certificate
+	delegateRenew time.Time
+}
+
+type cachedOCSPResponse struct {
+	serialNumber string
+	der          []byte
+	expiresAt    time.Time
+}
+
+// OCSPOption represents a configuration option for the OCSPResponder.
+type OCSPOption func(*OCSPResponder)
+
+// WithResponseValidity sets the duration between the thisUpdate
+// and nextUpdate fields of OCSP responses.
+func WithResponseValidity(d time.Duration) OCSPOption {
+	return func(r *OCSPResponder) { r.responseValidity = d }
+}
+
+// WithCacheTTL sets how long signed OCSP responses are served from memory
+// before being re-signed. A zero TTL disables caching. "Unknown" responses
+// (see WithCertificateStore) are cached for at most a minute.
+func WithCacheTTL(d time.Duration) OCSPOption {
+	return func(r *OCSPResponder) { r.cacheTTL = d }
+}
+
+// WithCacheSize sets the maximum number of signed OCSP responses
+// cached, beyond which the least recently used ones are evicted.
+func WithCacheSize(n int) OCSPOption {
+	return func(r *OCSPResponder) { r.cacheSize = n }
+}
+
+// WithCertificateStore makes the responder answer "unknown" for serial
+// numbers which are not in the inventory of issued certificates, and
+// record the delegated OCSP signing certificates it issues in it.
+func WithCertificateStore(issued store.CertificateStore) OCSPOption {
+	return func(r *OCSPResponder) { r.issued = issued }
+}
+
+// WithAuditor sets the Auditor for the delegated OCSP signing certificates
+// the responder issues (see WithDelegatedSigning).
+func WithAuditor(aud auditor.Auditor) OCSPOption {
+	return func(r *OCSPResponder) { r.auditor = aud }
+}
+
+// WithDelegatedSigning makes the responder sign responses with a locally held key
+// for which the CA issues a delegated OCSP signing certificate (valid for the given
+// lifespan), rather than signing every response directly with the CA key.
+func WithDelegatedSigning(lifespan time.Duration) OCSPOption {
+	return func(r *OCSPResponder) {
+		r.delegated = true
+		r.delegateCertLifespan = lifespan
+	}
+}
+
+// NewOCSPResponder returns a new OCSPResponder.
+func NewOCSPResponder(iss issuer.CertificateIssuer, store Store, opts ...OCSPOption) (*OCSPResponder, error) {
+	issuerCertDER, err := iss.IssuerCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve issuer certificate: %v", err)
+	}
+	issuerCert, err := x509.ParseCertificate(issuerCertDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issuer certificate: %v", err)
+	}
+
+	r := &OCSPResponder{
+		iss:                  iss,
+		issuerCert:           issuerCert,
+		store:                store,
+		responseValidity:     defaultOCSPResponseValidity,
+		cacheTTL:             defaultOCSPCacheTTL,
+		cacheSize:            defaultOCSPCacheSize,
+		delegateCertLifespan: defaultOCSPDelegateCertLifespan,
+		cache:                make(map[string]*list.Element),
+		lru:                  list.New(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// Respond returns the (DER encoded) OCSP response for a (DER encoded) OCSP request.
+// Requests which cannot be parsed or that are for certificates of a different issuer
+// get the corresponding (unsigned) OCSP error responses rather than an error.
+func (r *OCSPResponder) Respond(rawRequest []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(rawRequest)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+
+	ok, err := r.matchesIssuer(req)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, fmt.Errorf("failed to match request issuer: %v", err)
+	}
+	if !ok {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	key := req.SerialNumber.String()
+	r.mu.Lock()
+	cached := r.cached(key)
+	generation := r.generation
+	r.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	resp, err, _ := r.signing.Do(fmt.Sprintf("%d/%s", generation, key), func() (interface{}, error) {
+		resp, known, err := r.buildResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		ttl := r.cacheTTL
+		if !known && ttl > unknownOCSPCacheTTL {
+			// requesters can make up any number of serial numbers, so these are cached
+			// (to not be signed on every request) but not long enough to crowd the cache
+			ttl = unknownOCSPCacheTTL
+		}
+		r.mu.Lock()
+		r.cacheResponse(key, generation, resp, ttl)
+		r.mu.Unlock()
+		return resp, nil
+	})
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, err
+	}
+	return resp.([]byte), nil
+}
+
+// Invalidate discards any cached response for the given serial number.
+func (r *OCSPResponder) Invalidate(serialNumber *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	if element, ok := r.cache[serialNumber.String()]; ok {
+		r.evict(element)
+	}
+}
+
+// cached returns the cached response for a serial number, or nil if there is none
+// (or it expired). It must be called with the lock held.
+func (r *OCSPResponder) cached(key string) []byte {
+	element, ok := r.cache[key]
+	if !ok {
+		return nil
+	}
+	cached := element.Value.(*cachedOCSPResponse)
+	if !time.Now().Before(cached.expiresAt) {
+		r.evict(element)
+		return nil
+	}
+	r.lru.MoveToFront(element)
+	return cached.der
+}
+
+// cacheResponse caches a response built in the given generation for the given TTL, unless it
+// was invalidated since, evicting the least recently used responses beyond the cache size. It
+// must be called with the lock held.
+func (r *OCSPResponder) cacheResponse(key string, generation uint64, der []byte, ttl time.Duration) {
+	if ttl <= 0 || r.cacheSize <= 0 || generation != r.generation {
+		return
+	}
+	if element, ok := r.cache[key]; ok {
+		r.evict(element)
+	}
+	r.cache[key] = r.lru.PushFront(&cachedOCSPResponse{
+		serialNumber: key,
+		der:          der,
+		expiresAt:    time.Now().Add(ttl),
+	})
+	for r.lru.Len() > r.cacheSize {
+		r.evict(r.lru.Back())
+	}
+}
+
+// evict must be called with the lock held.
+func (r *OCSPResponder) evict(element *list.Element) {
+	r.lru.Remove(element)
+	delete(r.cache, element.Value.(*cachedOCSPResponse).serialNumber)
+}
+
+// buildResponse builds and signs the response to a request, and returns whether
+// the serial number is known to have been issued (i.e. the response is not
+// "unknown"). It must not be called with the lock held, since it may sign.
+func (r *OCSPResponder) buildResponse(req *ocsp.Request) ([]byte, bool, error) {
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(r.responseValidity),
+	}
+
+	if r.issued != nil {
+		if _, err := r.issued.Get(req.SerialNumber); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				return nil, false, fmt.Errorf("failed to look up issued certificate: %v", err)
+			}
+			template.Status = ocsp.Unknown
+		}
+	}
+
+	entry, err := r.store.Get(req.SerialNumber)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up revocation status: %v", err)
+	}
+	if entry != nil {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = entry.RevokedAt
+		template.RevocationReason = int(entry.Reason)
+	}
+
+	known := template.Status != ocsp.Unknown
+
+	if !r.delegated {
+		resp, err := r.iss.IssueOCSPResponse(template)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to issue OCSP response: %v", err)
+		}
+		return resp, known, nil
+	}
+
+	delegateKey, delegateCert, err := r.ensureDelegate()
+	if err != nil {
+		return nil, false, err
+	}
+	template.Certificate = delegateCert
+	resp, err := ocsp.CreateResponse(r.issuerCert, delegateCert, template, delegateKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create OCSP response: %v", err)
+	}
+	return resp, known, nil
+}
+
+// ensureDelegate returns the delegated OCSP signing key and certificate, (re)issuing
+// the certificate once half of its lifespan has elapsed. Certificates are recorded
+// and audited (if so configured) before they are used, like any other issuance.
+func (r *OCSPResponder) ensureDelegate() (crypto.Signer, *x509.Certificate, error) {
+	r.delegateMu.Lock()
+	defer r.delegateMu.Unlock()
+
+	if r.delegateCert != nil && time.Now().Before(r.delegateRenew) {
+		return r.delegateKey, r.delegateCert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate OCSP signing key: %v", err)
+	}
+	certDER, err := r.iss.IssueOCSPSigningCertificate(key.Public(), r.delegateCertLifespan)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue OCSP signing certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OCSP signing certificate: %v", err)
+	}
+	if err = r.recordDelegate(key.Public(), cert); err != nil {
+		return nil, nil, err
+	}
+
+	r.delegateKey = key
+	r.delegateCert = cert
+	r.delegateRenew = time.Now().Add(r.delegateCertLifespan / 2)
+
+	// responses signed with the previous key remain valid, but clients
+	// should get the newest certificate as soon as possible
+	r.mu.Lock()
+	r.cache = make(map[string]*list.Element)
+	r.lru.Init()
+	r.mu.Unlock()
+
+	return key, cert, nil
+}
+
+// recordDelegate records a delegated OCSP signing certificate in the inventory
+// of issued certificates and audits its issuance, as far as either is configured.
+func (r *OCSPResponder) recordDelegate(pub crypto.PublicKey, cert *x509.Certificate) error {
+	if r.issued != nil {
+		record, err := store.NewRecord(cert)
+		if err != nil {
+			return fmt.Errorf("failed to build OCSP signing certificate record: %v", err)
+		}
+		if err = r.issued.Put(record); err != nil {
+			return fmt.Errorf("failed to store OCSP signing certificate record: %v", err)
+		}
+	}
+	if r.auditor != nil {
+		// there is no CSR, the responder's key is certified directly
+		event, err := auditor.NewIssuanceEvent(
+			auditor.Client{Principal: ocspResponderPrincipal},
+			&x509.CertificateRequest{PublicKey: pub},
+			cert.Raw,
+			auditor.HTTPRequest{},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to build OCSP signing certificate audit event: %v", err)
+		}
+		if err = r.auditor.Audit(event); err != nil {
+			return fmt.Errorf("failed to audit OCSP signing certificate: %v", err)
+		}
+	}
+	return nil
+}
+
+// matchesIssuer checks whether an OCSP request's issuer name and key hashes match the issuer certificate.
+func (r *OCSPResponder) matchesIssuer(req *ocsp.Request) (bool, error) {
+	if !req.HashAlgorithm.Available() {
+		return false, nil
+	}
+
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(r.issuerCert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false, fmt.Errorf("failed to parse issuer public key info: %v", err)
+	}
+
+	nameHash := req.HashAlgorithm.New()
+	nameHash.Write(r.issuerCert.RawSubject)
+	keyHash := req.HashAlgorithm.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+
+	return bytes.Equal(nameHash.Sum(nil), req.IssuerNameHash) &&
+		bytes.Equal(keyHash.Sum(nil), req.IssuerKeyHash), nil
+}
@@ -0,0 +1,195 @@
+package revocation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
+	"golang.org/x/crypto/ocsp"
+)
+
+// recordingAuditor is an implementation of the Auditor interface which keeps audit events in memory.
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []*auditor.Event
+}
+
+func (r *recordingAuditor) Audit(e *auditor.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// newTestIssuer returns a CertificateIssuer with a self-signed root CA certificate.
+func newTestIssuer(t *testing.T) (issuer.CertificateIssuer, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	return issuer.New(caCert, key, template.New(time.Minute, time.Hour)), caCert
+}
+
+// newOCSPRequest returns a (DER encoded) OCSP request for a serial number issued by a CA.
+func newOCSPRequest(t *testing.T, caCert *x509.Certificate, serialNumber *big.Int) []byte {
+	t.Helper()
+	req, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serialNumber}, caCert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("failed to create ocsp request: %v", err)
+	}
+	return req
+}
+
+func TestOCSPResponderCache(t *testing.T) {
+	iss, caCert := newTestIssuer(t)
+	revocations, err := NewFileStore(filepath.Join(t.TempDir(), "revocations.json"))
+	if err != nil {
+		t.Fatalf("failed to create revocation store: %v", err)
+	}
+	issued := store.NewMemoryStore()
+	known, err := x509.ParseCertificate(mustIssue(t, iss))
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	record, err := store.NewRecord(known)
+	if err != nil {
+		t.Fatalf("failed to build certificate record: %v", err)
+	}
+	if err = issued.Put(record); err != nil {
+		t.Fatalf("failed to store certificate record: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		serialNumber *big.Int
+		status       int
+	}{
+		{name: "issued serial number", serialNumber: known.SerialNumber, status: ocsp.Good},
+		{name: "unknown serial number", serialNumber: big.NewInt(42), status: ocsp.Unknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder, err := NewOCSPResponder(iss, revocations, WithCertificateStore(issued))
+			if err != nil {
+				t.Fatalf("failed to create ocsp responder: %v", err)
+			}
+			first, err := responder.Respond(newOCSPRequest(t, caCert, test.serialNumber))
+			if err != nil {
+				t.Fatalf("failed to respond: %v", err)
+			}
+			resp, err := ocsp.ParseResponse(first, caCert)
+			if err != nil {
+				t.Fatalf("failed to parse ocsp response: %v", err)
+			}
+			if resp.Status != test.status {
+				t.Fatalf("expected ocsp status %d, got: %d", test.status, resp.Status)
+			}
+
+			// ECDSA signatures are randomized, so only a cached response is identical
+			second, err := responder.Respond(newOCSPRequest(t, caCert, test.serialNumber))
+			if err != nil {
+				t.Fatalf("failed to respond: %v", err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Fatal("expected the second response to be served from the cache")
+			}
+		})
+	}
+}
+
+func TestOCSPResponderDelegateIssuance(t *testing.T) {
+	iss, caCert := newTestIssuer(t)
+	revocations, err := NewFileStore(filepath.Join(t.TempDir(), "revocations.json"))
+	if err != nil {
+		t.Fatalf("failed to create revocation store: %v", err)
+	}
+	issued := store.NewMemoryStore()
+	aud := &recordingAuditor{}
+	responder, err := NewOCSPResponder(
+		iss,
+		revocations,
+		WithCertificateStore(issued),
+		WithAuditor(aud),
+		WithDelegatedSigning(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("failed to create ocsp responder: %v", err)
+	}
+
+	der, err := responder.Respond(newOCSPRequest(t, caCert, big.NewInt(42)))
+	if err != nil {
+		t.Fatalf("failed to respond: %v", err)
+	}
+	resp, err := ocsp.ParseResponse(der, caCert)
+	if err != nil {
+		t.Fatalf("failed to parse ocsp response: %v", err)
+	}
+	if resp.Certificate == nil {
+		t.Fatal("expected a response signed by a delegated ocsp signing certificate")
+	}
+
+	if _, err = issued.Get(resp.Certificate.SerialNumber); err != nil {
+		t.Fatalf("expected the delegated ocsp signing certificate to be recorded, got: %v", err)
+	}
+	aud.mu.Lock()
+	defer aud.mu.Unlock()
+	if len(aud.events) != 1 || aud.events[0].EventType != auditor.EventTypeCertificateIssued {
+		t.Fatalf("expected a single %s audit event, got: %d events", auditor.EventTypeCertificateIssued, len(aud.events))
+	}
+	if serial := aud.events[0].IssuedCertificate.SerialNumber; serial != resp.Certificate.SerialNumber.String() {
+		t.Fatalf("expected audited serial number %s, got: %s", resp.Certificate.SerialNumber, serial)
+	}
+}
+
+// mustIssue returns a (DER encoded) certificate issued by the given issuer for a new key.
+func mustIssue(t *testing.T, iss issuer.CertificateIssuer) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}}, key)
+	if err != nil {
+		t.Fatalf("failed to create csr: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatalf("failed to parse csr: %v", err)
+	}
+	certDER, err := iss.IssueCertificate(csr)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	return certDER
+}
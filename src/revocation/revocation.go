@@ -0,0 +1,81 @@
+package revocation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Reason represents an RFC 5280 (section 5.3.1) CRL reason code.
+type Reason int
+
+// RFC 5280 CRL reason codes (note that value 7 is not used).
+const (
+	ReasonUnspecified          Reason = 0
+	ReasonKeyCompromise        Reason = 1
+	ReasonCACompromise         Reason = 2
+	ReasonAffiliationChanged   Reason = 3
+	ReasonSuperseded           Reason = 4
+	ReasonCessationOfOperation Reason = 5
+	ReasonCertificateHold      Reason = 6
+	ReasonRemoveFromCRL        Reason = 8
+	ReasonPrivilegeWithdrawn   Reason = 9
+	ReasonAACompromise         Reason = 10
+)
+
+var reasonNames = map[Reason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// String returns the RFC 5280 name of the reason code.
+func (r Reason) String() string {
+	if name, ok := reasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(r))
+}
+
+// ParseReason parses a reason code from its RFC 5280 name.
+func ParseReason(name string) (Reason, error) {
+	for reason, reasonName := range reasonNames {
+		if reasonName == name {
+			return reason, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason %q", name)
+}
+
+// Valid returns true if the reason is a valid reason code for revoking a certificate.
+// Note that removeFromCRL is only meaningful in delta CRLs, which are not supported.
+func (r Reason) Valid() bool {
+	_, ok := reasonNames[r]
+	return ok && r != ReasonRemoveFromCRL
+}
+
+// Entry represents a revoked certificate.
+type Entry struct {
+	SerialNumber *big.Int  `json:"serial_number"`
+	RevokedAt    time.Time `json:"revoked_at"`
+	Reason       Reason    `json:"reason"`
+}
+
+// Store represents an entity capable of persisting certificate revocations.
+// Get returns a nil entry (and no error) for certificates which are not revoked.
+type Store interface {
+	Revoke(*Entry) error
+	Get(serialNumber *big.Int) (*Entry, error)
+	List() ([]*Entry, error)
+}
+
+// ErrAlreadyRevoked is returned by a Store when attempting to revoke a certificate twice.
+var ErrAlreadyRevoked = errors.New("certificate already revoked")
@@ -0,0 +1,74 @@
+package scep
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidChallenge is returned by a ChallengeStore for unknown challenge passwords.
+var ErrInvalidChallenge = errors.New("invalid challenge password")
+
+// ChallengeStore represents a store of the secrets
+// accepted as SCEP challenge passwords.
+type ChallengeStore interface {
+	// Verify returns the name of the secret matching a
+	// challenge password, or ErrInvalidChallenge if none does.
+	Verify(password string) (string, error)
+}
+
+// ChallengePassword represents the configuration of a static challenge password.
+// Only the (hex encoded) SHA-256 hash of the password is ever configured.
+type ChallengePassword struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// StaticChallengeStore is a static (configured) implementation of the ChallengeStore interface.
+type StaticChallengeStore struct {
+	passwords []passwordHash
+}
+
+type passwordHash struct {
+	name string
+	hash []byte
+}
+
+// ensure StaticChallengeStore implements ChallengeStore.
+var _ ChallengeStore = (*StaticChallengeStore)(nil)
+
+// NewStaticChallengeStore returns a static implementation of the ChallengeStore interface.
+func NewStaticChallengeStore(passwords ...ChallengePassword) (*StaticChallengeStore, error) {
+	s := &StaticChallengeStore{}
+	for _, password := range passwords {
+		hash, err := hex.DecodeString(password.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash for challenge password %q", password.Name)
+		}
+		s.passwords = append(s.passwords, passwordHash{name: password.Name, hash: hash})
+	}
+	return s, nil
+}
+
+// Verify returns the name of the secret matching a challenge password.
+func (s *StaticChallengeStore) Verify(password string) (string, error) {
+	if password == "" {
+		return "", ErrInvalidChallenge
+	}
+
+	hash := sha256.Sum256([]byte(password))
+
+	// compare against every password to not leak which one matched through timing
+	name := ""
+	for _, allowed := range s.passwords {
+		if subtle.ConstantTimeCompare(hash[:], allowed.hash) == 1 {
+			name = allowed.name
+		}
+	}
+	if name == "" {
+		return "", ErrInvalidChallenge
+	}
+	return name, nil
+}
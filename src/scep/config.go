@@ -0,0 +1,11 @@
+package scep
+
+// Config represents the configuration of the SCEP server.
+type Config struct {
+	ChallengePasswords []ChallengePassword `yaml:"challenge_passwords"`
+}
+
+// ChallengeStore returns a ChallengeStore accepting the configured challenge passwords.
+func (c *Config) ChallengeStore() (ChallengeStore, error) {
+	return NewStaticChallengeStore(c.ChallengePasswords...)
+}
@@ -0,0 +1,67 @@
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/template"
+)
+
+const (
+	raKeyBits   = 2048
+	raClockSkew = time.Minute * 5
+)
+
+// RegistrationAuthority is the SCEP registration authority (RA): a certificate issued
+// by the CA whose (RSA) key decrypts client requests and signs server responses on
+// behalf of the CA, whose own key can neither decrypt nor leave its key store.
+type RegistrationAuthority struct {
+	Certificate *x509.Certificate
+	Key         *rsa.PrivateKey
+}
+
+// NewRegistrationAuthority generates a new RA key and has it certified by an issuer.
+func NewRegistrationAuthority(
+	iss issuer.CertificateIssuer,
+	commonName string,
+	lifespan time.Duration,
+) (*RegistrationAuthority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, raKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate registration authority key: %v", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(
+		rand.Reader,
+		&x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}},
+		key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registration authority csr: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registration authority csr: %v", err)
+	}
+
+	certDER, err := iss.IssueCertificateWithBuilder(csr, template.New(
+		raClockSkew,
+		lifespan,
+		template.WithKeyUsage(x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment),
+		template.WithExtKeyUsage(),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue registration authority certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registration authority certificate: %v", err)
+	}
+
+	return &RegistrationAuthority{Certificate: cert, Key: key}, nil
+}
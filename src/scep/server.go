@@ -0,0 +1,520 @@
+package scep
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/revocation"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+	"github.com/smallstep/pkcs7"
+	scepproto "github.com/smallstep/scep"
+)
+
+const (
+	// capabilities advertised via GetCACaps (RFC 8894 section 3.5.2)
+	capabilities = "Renewal\nSHA-256\nAES\nSCEPStandard\nPOSTPKIOperation\n"
+
+	// maxMessageSize is the maximum accepted size of a PKIOperation message.
+	maxMessageSize = 64 * 1024
+)
+
+// Server is an RFC 8894 (SCEP) front-end for a CertificateIssuer.
+//
+// New enrollments (PKCSReq) must carry a challenge password known to the
+// ChallengeStore. Renewals (RenewalReq) must instead be signed with a current
+// (unexpired, unrevoked) certificate previously issued by the CA, and may only
+// request the subject and (a subset of the) subject alternative names of it.
+type Server struct {
+	iss             issuer.CertificateIssuer
+	auditor         auditor.Auditor
+	failureAuditor  auditor.Auditor
+	ra              *RegistrationAuthority
+	challenges      ChallengeStore
+	certificates    store.CertificateStore
+	intents         auditor.IntentJournal
+	revocations     revocation.Store
+	policy          *policy.Policy
+	templateBuilder template.CertificateTemplateBuilder
+}
+
+// Option represents a configuration option for the SCEP Server.
+type Option func(*Server)
+
+// WithChallengeStore sets the store of accepted challenge passwords.
+// Without one, only renewals are possible.
+func WithChallengeStore(challenges ChallengeStore) Option {
+	return func(s *Server) { s.challenges = challenges }
+}
+
+// WithCertificateStore enables recording certificates issued via SCEP in an inventory.
+func WithCertificateStore(certificates store.CertificateStore) Option {
+	return func(s *Server) { s.certificates = certificates }
+}
+
+// WithRevocationStore enables rejecting renewals signed with revoked certificates.
+func WithRevocationStore(revocations revocation.Store) Option {
+	return func(s *Server) { s.revocations = revocations }
+}
+
+// WithIntentJournal enables keeping track of issuance intents until they are resolved,
+// such that those never completed can be flagged (see service.ReconcileIntents).
+func WithIntentJournal(intents auditor.IntentJournal) Option {
+	return func(s *Server) { s.intents = intents }
+}
+
+// WithFailureAuditor sets the Auditor for the events of denied and failed requests
+// instead of the server's auditor (see service.WithFailureAuditor).
+func WithFailureAuditor(failureAuditor auditor.Auditor) Option {
+	return func(s *Server) { s.failureAuditor = failureAuditor }
+}
+
+// WithPolicy enables enforcing an issuance policy on SCEP certificate signing requests.
+func WithPolicy(p *policy.Policy) Option {
+	return func(s *Server) { s.policy = p }
+}
+
+// WithTemplateBuilder sets the CertificateTemplateBuilder (profile) for certificates
+// issued via SCEP. Without one, the issuer's default builder is used.
+func WithTemplateBuilder(templateBuilder template.CertificateTemplateBuilder) Option {
+	return func(s *Server) { s.templateBuilder = templateBuilder }
+}
+
+// NewServer returns a new SCEP Server.
+func NewServer(
+	iss issuer.CertificateIssuer,
+	auditor auditor.Auditor,
+	ra *RegistrationAuthority,
+	opts ...Option,
+) *Server {
+	s := &Server{
+		iss:     iss,
+		auditor: auditor,
+		ra:      ra,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.failureAuditor == nil {
+		s.failureAuditor = auditor
+	}
+	return s
+}
+
+// Register mounts the SCEP endpoint (at /scep) on a gin router.
+func (s *Server) Register(r gin.IRouter) {
+	r.GET("/scep", s.handler)
+	r.POST("/scep", s.handler)
+}
+
+func (s *Server) handler(c *gin.Context) {
+	switch operation := c.Query("operation"); operation {
+	case "GetCACaps":
+		c.Data(http.StatusOK, "text/plain", []byte(capabilities))
+	case "GetCACert":
+		s.getCACertHandler(c)
+	case "PKIOperation":
+		s.pkiOperationHandler(c)
+	default:
+		c.String(http.StatusBadRequest, "unsupported operation %q", operation)
+	}
+}
+
+func (s *Server) getCACertHandler(c *gin.Context) {
+	chain, err := s.iss.Chain()
+	if err != nil {
+		log.Printf("failed to retrieve issuer certificate chain: %v", err)
+		c.String(http.StatusInternalServerError, "failed to retrieve issuer certificate chain")
+		return
+	}
+	p7, err := pkcs7.DegenerateCertificate(bytes.Join(append(chain, s.ra.Certificate.Raw), nil))
+	if err != nil {
+		log.Printf("failed to build pkcs7 certs-only message: %v", err)
+		c.String(http.StatusInternalServerError, "failed to build ca certificates message")
+		return
+	}
+	c.Data(http.StatusOK, "application/x-x509-ca-ra-cert", p7)
+}
+
+func (s *Server) pkiOperationHandler(c *gin.Context) {
+	attempt := issuanceAttempt{
+		client:  auditor.Client{IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()},
+		request: auditor.Request{Method: c.Request.Method, Path: c.Request.URL.Path},
+	}
+
+	var data []byte
+	var err error
+	if c.Request.Method == http.MethodPost {
+		data, err = io.ReadAll(io.LimitReader(c.Request.Body, maxMessageSize))
+	} else {
+		data, err = base64.StdEncoding.DecodeString(c.Query("message"))
+	}
+	if err != nil {
+		s.auditFailure(attempt, denied(auditor.FailureCategoryInvalidRequest, err))
+		c.String(http.StatusBadRequest, "invalid pki message: %v", err)
+		return
+	}
+
+	msg, err := scepproto.ParsePKIMessage(data)
+	if err != nil {
+		s.auditFailure(attempt, denied(auditor.FailureCategoryInvalidRequest, err))
+		c.String(http.StatusBadRequest, "invalid pki message: %v", err)
+		return
+	}
+	if err = msg.DecryptPKIEnvelope(s.ra.Certificate, s.ra.Key); err != nil {
+		err = fmt.Errorf("failed to decrypt pki envelope: %v", err)
+		s.auditFailure(attempt, denied(auditor.FailureCategoryInvalidRequest, err))
+		s.fail(c, msg, scepproto.BadMessageCheck, err)
+		return
+	}
+	attempt.csr = msg.CSR
+
+	var client auditor.Client
+	switch msg.MessageType {
+	case scepproto.PKCSReq:
+		client, err = s.authenticateChallenge(c, msg)
+	case scepproto.RenewalReq:
+		client, err = s.authenticateRenewal(c, msg)
+	default:
+		err = fmt.Errorf("unsupported message type %s", msg.MessageType)
+		s.auditFailure(attempt, denied(auditor.FailureCategoryInvalidRequest, err))
+		s.fail(c, msg, scepproto.BadRequest, err)
+		return
+	}
+	if err != nil {
+		s.auditAuthenticationFailure(attempt, err)
+		s.fail(c, msg, scepproto.BadRequest, err)
+		return
+	}
+	attempt.client = client
+
+	cert, err := s.issue(&attempt)
+	if err != nil {
+		s.auditFailure(attempt, err)
+		s.fail(c, msg, scepproto.BadRequest, err)
+		return
+	}
+
+	certRep, err := msg.Success(s.ra.Certificate, s.ra.Key, cert)
+	if err != nil {
+		log.Printf("failed to build scep success response: %v", err)
+		s.auditFailure(attempt, failed(auditor.FailureCategoryResponseError, fmt.Errorf("failed to build pki message: %v", err)))
+		c.String(http.StatusInternalServerError, "failed to build pki message")
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pki-message", certRep.Raw)
+}
+
+// fail responds with a CertRep message (RFC 8894 section 3.3.2) indicating failure.
+func (s *Server) fail(c *gin.Context, msg *scepproto.PKIMessage, info scepproto.FailInfo, reason error) {
+	log.Printf("scep %s request (transaction %s) failed: %v", msg.MessageType, msg.TransactionID, reason)
+
+	certRep, err := msg.Fail(s.ra.Certificate, s.ra.Key, info)
+	if err != nil {
+		log.Printf("failed to build scep failure response: %v", err)
+		c.String(http.StatusInternalServerError, "failed to build pki message")
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pki-message", certRep.Raw)
+}
+
+// issuanceAttempt represents the state of a SCEP request's issuance, as far as it got.
+type issuanceAttempt struct {
+	client  auditor.Client
+	request auditor.Request
+	csr     *x509.CertificateRequest
+	certDER []byte
+	intent  *auditor.Event
+}
+
+// issuanceError represents the failure of an issuance with its audit failure category and
+// the HTTP status code equivalent to it (SCEP failures are sent as CertRep messages).
+type issuanceError struct {
+	category   string
+	statusCode int
+	err        error
+}
+
+// Error returns the underlying error's message.
+func (e *issuanceError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *issuanceError) Unwrap() error {
+	return e.err
+}
+
+// denied returns an issuanceError caused by the client.
+func denied(category string, err error) error {
+	return &issuanceError{category: category, statusCode: http.StatusBadRequest, err: err}
+}
+
+// failed returns an issuanceError caused by the CA.
+func failed(category string, err error) error {
+	return &issuanceError{category: category, statusCode: http.StatusInternalServerError, err: err}
+}
+
+// auditFailure emits an auditor.EventTypeIssuanceFailed event for a SCEP request (categorized
+// by its *issuanceError, if any). Failures to audit it are only logged, since the request is
+// being aborted either way.
+func (s *Server) auditFailure(attempt issuanceAttempt, err error) {
+	issuanceErr := &issuanceError{category: auditor.FailureCategorySigningError, statusCode: http.StatusInternalServerError}
+	errors.As(err, &issuanceErr)
+
+	event := auditor.NewIssuanceFailedEvent(
+		attempt.client,
+		attempt.csr,
+		attempt.certDER,
+		auditor.HTTPRequest{},
+		attempt.request,
+		auditor.Failure{Category: issuanceErr.category, StatusCode: issuanceErr.statusCode, Detail: err.Error()},
+	)
+	if attempt.intent != nil {
+		event.IntentID = attempt.intent.EventID
+	}
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit issuance failure audit event for scep request (%s): %v", issuanceErr.category, err)
+	}
+}
+
+// auditAuthenticationFailure emits an auditor.EventTypeAuthenticationFailed event for a SCEP
+// request which could not be authenticated. Failures to audit it are only logged.
+func (s *Server) auditAuthenticationFailure(attempt issuanceAttempt, err error) {
+	event := auditor.NewAuthenticationFailedEvent(
+		attempt.client,
+		attempt.request,
+		auditor.Failure{Category: auditor.FailureCategoryUnauthorized, StatusCode: http.StatusUnauthorized, Detail: err.Error()},
+	)
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit authentication failure audit event for scep request: %v", err)
+	}
+}
+
+// authenticateChallenge authenticates an initial enrollment by its challenge password.
+func (s *Server) authenticateChallenge(c *gin.Context, msg *scepproto.PKIMessage) (auditor.Client, error) {
+	if s.challenges == nil {
+		return auditor.Client{}, errors.New("no challenge passwords are configured")
+	}
+	name, err := s.challenges.Verify(msg.ChallengePassword)
+	if err != nil {
+		return auditor.Client{}, err
+	}
+	return auditor.Client{
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Principal:  name,
+		AuthMethod: "scep-challenge",
+	}, nil
+}
+
+// authenticateRenewal authenticates a renewal by the certificate which signed it.
+func (s *Server) authenticateRenewal(c *gin.Context, msg *scepproto.PKIMessage) (auditor.Client, error) {
+	p7, err := pkcs7.Parse(msg.Raw)
+	if err != nil {
+		return auditor.Client{}, fmt.Errorf("failed to parse pki message: %v", err)
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return auditor.Client{}, errors.New("renewal request must have exactly one signer")
+	}
+
+	issuerCert, err := s.issuerCertificate()
+	if err != nil {
+		return auditor.Client{}, fmt.Errorf("failed to retrieve issuer certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuerCert)
+	if _, err = signer.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return auditor.Client{}, fmt.Errorf("renewal request signer is not a current certificate: %v", err)
+	}
+
+	if s.revocations != nil {
+		entry, err := s.revocations.Get(signer.SerialNumber)
+		if err != nil {
+			return auditor.Client{}, fmt.Errorf("failed to check revocation status: %v", err)
+		}
+		if entry != nil {
+			return auditor.Client{}, errors.New("renewal request signer has been revoked")
+		}
+	}
+
+	if !bytes.Equal(msg.CSR.RawSubject, signer.RawSubject) {
+		return auditor.Client{}, errors.New("renewal request subject must match the current certificate")
+	}
+	if err = checkRenewalSubjectAltNames(msg.CSR, signer); err != nil {
+		return auditor.Client{}, err
+	}
+
+	return auditor.Client{
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Principal:  signer.SerialNumber.String(),
+		AuthMethod: "scep-renewal",
+	}, nil
+}
+
+// checkRenewalSubjectAltNames returns an error unless every subject alternative name of
+// a renewal request is also a subject alternative name of the certificate which signed it.
+func checkRenewalSubjectAltNames(csr *x509.CertificateRequest, current *x509.Certificate) error {
+	for _, name := range csr.DNSNames {
+		if !containsFold(current.DNSNames, name) {
+			return fmt.Errorf("renewal request dns name %q is not in the current certificate", name)
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if !containsFold(current.EmailAddresses, email) {
+			return fmt.Errorf("renewal request email address %q is not in the current certificate", email)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		found := false
+		for _, currentIP := range current.IPAddresses {
+			found = found || ip.Equal(currentIP)
+		}
+		if !found {
+			return fmt.Errorf("renewal request ip address %s is not in the current certificate", ip)
+		}
+	}
+	for _, uri := range csr.URIs {
+		found := false
+		for _, currentURI := range current.URIs {
+			found = found || uri.String() == currentURI.String()
+		}
+		if !found {
+			return fmt.Errorf("renewal request uri %q is not in the current certificate", uri)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// issue issues, records and audits a certificate for an authenticated SCEP request, keeping
+// track of the attempt. Errors are *issuanceError, to be audited by the caller.
+func (s *Server) issue(attempt *issuanceAttempt) (*x509.Certificate, error) {
+	csr, client := attempt.csr, attempt.client
+	if err := csr.CheckSignature(); err != nil {
+		return nil, denied(auditor.FailureCategoryInvalidRequest, fmt.Errorf("invalid csr signature: %v", err))
+	}
+	if err := s.iss.CheckPublicKey(csr.PublicKey); err != nil {
+		return nil, denied(auditor.FailureCategoryRejectedKey, err)
+	}
+	if s.policy != nil {
+		if err := s.policy.Evaluate(csr); err != nil {
+			var denial *policy.DenialError
+			if errors.As(err, &denial) {
+				return nil, denied(auditor.FailureCategoryPolicyDenied, err)
+			}
+			return nil, failed(auditor.FailureCategoryPolicyError, err)
+		}
+	}
+
+	templateBuilder := s.templateBuilder
+	if templateBuilder == nil {
+		templateBuilder = s.iss.TemplateBuilder()
+	}
+	certTemplate, templateBuilder, err := template.Prebuild(templateBuilder, csr)
+	if err != nil {
+		return nil, failed(auditor.FailureCategoryTemplateError, fmt.Errorf("failed to build certificate template: %v", err))
+	}
+
+	// two-phase issuance, see service.ReconcileIntents
+	intent, err := auditor.NewIssuanceIntentEvent(client, csr, certTemplate, auditor.HTTPRequest{})
+	if err != nil {
+		return nil, failed(auditor.FailureCategoryAuditError, fmt.Errorf("failed to build issuance intent audit event: %v", err))
+	}
+	if s.intents != nil {
+		if err = s.intents.Begin(intent); err != nil {
+			return nil, failed(auditor.FailureCategoryStorageError, fmt.Errorf("failed to record issuance intent: %v", err))
+		}
+	}
+	attempt.intent = intent
+	if err = s.auditor.Audit(intent); err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		return nil, failed(auditor.FailureCategoryAuditError, fmt.Errorf("failed to emit issuance intent audit event: %v", err))
+	}
+
+	issueCertStart := time.Now()
+	certDER, err := s.iss.IssueCertificateWithBuilder(csr, templateBuilder)
+	if err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		return nil, failed(auditor.FailureCategorySigningError, fmt.Errorf("failed to issue certificate: %v", err))
+	}
+	issueCertDuration := time.Now().Sub(issueCertStart)
+	attempt.certDER = certDER
+
+	// from here on failures leave the intent pending, to be flagged on startup
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, failed(auditor.FailureCategorySigningError, fmt.Errorf("failed to parse issued certificate: %v", err))
+	}
+
+	if s.certificates != nil {
+		record, err := store.NewRecord(cert)
+		if err == nil {
+			err = s.certificates.Put(record)
+		}
+		if err != nil {
+			return nil, failed(auditor.FailureCategoryStorageError, fmt.Errorf("failed to record issued certificate: %v", err))
+		}
+	}
+
+	event, err := auditor.NewIssuanceEvent(
+		client,
+		csr,
+		certDER,
+		auditor.HTTPRequest{IssueCertificateDuration: issueCertDuration.Milliseconds()},
+	)
+	if err != nil {
+		return nil, failed(auditor.FailureCategoryAuditError, fmt.Errorf("failed to build audit event: %v", err))
+	}
+	event.IntentID = intent.EventID
+	if err = s.auditor.Audit(event); err != nil {
+		return nil, failed(auditor.FailureCategoryAuditError, fmt.Errorf("failed to emit audit event: %v", err))
+	}
+	s.resolveIntent(intent, auditor.ResolutionCompleted)
+
+	return cert, nil
+}
+
+// resolveIntent records the resolution of an issuance intent, if intents are journaled.
+// Failures are only logged: an intent left pending is flagged on startup.
+func (s *Server) resolveIntent(intent *auditor.Event, resolution auditor.Resolution) {
+	if s.intents == nil {
+		return
+	}
+	if err := s.intents.Resolve(intent.EventID, resolution); err != nil {
+		log.Printf("failed to resolve issuance intent %s as %s: %v", intent.EventID, resolution, err)
+	}
+}
+
+func (s *Server) issuerCertificate() (*x509.Certificate, error) {
+	issuerDER, err := s.iss.IssuerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(issuerDER)
+}
@@ -0,0 +1,220 @@
+package scep
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/revocation"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+	scepproto "github.com/smallstep/scep"
+)
+
+func TestRenewal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	iss := issuer.New(caCert, caKey, template.New(time.Minute, time.Hour))
+
+	ra, err := NewRegistrationAuthority(iss, "test ra", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create registration authority: %v", err)
+	}
+	revocations, err := revocation.NewFileStore(filepath.Join(t.TempDir(), "revocations.json"))
+	if err != nil {
+		t.Fatalf("failed to create revocation store: %v", err)
+	}
+	server := NewServer(iss, auditor.NewSlog(io.Discard, nil), ra, WithRevocationStore(revocations))
+	router := gin.New()
+	server.Register(router)
+
+	currentKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	current := issueCertificate(t, iss, currentKey, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "device"},
+		DNSNames:    []string{"device.example.com", "alias.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	})
+	revoked := issueCertificate(t, iss, currentKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}})
+	if err = revocations.Revoke(&revocation.Entry{SerialNumber: revoked.SerialNumber, RevokedAt: time.Now(), Reason: revocation.ReasonKeyCompromise}); err != nil {
+		t.Fatalf("failed to revoke certificate: %v", err)
+	}
+	selfSignedTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "device"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	selfSignedDER, err := x509.CreateCertificate(rand.Reader, selfSignedTemplate, selfSignedTemplate, &currentKey.PublicKey, currentKey)
+	if err != nil {
+		t.Fatalf("failed to create self-signed certificate: %v", err)
+	}
+	selfSigned, err := x509.ParseCertificate(selfSignedDER)
+	if err != nil {
+		t.Fatalf("failed to parse self-signed certificate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *x509.Certificate
+		csr    x509.CertificateRequest
+		status scepproto.PKIStatus
+	}{
+		{
+			name:   "same names",
+			signer: current,
+			csr: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "device"},
+				DNSNames:    []string{"device.example.com", "alias.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			status: scepproto.SUCCESS,
+		},
+		{
+			name:   "subset of names",
+			signer: current,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}, DNSNames: []string{"DEVICE.example.com"}},
+			status: scepproto.SUCCESS,
+		},
+		{
+			name:   "different subject",
+			signer: current,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "other device"}},
+			status: scepproto.FAILURE,
+		},
+		{
+			name:   "additional dns name",
+			signer: current,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}, DNSNames: []string{"other.example.com"}},
+			status: scepproto.FAILURE,
+		},
+		{
+			name:   "additional ip address",
+			signer: current,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.2")}},
+			status: scepproto.FAILURE,
+		},
+		{
+			name:   "signed by a revoked certificate",
+			signer: revoked,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}},
+			status: scepproto.FAILURE,
+		},
+		{
+			name:   "signed by a certificate not issued by the ca",
+			signer: selfSigned,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}},
+			status: scepproto.FAILURE,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			renewalKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatalf("failed to generate rsa key: %v", err)
+			}
+			csrDER, err := x509.CreateCertificateRequest(rand.Reader, &test.csr, renewalKey)
+			if err != nil {
+				t.Fatalf("failed to create csr: %v", err)
+			}
+			csr, err := x509.ParseCertificateRequest(csrDER)
+			if err != nil {
+				t.Fatalf("failed to parse csr: %v", err)
+			}
+			msg, err := scepproto.NewCSRRequest(csr, &scepproto.PKIMessage{
+				MessageType: scepproto.RenewalReq,
+				Recipients:  []*x509.Certificate{ra.Certificate},
+				SignerKey:   currentKey,
+				SignerCert:  test.signer,
+			})
+			if err != nil {
+				t.Fatalf("failed to create pki message: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scep?operation=PKIOperation", bytes.NewReader(msg.Raw)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+			}
+			certRep, err := scepproto.ParsePKIMessage(w.Body.Bytes(), scepproto.WithCACerts([]*x509.Certificate{ra.Certificate}))
+			if err != nil {
+				t.Fatalf("failed to parse pki message: %v", err)
+			}
+			if certRep.PKIStatus != test.status {
+				t.Fatalf("expected pki status %s, got: %s (fail info %s)", test.status, certRep.PKIStatus, certRep.FailInfo)
+			}
+			if test.status != scepproto.SUCCESS {
+				return
+			}
+			if err = certRep.DecryptPKIEnvelope(test.signer, currentKey); err != nil {
+				t.Fatalf("failed to decrypt pki envelope: %v", err)
+			}
+			renewed := certRep.CertRepMessage.Certificate
+			if !bytes.Equal(renewed.RawSubject, test.signer.RawSubject) {
+				t.Fatalf("expected renewed certificate subject %s, got: %s", test.signer.Subject, renewed.Subject)
+			}
+			if !renewed.PublicKey.(*rsa.PublicKey).Equal(&renewalKey.PublicKey) {
+				t.Fatal("expected renewed certificate to certify the renewal key")
+			}
+		})
+	}
+}
+
+// issueCertificate issues a certificate for a key with the given csr fields.
+func issueCertificate(t *testing.T, iss issuer.CertificateIssuer, key *rsa.PrivateKey, csrTemplate *x509.CertificateRequest) *x509.Certificate {
+	t.Helper()
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, key)
+	if err != nil {
+		t.Fatalf("failed to create csr: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatalf("failed to parse csr: %v", err)
+	}
+	certDER, err := iss.IssueCertificateWithBuilder(csr, iss.TemplateBuilder())
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCSRRequestSize is the maximum accepted size of a certificate signing request body.
+const maxCSRRequestSize = 64 * 1024
+
+type certificateSigningRequestBody struct {
+	ASN1Data []byte `json:"asn1data"`
+	CSRPEM   string `json:"csr_pem"`
+	Profile  string `json:"profile"`
+}
+
+// readCertificateSigningRequest reads a (DER encoded) CSR from a request body, which may be:
+//
+//   - JSON (the default) with either the base64 DER "asn1data" or the PEM "csr_pem" field
+//   - application/pkcs10 with the raw or base64 encoded DER CSR
+//   - application/x-pem-file with the PEM CSR (as output by "openssl req")
+//
+// Content types browsers may send cross-origin without a preflight request (such as
+// application/x-www-form-urlencoded and text/plain) are deliberately not accepted.
+// The certificate profile is only ever read from JSON bodies.
+func readCertificateSigningRequest(c *gin.Context) (csrDER []byte, profile string, err error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCSRRequestSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read request body: %v", err)
+	}
+	if len(body) > maxCSRRequestSize {
+		return nil, "", fmt.Errorf("request body exceeds %d bytes", maxCSRRequestSize)
+	}
+
+	mediaType := "application/json"
+	if contentType := c.GetHeader("Content-Type"); contentType != "" {
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, "", fmt.Errorf("invalid content type: %v", err)
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		var payload certificateSigningRequestBody
+		if err = json.Unmarshal(body, &payload); err != nil {
+			return nil, "", fmt.Errorf("failed to json-decode body: %v", err)
+		}
+		switch {
+		case len(payload.ASN1Data) > 0 && payload.CSRPEM != "":
+			return nil, "", errors.New("only one of asn1data and csr_pem may be set")
+		case payload.CSRPEM != "":
+			csrDER, err = decodeCSRPEM([]byte(payload.CSRPEM))
+			return csrDER, payload.Profile, err
+		default:
+			return payload.ASN1Data, payload.Profile, nil
+		}
+	case "application/pkcs10":
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("-----BEGIN")) {
+			csrDER, err = decodeCSRPEM(body)
+			return csrDER, "", err
+		}
+		// DER CSRs are ASN.1 SEQUENCEs, starting with 0x30 (i.e. '0'), whereas base64
+		// encoded ones start with "MI": a base64 string starting with '0' would decode
+		// to a first byte of 0xd0-0xd3, which is not the start of a CSR either way
+		if len(body) > 0 && body[0] == 0x30 {
+			return body, "", nil
+		}
+		csrDER, err = base64.StdEncoding.DecodeString(string(stripWhitespace(body)))
+		if err != nil {
+			return nil, "", fmt.Errorf("body is neither DER nor base64 encoded: %v", err)
+		}
+		return csrDER, "", nil
+	case "application/x-pem-file":
+		csrDER, err = decodeCSRPEM(body)
+		return csrDER, "", err
+	default:
+		return nil, "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+// decodeCSRPEM returns the DER bytes of the (first) PEM encoded CSR in data.
+func decodeCSRPEM(data []byte) ([]byte, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM encoded certificate request found")
+		}
+		if block.Type == "CERTIFICATE REQUEST" || block.Type == "NEW CERTIFICATE REQUEST" {
+			return block.Bytes, nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/smallstep/pkcs7"
+)
+
+// media types certificates can be served as, negotiated via the Accept header.
+//
+// Note that PKCS#12 is deliberately not offered: a PKCS#12 bundle is only
+// useful with the subject's private key, which never leaves the subject.
+const (
+	mimeJSON     = "application/json"
+	mimePKIXCert = "application/pkix-cert"
+	mimePEMFile  = "application/x-pem-file"
+	mimePKCS7    = "application/pkcs7-mime"
+)
+
+var certificateFormats = []string{mimeJSON, mimePKIXCert, mimePEMFile, mimePKCS7}
+
+// negotiateCertificateFormat returns the media type to serve certificates as: the supported media
+// type the Accept header prefers (by quality value, then in the order of certificateFormats). It
+// defaults to JSON, which is also served when none of the supported media types are acceptable,
+// as it was before other formats were supported.
+func negotiateCertificateFormat(c *gin.Context) string {
+	format, bestQuality := mimeJSON, 0.0
+	accept := parseAccept(c.GetHeader("Accept"))
+	for _, candidate := range certificateFormats {
+		if quality := acceptQuality(accept, candidate); quality > bestQuality {
+			format, bestQuality = candidate, quality
+		}
+	}
+	return format
+}
+
+// mediaRange represents a media range of an Accept header with its quality value.
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept parses the media ranges of an Accept header (RFC 9110 section 12.5.1).
+// Malformed quality values are treated as 0, i.e. not acceptable.
+func parseAccept(header string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, element := range strings.Split(header, ",") {
+		params := strings.Split(element, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), quality: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || quality < 0 || quality > 1 {
+					quality = 0
+				}
+				r.quality = quality
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality value of the most specific media range matching
+// a media type, i.e. "type/subtype" over "type/*" over "*/*", or 0 if none does.
+func acceptQuality(ranges []mediaRange, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, 0
+	for _, r := range ranges {
+		s := 0
+		switch r.mediaType {
+		case mediaType:
+			s = 3
+		case typ + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		}
+		if s > specificity {
+			quality, specificity = r.quality, s
+		}
+	}
+	return quality
+}
+
+// respondCertificate responds with a (DER encoded) certificate followed by
+// the chain above it in a raw (non-JSON) format. For application/pkix-cert,
+// which only holds one certificate, the chain is omitted. If the response
+// cannot be encoded, nothing is written and an error is returned.
+func respondCertificate(c *gin.Context, format string, cert []byte, chain [][]byte) error {
+	switch format {
+	case mimePKIXCert:
+		c.Data(http.StatusOK, mimePKIXCert, cert)
+	case mimePEMFile:
+		c.Data(http.StatusOK, mimePEMFile, encodeChainPEM(append([][]byte{cert}, chain...)))
+	case mimePKCS7:
+		p7, err := pkcs7.DegenerateCertificate(bytes.Join(append([][]byte{cert}, chain...), nil))
+		if err != nil {
+			return fmt.Errorf("failed to build pkcs7 certs-only message: %v", err)
+		}
+		c.Data(http.StatusOK, mimePKCS7+"; smime-type=certs-only", p7)
+	default:
+		return fmt.Errorf("unsupported certificate format %q", format)
+	}
+	c.Abort()
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateCertificateFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{name: "no accept header", expected: mimeJSON},
+		{name: "any", accept: "*/*", expected: mimeJSON},
+		{name: "json", accept: "application/json", expected: mimeJSON},
+		{name: "der", accept: "application/pkix-cert", expected: mimePKIXCert},
+		{name: "case insensitive", accept: "Application/X-PEM-File", expected: mimePEMFile},
+		{name: "highest quality wins", accept: "application/pkix-cert;q=0.5, application/pkcs7-mime;q=0.8", expected: mimePKCS7},
+		{name: "default quality is 1", accept: "application/json;q=0.9, application/x-pem-file", expected: mimePEMFile},
+		{name: "ties resolved in order of preference", accept: "application/pkcs7-mime, application/pkix-cert", expected: mimePKIXCert},
+		{name: "specific range overrides wildcard", accept: "application/*;q=0.9, application/json;q=0.1", expected: mimePKIXCert},
+		{name: "excluded by zero quality", accept: "*/*, application/json;q=0", expected: mimePKIXCert},
+		{name: "spaces around parameters", accept: "application/pkix-cert ; q = 0.2 , application/x-pem-file ; q=0.3", expected: mimePEMFile},
+		{name: "malformed quality is not acceptable", accept: "application/pkix-cert;q=high, application/x-pem-file;q=0.1", expected: mimePEMFile},
+		{name: "out of range quality is not acceptable", accept: "application/pkix-cert;q=2, application/x-pem-file;q=0.1", expected: mimePEMFile},
+		{name: "nothing supported falls back to json", accept: "text/html", expected: mimeJSON},
+		{name: "everything excluded falls back to json", accept: "application/*;q=0", expected: mimeJSON},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/certificates/sign", nil)
+			if test.accept != "" {
+				c.Request.Header.Set("Accept", test.accept)
+			}
+			if format := negotiateCertificateFormat(c); format != test.expected {
+				t.Fatalf("expected format %s, got: %s", test.expected, format)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 )
 
 func (s *Service) caHandler(c *gin.Context) {
+	format := negotiateCertificateFormat(c)
+
 	cert, err := s.iss.IssuerCertificate()
 	if err != nil {
 		// FIXME: log and do not return error
@@ -19,6 +21,23 @@ func (s *Service) caHandler(c *gin.Context) {
 		return
 	}
 
+	if format != mimeJSON {
+		chain, err := s.iss.Chain()
+		if err != nil {
+			// FIXME: log and do not return error
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to retrieve issuer certificate chain: %v", err)},
+			)
+			return
+		}
+		if err = respondCertificate(c, format, cert, chain[1:]); err != nil {
+			// FIXME: log and do not return error
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
 	if c.Query("format") == "pem" {
 		cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
 		c.AbortWithStatusJSON(http.StatusOK, gin.H{"certificate": string(cert)})
@@ -28,3 +47,32 @@ func (s *Service) caHandler(c *gin.Context) {
 	c.AbortWithStatusJSON(http.StatusOK, gin.H{"certificate": cert})
 	return
 }
+
+func (s *Service) caChainHandler(c *gin.Context) {
+	chain, err := s.iss.Chain()
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to retrieve issuer certificate chain: %v", err)},
+		)
+		return
+	}
+
+	if c.Query("format") == "pem" {
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{"chain": string(encodeChainPEM(chain))})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{"chain": chain})
+	return
+}
+
+// encodeChainPEM returns the concatenated PEM encoding of (DER encoded) certificates.
+func encodeChainPEM(chain [][]byte) []byte {
+	chainPEM := []byte{}
+	for _, cert := range chain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	return chainPEM
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrianosela/ca/src/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCertificatesPageSize = 50
+	maxCertificatesPageSize     = 500
+)
+
+type certificateMetadata struct {
+	SerialNumber         string    `json:"serial_number"`
+	Subject              string    `json:"subject"`
+	DNSNames             []string  `json:"dns_names"`
+	IPAddresses          []string  `json:"ip_addresses"`
+	EmailAddresses       []string  `json:"email_addresses"`
+	URIs                 []string  `json:"uris"`
+	NotBefore            time.Time `json:"not_before"`
+	NotAfter             time.Time `json:"not_after"`
+	PublicKeyFingerprint string    `json:"public_key_fingerprint"`
+	Status               string    `json:"status"`
+	IssuedAt             time.Time `json:"issued_at"`
+}
+
+func newCertificateMetadata(r *store.Record) certificateMetadata {
+	return certificateMetadata{
+		SerialNumber:         r.SerialNumber,
+		Subject:              r.Subject,
+		DNSNames:             r.DNSNames,
+		IPAddresses:          r.IPAddresses,
+		EmailAddresses:       r.EmailAddresses,
+		URIs:                 r.URIs,
+		NotBefore:            r.NotBefore,
+		NotAfter:             r.NotAfter,
+		PublicKeyFingerprint: r.PublicKeyFingerprint,
+		Status:               string(r.StatusAt(time.Now())),
+		IssuedAt:             r.IssuedAt,
+	}
+}
+
+func (s *Service) getCertificateHandler(c *gin.Context) {
+	serialNumber, ok := parseSerialNumber(c)
+	if !ok {
+		return
+	}
+
+	record, err := s.certificates.Get(serialNumber)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatusJSON(
+				http.StatusNotFound,
+				gin.H{"error": fmt.Sprintf("no certificate with serial number %s was issued", serialNumber)},
+			)
+			return
+		}
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to look up certificate: %v", err)},
+		)
+		return
+	}
+
+	if c.Query("format") == "pem" {
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: record.Raw})
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{
+			"certificate": string(cert),
+			"metadata":    newCertificateMetadata(record),
+		})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{
+		"certificate": record.Raw,
+		"metadata":    newCertificateMetadata(record),
+	})
+	return
+}
+
+func (s *Service) listCertificatesHandler(c *gin.Context) {
+	query := &store.Query{
+		DNSName:              c.Query("dns_name"),
+		PublicKeyFingerprint: c.Query("fingerprint"),
+		Status:               store.Status(c.Query("status")),
+		Limit:                defaultCertificatesPageSize,
+	}
+
+	switch query.Status {
+	case "", store.StatusValid, store.StatusRevoked, store.StatusExpired:
+	default:
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid status %q, must be one of %q, %q or %q", query.Status, store.StatusValid, store.StatusRevoked, store.StatusExpired)},
+		)
+		return
+	}
+
+	if raw := c.Query("expiring_before"); raw != "" {
+		expiringBefore, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid expiring_before %q, must be an RFC 3339 timestamp", raw)},
+			)
+			return
+		}
+		query.ExpiringBefore = expiringBefore
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxCertificatesPageSize {
+			c.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid limit %q, must be an integer between 1 and %d", raw, maxCertificatesPageSize)},
+			)
+			return
+		}
+		query.Limit = limit
+	}
+
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			c.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid offset %q, must be a non-negative integer", raw)},
+			)
+			return
+		}
+		query.Offset = offset
+	}
+
+	records, total, err := s.certificates.Search(query)
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to search certificates: %v", err)},
+		)
+		return
+	}
+
+	certificates := []certificateMetadata{}
+	for _, record := range records {
+		certificates = append(certificates, newCertificateMetadata(record))
+	}
+
+	resp := gin.H{
+		"certificates": certificates,
+		"total":        total,
+		"offset":       query.Offset,
+		"limit":        query.Limit,
+	}
+	if next := query.Offset + len(records); next < total {
+		resp["next_offset"] = next
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+	return
+}
+
+// parseSerialNumber parses the (decimal) serial number path parameter,
+// aborting the request with a 400 if it is not a valid serial number.
+func parseSerialNumber(c *gin.Context) (*big.Int, bool) {
+	serialNumber, ok := new(big.Int).SetString(c.Param("serial"), 10)
+	if !ok || serialNumber.Sign() <= 0 {
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid serial number %q, must be a positive decimal integer", c.Param("serial"))},
+		)
+		return nil, false
+	}
+	return serialNumber, true
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auth"
+	"github.com/adrianosela/ca/src/store"
+)
+
+func TestListCertificatesHandler(t *testing.T) {
+	now := time.Now().UTC()
+	certificates := store.NewMemoryStore()
+	for i, r := range []struct {
+		dnsName  string
+		status   store.Status
+		notAfter time.Time
+	}{
+		{dnsName: "a.example.com", status: store.StatusValid, notAfter: now.Add(time.Hour)},
+		{dnsName: "A.Example.com", status: store.StatusValid, notAfter: now.Add(48 * time.Hour)},
+		{dnsName: "a.example.com", status: store.StatusRevoked, notAfter: now.Add(time.Hour)},
+		{dnsName: "a.example.com", status: store.StatusValid, notAfter: now.Add(-time.Hour)},
+		{dnsName: "b.example.com", status: store.StatusValid, notAfter: now.Add(time.Hour)},
+	} {
+		if err := certificates.Put(&store.Record{
+			SerialNumber: fmt.Sprint(i + 1),
+			DNSNames:     []string{r.dnsName},
+			NotBefore:    now.Add(-48 * time.Hour),
+			NotAfter:     r.notAfter,
+			Status:       r.status,
+			// records are listed in the order they were issued
+			IssuedAt: now.Add(time.Duration(i) * time.Second),
+		}); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	handler := NewService(
+		newTestIssuer(t),
+		&recordingAuditor{},
+		WithCertificateStore(certificates),
+		WithAuthenticator(&staticAuthenticator{principal: &auth.Principal{Name: "inventory", Method: "bearer"}}),
+	).HTTPHandler()
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		// serialNumbers are those of the listed certificates, in order
+		serialNumbers []string
+		total         int
+		// nextOffset is the expected next_offset, 0 if there must be no next page
+		nextOffset int
+	}{
+		{name: "all", code: http.StatusOK, serialNumbers: []string{"1", "2", "3", "4", "5"}, total: 5},
+		{name: "dns name of any case", query: "dns_name=a.EXAMPLE.com", code: http.StatusOK, serialNumbers: []string{"1", "2", "3", "4"}, total: 4},
+		{name: "valid", query: "status=valid", code: http.StatusOK, serialNumbers: []string{"1", "2", "5"}, total: 3},
+		{name: "expired", query: "status=expired", code: http.StatusOK, serialNumbers: []string{"4"}, total: 1},
+		{name: "revoked", query: "dns_name=a.example.com&status=revoked", code: http.StatusOK, serialNumbers: []string{"3"}, total: 1},
+		{
+			name:          "expiring before",
+			query:         "status=valid&expiring_before=" + now.Add(24*time.Hour).Format(time.RFC3339),
+			code:          http.StatusOK,
+			serialNumbers: []string{"1", "5"},
+			total:         2,
+		},
+		{name: "first page", query: "limit=2", code: http.StatusOK, serialNumbers: []string{"1", "2"}, total: 5, nextOffset: 2},
+		{name: "middle page", query: "limit=2&offset=2", code: http.StatusOK, serialNumbers: []string{"3", "4"}, total: 5, nextOffset: 4},
+		{name: "last page", query: "limit=2&offset=4", code: http.StatusOK, serialNumbers: []string{"5"}, total: 5},
+		{name: "offset past the end", query: "offset=10", code: http.StatusOK, serialNumbers: []string{}, total: 5},
+		{name: "invalid status", query: "status=pending", code: http.StatusBadRequest},
+		{name: "invalid expiring before", query: "expiring_before=tomorrow", code: http.StatusBadRequest},
+		{name: "zero limit", query: "limit=0", code: http.StatusBadRequest},
+		{name: "limit over the maximum", query: fmt.Sprintf("limit=%d", maxCertificatesPageSize+1), code: http.StatusBadRequest},
+		{name: "negative offset", query: "offset=-1", code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/certificates?"+test.query, nil)
+			req.Header.Set("Authorization", "test")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Fatalf("expected status code %d, got: %d (%s)", test.code, w.Code, w.Body.String())
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Certificates []certificateMetadata `json:"certificates"`
+				Total        int                   `json:"total"`
+				NextOffset   int                   `json:"next_offset"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to json-decode response: %v", err)
+			}
+			serialNumbers := []string{}
+			for _, cert := range resp.Certificates {
+				serialNumbers = append(serialNumbers, cert.SerialNumber)
+			}
+			if !reflect.DeepEqual(serialNumbers, test.serialNumbers) {
+				t.Fatalf("expected serial numbers %v, got: %v", test.serialNumbers, serialNumbers)
+			}
+			if resp.Total != test.total {
+				t.Fatalf("expected total %d, got: %d", test.total, resp.Total)
+			}
+			if resp.NextOffset != test.nextOffset {
+				t.Fatalf("expected next offset %d, got: %d", test.nextOffset, resp.NextOffset)
+			}
+		})
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/certificates", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d, got: %d (%s)", http.StatusUnauthorized, w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Service) crlHandler(c *gin.Context) {
+	crl, err := s.crlBuilder.CRL()
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to build certificate revocation list: %v", err)},
+		)
+		return
+	}
+
+	if c.Query("format") == "pem" {
+		crl = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+		c.Data(http.StatusOK, "application/x-pem-file", crl)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/pkix-crl", crl)
+	return
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	estCurrentCertificateContextKey = "est_current_certificate"
+
+	// maxESTRequestSize is the maximum accepted size of a (base64 encoded) EST request body.
+	maxESTRequestSize = 64 * 1024
+)
+
+// registerEST mounts the EST (RFC 7030) endpoints on a router group. The optional
+// CA label path segment (RFC 7030 section 3.2.2) selects the certificate profile.
+func (s *Service) registerEST(g *gin.RouterGroup) {
+	enroll := []gin.HandlerFunc{s.estSimpleEnrollHandler}
+	if s.authenticator != nil {
+		enroll = append([]gin.HandlerFunc{s.requireAuthentication(s.authenticator)}, enroll...)
+	}
+
+	g.GET("/cacerts", s.estCACertsHandler)
+	g.POST("/simpleenroll", enroll...)
+	g.POST("/simplereenroll", s.requireCurrentCertificate, s.estSimpleReenrollHandler)
+	g.GET("/csrattrs", s.estCSRAttrsHandler)
+}
+
+func (s *Service) estCACertsHandler(c *gin.Context) {
+	chain, err := s.iss.Chain()
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to retrieve issuer certificate chain: %v", err)},
+		)
+		return
+	}
+	s.estRespondCertsOnly(c, "application/pkcs7-mime", chain...)
+}
+
+func (s *Service) estSimpleEnrollHandler(c *gin.Context) {
+	csr, ok := s.estReadPKCS10(c)
+	if !ok {
+		return
+	}
+	s.estIssue(c, csr)
+}
+
+func (s *Service) estSimpleReenrollHandler(c *gin.Context) {
+	current := c.MustGet(estCurrentCertificateContextKey).(*x509.Certificate)
+	csr, ok := s.estReadPKCS10(c)
+	if !ok {
+		return
+	}
+
+	// the subject and SANs of a re-enrollment request must be identical
+	// to those of the certificate being renewed (RFC 7030 section 4.2.2)
+	if !bytes.Equal(csr.RawSubject, current.RawSubject) || !sameSubjectAltNames(csr, current) {
+		s.abortIssuance(
+			c, issuanceAttempt{csr: csr}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": "re-enrollment request subject and subject alternative names must match the current certificate"},
+		)
+		return
+	}
+	s.estIssue(c, csr)
+}
+
+func (s *Service) estIssue(c *gin.Context, csr *x509.CertificateRequest) {
+	templateBuilder, err := s.resolveProfile(c, c.Param("label"))
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{csr: csr}, auditor.FailureCategoryInvalidRequest,
+			http.StatusNotFound,
+			gin.H{"error": fmt.Sprintf("invalid certificate profile: %v", err)},
+		)
+		return
+	}
+
+	certDER, ok := s.issue(c, csr, templateBuilder, auditor.HTTPRequest{})
+	if !ok {
+		return
+	}
+	s.estRespondCertsOnly(c, "application/pkcs7-mime; smime-type=certs-only", certDER)
+}
+
+func (s *Service) estCSRAttrsHandler(c *gin.Context) {
+	if len(s.estCSRAttributes) == 0 {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	// CsrAttrs ::= SEQUENCE SIZE (0..MAX) OF AttrOrOID (RFC 7030 section 4.5.2)
+	der, err := asn1.Marshal(s.estCSRAttributes)
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to encode csr attributes: %v", err)},
+		)
+		return
+	}
+	estRespondBase64(c, "application/csrattrs", der)
+}
+
+// estReadPKCS10 reads a base64 encoded PKCS#10 certificate signing request from an EST request body.
+func (s *Service) estReadPKCS10(c *gin.Context) (*x509.CertificateRequest, bool) {
+	if mediaType, _, err := mime.ParseMediaType(c.ContentType()); err != nil || mediaType != "application/pkcs10" {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
+			http.StatusUnsupportedMediaType,
+			gin.H{"error": "content type must be application/pkcs10"},
+		)
+		return nil, false
+	}
+
+	// larger bodies are rejected rather than truncated into an invalid (or different) request
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxESTRequestSize+1))
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("failed to read request body: %v", err)},
+		)
+		return nil, false
+	}
+	if len(body) > maxESTRequestSize {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
+			http.StatusRequestEntityTooLarge,
+			gin.H{"error": fmt.Sprintf("request body may be at most %d bytes", maxESTRequestSize)},
+		)
+		return nil, false
+	}
+	der, err := base64.StdEncoding.DecodeString(string(stripWhitespace(body)))
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("request body is not base64 encoded: %v", err)},
+		)
+		return nil, false
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid certificate signing request: %v", err)},
+		)
+		return nil, false
+	}
+	if err = csr.CheckSignature(); err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{csr: csr}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid certificate signing request signature: %v", err)},
+		)
+		return nil, false
+	}
+	return csr, true
+}
+
+// estRespondCertsOnly responds with a base64 encoded PKCS#7 certs-only message.
+func (s *Service) estRespondCertsOnly(c *gin.Context, contentType string, certsDER ...[]byte) {
+	p7, err := pkcs7.DegenerateCertificate(bytes.Join(certsDER, nil))
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to build pkcs7 certs-only message: %v", err)},
+		)
+		return
+	}
+	estRespondBase64(c, contentType, p7)
+}
+
+func estRespondBase64(c *gin.Context, contentType string, data []byte) {
+	c.Header("Content-Transfer-Encoding", "base64")
+	c.Data(http.StatusOK, contentType, []byte(base64.StdEncoding.EncodeToString(data)))
+	c.Abort()
+}
+
+// requireCurrentCertificate is a middleware which authenticates EST re-enrollment
+// requests by the (unexpired, unrevoked) TLS client certificate previously issued
+// by this CA, and stores the certificate and its principal in the gin context.
+// Requests which cannot be authenticated are rejected and audited.
+func (s *Service) requireCurrentCertificate(c *gin.Context) {
+	if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+		s.abortUnauthenticated(c, http.StatusUnauthorized, "re-enrollment requires the current certificate as TLS client certificate")
+		return
+	}
+	current := c.Request.TLS.PeerCertificates[0]
+
+	issuerDER, err := s.iss.IssuerCertificate()
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to retrieve issuer certificate: %v", err)},
+		)
+		return
+	}
+	issuerCert, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to parse issuer certificate: %v", err)},
+		)
+		return
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuerCert)
+	if _, err = current.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		s.abortUnauthenticated(c, http.StatusUnauthorized, fmt.Sprintf("invalid client certificate: %v", err))
+		return
+	}
+
+	if s.revocations != nil {
+		entry, err := s.revocations.Get(current.SerialNumber)
+		if err != nil {
+			// FIXME: log and do not return error
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to check revocation status: %v", err)},
+			)
+			return
+		}
+		if entry != nil {
+			s.abortUnauthenticated(c, http.StatusUnauthorized, "client certificate has been revoked")
+			return
+		}
+	}
+
+	c.Set(estCurrentCertificateContextKey, current)
+	c.Set(principalContextKey, &auth.Principal{
+		Name:   current.SerialNumber.String(),
+		Method: "est-reenroll",
+	})
+	c.Next()
+}
+
+// sameSubjectAltNames returns whether a CSR requests exactly the SANs of a certificate.
+func sameSubjectAltNames(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	if !equalStrings(csr.DNSNames, cert.DNSNames) || !equalStrings(csr.EmailAddresses, cert.EmailAddresses) {
+		return false
+	}
+	if len(csr.IPAddresses) != len(cert.IPAddresses) || len(csr.URIs) != len(cert.URIs) {
+		return false
+	}
+	for i := range csr.IPAddresses {
+		if !csr.IPAddresses[i].Equal(cert.IPAddresses[i]) {
+			return false
+		}
+	}
+	for i := range csr.URIs {
+		if csr.URIs[i].String() != cert.URIs[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stripWhitespace(data []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, data)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/revocation"
+)
+
+// newESTRequest returns an EST request with a (base64 encoded) PKCS#10 body.
+func newESTRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/est"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/pkcs10")
+	return req
+}
+
+// newTestCertificate returns a certificate issued by the given issuer for a new key.
+func newTestCertificate(t *testing.T, iss issuer.CertificateIssuer) *x509.Certificate {
+	t.Helper()
+	csr, err := x509.ParseCertificateRequest(newTestCSR(t, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}}))
+	if err != nil {
+		t.Fatalf("failed to parse csr: %v", err)
+	}
+	certDER, err := iss.IssueCertificate(csr)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestESTReenrollAuthentication(t *testing.T) {
+	iss := newTestIssuer(t)
+	revocations, err := revocation.NewFileStore(filepath.Join(t.TempDir(), "revocations.json"))
+	if err != nil {
+		t.Fatalf("failed to create revocation store: %v", err)
+	}
+	revoked := newTestCertificate(t, iss)
+	if err = revocations.Revoke(&revocation.Entry{SerialNumber: revoked.SerialNumber, RevokedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to revoke certificate: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// clientCert is the TLS client certificate of the request, if any
+		clientCert *x509.Certificate
+	}{
+		{name: "no client certificate"},
+		{name: "client certificate of another ca", clientCert: newTestCertificate(t, newTestIssuer(t))},
+		{name: "revoked client certificate", clientCert: revoked},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aud := &recordingAuditor{}
+			handler := NewService(iss, aud, WithEST(), WithRevocation(revocations, nil)).HTTPHandler()
+
+			req := newESTRequest("/simplereenroll", base64.StdEncoding.EncodeToString(newTestCSR(t, &x509.CertificateRequest{})))
+			if test.clientCert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.clientCert}}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status code %d, got: %d (%s)", http.StatusUnauthorized, w.Code, w.Body.String())
+			}
+			expected := []string{auditor.EventTypeAuthenticationFailed}
+			if events := aud.eventTypes(); !reflect.DeepEqual(events, expected) {
+				t.Fatalf("expected audit events %v, got: %v", expected, events)
+			}
+		})
+	}
+}
+
+func TestESTRequestSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		code int
+	}{
+		// a body within the limit is read whole (and here rejected as not base64)
+		{name: "at the limit", size: maxESTRequestSize, code: http.StatusBadRequest},
+		{name: "over the limit", size: maxESTRequestSize + 1, code: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			aud := &recordingAuditor{}
+			handler := NewService(newTestIssuer(t), aud, WithEST()).HTTPHandler()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newESTRequest("/simpleenroll", strings.Repeat("!", test.size)))
+			if w.Code != test.code {
+				t.Fatalf("expected status code %d, got: %d (%s)", test.code, w.Code, w.Body.String())
+			}
+			expected := []string{auditor.EventTypeIssuanceFailed}
+			if events := aud.eventTypes(); !reflect.DeepEqual(events, expected) {
+				t.Fatalf("expected audit events %v, got: %v", expected, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspRequestContentType  = "application/ocsp-request"
+	ocspResponseContentType = "application/ocsp-response"
+
+	// RFC 6960 OCSP requests are small, anything beyond this is not worth parsing
+	maxOCSPRequestSize = 1 << 14
+)
+
+// ocspHandler serves both the GET (RFC 6960 Appendix A.1, base64
+// request in the path) and the POST (DER request in the body) forms.
+func (s *Service) ocspHandler(c *gin.Context) {
+	var rawRequest []byte
+	if c.Request.Method == http.MethodGet {
+		encoded, err := url.PathUnescape(strings.TrimPrefix(c.Param("request"), "/"))
+		if err != nil {
+			c.Data(http.StatusBadRequest, ocspResponseContentType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		if rawRequest, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			c.Data(http.StatusBadRequest, ocspResponseContentType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+	} else {
+		if c.ContentType() != ocspRequestContentType {
+			c.Data(http.StatusUnsupportedMediaType, ocspResponseContentType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxOCSPRequestSize))
+		if err != nil {
+			c.Data(http.StatusBadRequest, ocspResponseContentType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		rawRequest = body
+	}
+
+	resp, err := s.ocspResponder.Respond(rawRequest)
+	if err != nil {
+		log.Printf("failed to build OCSP response: %v", err)
+	}
+
+	c.Data(http.StatusOK, ocspResponseContentType, resp)
+	return
+}
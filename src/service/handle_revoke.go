@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrianosela/ca/src/revocation"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/gin-gonic/gin"
+)
+
+type revocationRequestBody struct {
+	Reason     string `json:"reason"`
+	ReasonCode *int   `json:"reason_code"`
+}
+
+func (s *Service) revokeHandler(c *gin.Context) {
+	serialNumber, ok := parseSerialNumber(c)
+	if !ok {
+		return
+	}
+
+	if s.certificates != nil {
+		if _, err := s.certificates.Get(serialNumber); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.AbortWithStatusJSON(
+					http.StatusNotFound,
+					gin.H{"error": fmt.Sprintf("no certificate with serial number %s was issued", serialNumber)},
+				)
+				return
+			}
+			// FIXME: log and do not return error
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to look up certificate: %v", err)},
+			)
+			return
+		}
+	}
+
+	var payload revocationRequestBody
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.AbortWithStatusJSON(
+				http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("invalid request body: %v", err)},
+			)
+			return
+		}
+	}
+
+	reason, err := parseRevocationReason(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid request body: %v", err)},
+		)
+		return
+	}
+
+	entry := &revocation.Entry{
+		SerialNumber: serialNumber,
+		RevokedAt:    time.Now().UTC(),
+		Reason:       reason,
+	}
+	if err = s.revocations.Revoke(entry); err != nil {
+		if errors.Is(err, revocation.ErrAlreadyRevoked) {
+			c.AbortWithStatusJSON(
+				http.StatusConflict,
+				gin.H{"error": fmt.Sprintf("certificate with serial number %s is already revoked", serialNumber)},
+			)
+			return
+		}
+		// FIXME: log and do not return error
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to revoke certificate: %v", err)},
+		)
+		return
+	}
+	if s.crlBuilder != nil {
+		s.crlBuilder.Invalidate()
+	}
+	if s.certificates != nil {
+		if err = s.certificates.UpdateStatus(serialNumber, store.StatusRevoked); err != nil {
+			// the revocation store is the source of truth for revocation
+			// status, so this only affects inventory lookups
+			log.Printf("failed to update status of revoked certificate %s: %v", serialNumber, err)
+		}
+	}
+	if s.ocspResponder != nil {
+		s.ocspResponder.Invalidate(serialNumber)
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{
+		"serial_number": serialNumber.String(),
+		"revoked_at":    entry.RevokedAt,
+		"reason":        entry.Reason.String(),
+	})
+	return
+}
+
+func parseRevocationReason(payload revocationRequestBody) (revocation.Reason, error) {
+	reason := revocation.ReasonUnspecified
+	if payload.Reason != "" {
+		parsed, err := revocation.ParseReason(payload.Reason)
+		if err != nil {
+			return 0, err
+		}
+		reason = parsed
+	}
+	if payload.ReasonCode != nil {
+		if payload.Reason != "" && revocation.Reason(*payload.ReasonCode) != reason {
+			return 0, fmt.Errorf("reason %q does not match reason_code %d", payload.Reason, *payload.ReasonCode)
+		}
+		reason = revocation.Reason(*payload.ReasonCode)
+	}
+	if !reason.Valid() {
+		return 0, fmt.Errorf("reason %s is not a valid revocation reason", reason)
+	}
+	return reason, nil
+}
@@ -1,28 +1,30 @@
 package service
 
 import (
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
-type certificateSigningRequestBody struct {
-	ASN1Data []byte `json:"asn1data"`
-}
-
 func (s *Service) signHandler(c *gin.Context) {
+	format := negotiateCertificateFormat(c)
+
 	parseReqStart := time.Now()
-	var payload *certificateSigningRequestBody
-	if err := c.BindJSON(&payload); err != nil {
-		c.AbortWithStatusJSON(
+	csrDER, bodyProfile, err := readCertificateSigningRequest(c)
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
 			http.StatusBadRequest,
 			gin.H{"error": fmt.Sprintf("invalid request body: %v", err)},
 		)
@@ -31,9 +33,10 @@ func (s *Service) signHandler(c *gin.Context) {
 	parseReqDuration := time.Now().Sub(parseReqStart)
 
 	parseCSRStart := time.Now()
-	csr, err := x509.ParseCertificateRequest(payload.ASN1Data)
+	csr, err := x509.ParseCertificateRequest(csrDER)
 	if err != nil {
-		c.AbortWithStatusJSON(
+		s.abortIssuance(
+			c, issuanceAttempt{}, auditor.FailureCategoryInvalidRequest,
 			http.StatusBadRequest,
 			gin.H{"error": fmt.Sprintf("invalid request body: %v", err)},
 		)
@@ -41,131 +44,351 @@ func (s *Service) signHandler(c *gin.Context) {
 	}
 	parseCSRDuration := time.Now().Sub(parseCSRStart)
 
-	issueCertStart := time.Now()
-	certDER, err := s.iss.IssueCertificate(csr)
+	templateBuilder, err := s.resolveProfile(c, bodyProfile)
+	if err != nil {
+		s.abortIssuance(
+			c, issuanceAttempt{csr: csr}, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid certificate profile: %v", err)},
+		)
+		return
+	}
+
+	// the chain is retrieved before issuing, such that failing to do so fails the request
+	// before a certificate is issued rather than after (when it cannot be returned)
+	chain, err := s.iss.Chain()
 	if err != nil {
 		// FIXME: log and do not return error
-		c.AbortWithStatusJSON(
+		s.abortIssuance(
+			c, issuanceAttempt{csr: csr}, auditor.FailureCategorySigningError,
 			http.StatusInternalServerError,
-			gin.H{"error": fmt.Sprintf("failed to issue certificate: %v", err)},
+			gin.H{"error": fmt.Sprintf("failed to retrieve issuer certificate chain: %v", err)},
 		)
 		return
 	}
-	issueCertDuration := time.Now().Sub(issueCertStart)
 
-	certPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certDER,
+	certDER, ok := s.issue(c, csr, templateBuilder, auditor.HTTPRequest{
+		ParseRequestBodyDuration: parseReqDuration.Milliseconds(),
+		ParseCSRDuration:         parseCSRDuration.Milliseconds(),
 	})
+	if !ok {
+		return
+	}
 
-	event, err := buildAuditEvent(
-		c,
-		csr,
-		certDER,
-		certPEM,
-		parseReqDuration.Milliseconds(),
-		parseCSRDuration.Milliseconds(),
-		issueCertDuration.Milliseconds(),
-	)
+	if format != mimeJSON {
+		if err = respondCertificate(c, format, certDER, chain); err != nil {
+			// FIXME: log and do not return error
+			s.abortIssuance(
+				c, issuanceAttempt{csr: csr, certDER: certDER}, auditor.FailureCategoryResponseError,
+				http.StatusInternalServerError,
+				gin.H{"error": err.Error()},
+			)
+		}
+		return
+	}
+
+	if c.Query("format") == "pem" {
+		certPEM := pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: certDER,
+		})
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{
+			"certificate": string(certPEM),
+			"chain":       string(encodeChainPEM(chain)),
+		})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, gin.H{"certificate": certDER, "chain": chain})
+	return
+}
+
+// resolveProfile returns the CertificateTemplateBuilder for the profile requested either via the
+// "profile" query parameter or the request body (which must agree if both are present).
+// The issuer's default builder is returned if certificate profiles are not enabled.
+func (s *Service) resolveProfile(c *gin.Context, bodyProfile string) (template.CertificateTemplateBuilder, error) {
+	profile := c.Query("profile")
+	if bodyProfile != "" {
+		if profile != "" && profile != bodyProfile {
+			return nil, fmt.Errorf("profile query parameter %q does not match request body profile %q", profile, bodyProfile)
+		}
+		profile = bodyProfile
+	}
+	if s.profiles == nil {
+		if profile != "" {
+			return nil, fmt.Errorf("certificate profiles are not enabled")
+		}
+		return s.iss.TemplateBuilder(), nil
+	}
+	return s.profiles.Resolve(profile)
+}
+
+// issue restricts CA certificate profiles to privileged callers (and other profiles to their
+// principals, if any), checks the CSR's public key, enforces the names bound to the
+// authenticated principal (if any), evaluates the issuance policy against the
+// certificate template, issues, records and audits the certificate.
+// On failure the request is aborted with an appropriate error (and the failure
+// audited, see abortIssuance) and ok is false.
+func (s *Service) issue(
+	c *gin.Context,
+	csr *x509.CertificateRequest,
+	templateBuilder template.CertificateTemplateBuilder,
+	httpRequest auditor.HTTPRequest,
+) (certDER []byte, ok bool) {
+	attempt := issuanceAttempt{csr: csr, httpRequest: httpRequest}
+
+	if err := csr.CheckSignature(); err != nil {
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryInvalidRequest,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid certificate signing request: failed to verify signature: %v", err)},
+		)
+		return nil, false
+	}
+
+	if template.IsCA(templateBuilder) && !isPrivileged(c) {
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryUnauthorized,
+			http.StatusForbidden,
+			gin.H{"error": "CA certificate profiles may only be used by privileged callers"},
+		)
+		return nil, false
+	}
+	if !isPrivileged(c) && !template.Permits(templateBuilder, principalMethod(c), principalName(c)) {
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryUnauthorized,
+			http.StatusForbidden,
+			gin.H{"error": "the certificate profile may not be used by this caller"},
+		)
+		return nil, false
+	}
+
+	if err := s.iss.CheckPublicKey(csr.PublicKey); err != nil {
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryRejectedKey,
+			http.StatusBadRequest,
+			gin.H{"error": fmt.Sprintf("invalid certificate signing request: %v", err)},
+		)
+		return nil, false
+	}
+
+	if principal := getPrincipal(c); principal != nil && principal.BoundNames != nil {
+		if err := principal.BoundNames.Check(csr); err != nil {
+			s.abortIssuance(
+				c, attempt, auditor.FailureCategoryBoundNamesDenied,
+				http.StatusForbidden,
+				gin.H{"error": fmt.Sprintf("certificate signing request denied: %v", err)},
+			)
+			return nil, false
+		}
+		templateBuilder = template.WithBoundNames(
+			templateBuilder,
+			principal.BoundNames.DNSNames,
+			principal.BoundNames.EmailAddresses,
+			principal.BoundNames.URIs,
+		)
+	}
+
+	// the template (and therefore the serial number) is built before the intent, such that
+	// the intent names the certificate which may be signed even if it is never recorded
+	certTemplate, templateBuilder, err := template.Prebuild(templateBuilder, csr)
+	if err != nil {
+		// FIXME: log and do not return error
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryTemplateError,
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to build certificate template: %v", err)},
+		)
+		return nil, false
+	}
+
+	if s.policy != nil {
+		// the template's names are evaluated, since they are not necessarily those requested
+		if err = s.policy.EvaluateTemplate(certTemplate, csr); err != nil {
+			var denial *policy.DenialError
+			if errors.As(err, &denial) {
+				s.abortIssuance(
+					c, attempt, auditor.FailureCategoryPolicyDenied,
+					http.StatusForbidden,
+					gin.H{"error": denial.Error(), "violations": denial.Violations},
+				)
+				return nil, false
+			}
+			// FIXME: log and do not return error
+			s.abortIssuance(
+				c, attempt, auditor.FailureCategoryPolicyError,
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to evaluate issuance policy: %v", err)},
+			)
+			return nil, false
+		}
+	}
+
+	// two-phase issuance: the intent is audited before the certificate is signed, such
+	// that certificates signed but never audited as issued can be found (see ReconcileIntents)
+	intent, err := auditor.NewIssuanceIntentEvent(auditClient(c), csr, certTemplate, httpRequest)
 	if err != nil {
 		// FIXME: log and do not return error
-		c.AbortWithStatusJSON(
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryAuditError,
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to build issuance intent audit event: %v", err)},
+		)
+		return nil, false
+	}
+	if s.intents != nil {
+		if err = s.intents.Begin(intent); err != nil {
+			// FIXME: log and do not return error
+			s.abortIssuance(
+				c, attempt, auditor.FailureCategoryAuditError,
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to record issuance intent: %v", err)},
+			)
+			return nil, false
+		}
+	}
+	attempt.intent = intent
+	if err = s.auditor.Audit(intent); err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		// FIXME: log and do not return error
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryAuditError,
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to emit issuance intent audit event: %v", err)},
+		)
+		return nil, false
+	}
+
+	issueCertStart := time.Now()
+	certDER, err = s.iss.IssueCertificateWithBuilder(csr, templateBuilder)
+	if err != nil {
+		s.resolveIntent(intent, auditor.ResolutionAborted)
+		category := auditor.FailureCategorySigningError
+		var templateErr *issuer.TemplateError
+		if errors.As(err, &templateErr) {
+			category = auditor.FailureCategoryTemplateError
+		}
+		// FIXME: log and do not return error
+		s.abortIssuance(
+			c, attempt, category,
+			http.StatusInternalServerError,
+			gin.H{"error": fmt.Sprintf("failed to issue certificate: %v", err)},
+		)
+		return nil, false
+	}
+	httpRequest.IssueCertificateDuration = time.Now().Sub(issueCertStart).Milliseconds()
+	attempt.httpRequest = httpRequest
+	attempt.certDER = certDER
+
+	// from here on failures leave the intent pending, to be flagged by ReconcileIntents
+	if s.certificates != nil {
+		if err = s.recordCertificate(certDER); err != nil {
+			// FIXME: log and do not return error
+			s.abortIssuance(
+				c, attempt, auditor.FailureCategoryStorageError,
+				http.StatusInternalServerError,
+				gin.H{"error": fmt.Sprintf("failed to record issued certificate: %v", err)},
+			)
+			return nil, false
+		}
+	}
+
+	event, err := auditor.NewIssuanceEvent(auditClient(c), csr, certDER, httpRequest)
+	if err != nil {
+		// FIXME: log and do not return error
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryAuditError,
 			http.StatusInternalServerError,
 			gin.H{"error": fmt.Sprintf("failed to build audit event: %v", err)},
 		)
-		return
+		return nil, false
 	}
+	event.IntentID = intent.EventID
 
 	if err = s.auditor.Audit(event); err != nil {
 		// FIXME: log and do not return error
-		c.AbortWithStatusJSON(
+		s.abortIssuance(
+			c, attempt, auditor.FailureCategoryAuditError,
 			http.StatusInternalServerError,
 			gin.H{"error": fmt.Sprintf("failed to emit audit event: %v", err)},
 		)
-		return
+		return nil, false
 	}
 
-	if c.Query("format") == "pem" {
-		c.AbortWithStatusJSON(http.StatusOK, gin.H{"certificate": string(certPEM)})
-		return
-	}
+	s.resolveIntent(intent, auditor.ResolutionCompleted)
+	return certDER, true
+}
 
-	c.AbortWithStatusJSON(http.StatusOK, gin.H{"certificate": certDER})
-	return
+// issuanceAttempt describes how far a certificate signing request got before it failed.
+// Any field may be unset, if the request failed before getting that far.
+type issuanceAttempt struct {
+	csr         *x509.CertificateRequest
+	httpRequest auditor.HTTPRequest
+	intent      *auditor.Event
+	certDER     []byte
 }
 
-func buildAuditEvent(
-	c *gin.Context,
-	csr *x509.CertificateRequest,
-	certDER []byte,
-	certPEM []byte,
-	parseReqDuration int64,
-	parseCSRDuration int64,
-	issueCertDuration int64,
-) (*auditor.Event, error) {
-	publicKeyDER, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal PKIX public key: %v", err)
+// abortIssuance aborts a certificate signing request like c.AbortWithStatusJSON,
+// after auditing the failure with the error of the response as the detail.
+func (s *Service) abortIssuance(c *gin.Context, attempt issuanceAttempt, category string, code int, obj gin.H) {
+	s.auditFailure(c, attempt, category, code, fmt.Sprint(obj["error"]))
+	c.AbortWithStatusJSON(code, obj)
+}
+
+// auditFailure emits an auditor.EventTypeIssuanceFailed event for a certificate signing request.
+// Failures to audit it are only logged, since the request is being aborted either way.
+func (s *Service) auditFailure(c *gin.Context, attempt issuanceAttempt, category string, code int, detail string) {
+	event := auditor.NewIssuanceFailedEvent(
+		auditClient(c),
+		attempt.csr,
+		attempt.certDER,
+		attempt.httpRequest,
+		auditor.Request{Method: c.Request.Method, Path: c.Request.URL.Path},
+		auditor.Failure{Category: category, StatusCode: code, Detail: detail},
+	)
+	if attempt.intent != nil {
+		event.IntentID = attempt.intent.EventID
 	}
-	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: publicKeyDER,
-	})
-	hash := sha256.Sum256(publicKeyDER)
-	publicKeyFingerprint := hex.EncodeToString(hash[:])
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit issuance failure audit event (%s): %v", category, err)
+	}
+}
 
+// resolveIntent records the resolution of an issuance intent, if intents are journaled.
+// Failures are only logged: an intent left pending is flagged by ReconcileIntents.
+func (s *Service) resolveIntent(intent *auditor.Event, resolution auditor.Resolution) {
+	if s.intents == nil {
+		return
+	}
+	if err := s.intents.Resolve(intent.EventID, resolution); err != nil {
+		log.Printf("failed to resolve issuance intent %s as %s: %v", intent.EventID, resolution, err)
+	}
+}
+
+func (s *Service) recordCertificate(certDER []byte) error {
 	cert, err := x509.ParseCertificate(certDER)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse issued certificate: %v", err)
-	}
-
-	ipAddresses := []string{}
-	for _, ip := range cert.IPAddresses {
-		ipAddresses = append(ipAddresses, ip.String())
-	}
-
-	emails := []string{}
-	if cert.EmailAddresses != nil {
-		emails = cert.EmailAddresses
-	}
-
-	dnsNames := []string{}
-	if cert.DNSNames != nil {
-		dnsNames = cert.DNSNames
-	}
-
-	uris := []string{}
-	for _, uri := range cert.URIs {
-		uris = append(uris, uri.String())
-	}
-
-	return &auditor.Event{
-		EventID:   uuid.New().String(),
-		Timestamp: time.Now().UnixMilli(),
-		Client: auditor.Client{
-			IPAddress: c.ClientIP(),
-			UserAgent: c.Request.UserAgent(),
-		},
-		CertificateSigningRequest: auditor.CertificateSigningRequest{
-			PublicKey:            string(publicKeyPEM),
-			PublicKeyFingerprint: publicKeyFingerprint,
-		},
-		IssuedCertificate: auditor.IssuedCertificate{
-			SerialNumber:   cert.SerialNumber.String(),
-			Issuer:         cert.Issuer.String(),
-			Subject:        cert.Subject.String(),
-			NotBefore:      cert.NotBefore.String(),
-			NotAfter:       cert.NotAfter.String(),
-			IPAddresses:    ipAddresses,
-			DNSNames:       dnsNames,
-			EmailAddresses: emails,
-			URIs:           uris,
-			Raw:            string(certPEM),
-		},
-		HTTPRequest: auditor.HTTPRequest{
-			ParseRequestBodyDuration: parseReqDuration,
-			ParseCSRDuration:         parseCSRDuration,
-			IssueCertificateDuration: issueCertDuration,
-		},
-	}, nil
+		return fmt.Errorf("failed to parse issued certificate: %v", err)
+	}
+	record, err := store.NewRecord(cert)
+	if err != nil {
+		return fmt.Errorf("failed to build certificate record: %v", err)
+	}
+	if err = s.certificates.Put(record); err != nil {
+		return fmt.Errorf("failed to store certificate record: %v", err)
+	}
+	return nil
+}
+
+// auditClient returns the audit event portion describing the client of a request.
+func auditClient(c *gin.Context) auditor.Client {
+	client := auditor.Client{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if principal := getPrincipal(c); principal != nil {
+		client.Principal = principal.Name
+		client.AuthMethod = principal.Method
+	}
+	return client
 }
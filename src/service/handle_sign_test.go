@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/auth"
+	"github.com/adrianosela/ca/src/template"
+)
+
+func TestSignHandlerAudit(t *testing.T) {
+	// successful issuances are audited as such whatever the response format
+	expected := []string{auditor.EventTypeIssuanceIntent, auditor.EventTypeCertificateIssued}
+	for _, accept := range certificateFormats {
+		t.Run(accept, func(t *testing.T) {
+			aud := &recordingAuditor{}
+			handler := NewService(newTestIssuer(t), aud).HTTPHandler()
+
+			req := newSignRequest(t, newTestCSR(t, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}}))
+			req.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+			}
+			if events := aud.eventTypes(); !reflect.DeepEqual(events, expected) {
+				t.Fatalf("expected audit events %v, got: %v", expected, events)
+			}
+		})
+	}
+}
+
+func TestSignHandlerAuditInvalidRequest(t *testing.T) {
+	aud := &recordingAuditor{}
+	handler := NewService(newTestIssuer(t), aud).HTTPHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newSignRequest(t, []byte("not a csr")))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	expected := []string{auditor.EventTypeIssuanceFailed}
+	if events := aud.eventTypes(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected audit events %v, got: %v", expected, events)
+	}
+	if category := aud.events[0].Failure.Category; category != auditor.FailureCategoryInvalidRequest {
+		t.Fatalf("expected failure category %q, got: %q", auditor.FailureCategoryInvalidRequest, category)
+	}
+}
+
+// staticAuthenticator is an implementation of the auth.Authenticator interface which
+// authenticates every request with credentials as the same principal.
+type staticAuthenticator struct {
+	principal *auth.Principal
+}
+
+func (a *staticAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	if r.Header.Get("Authorization") == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	return a.principal, nil
+}
+
+func TestSignHandlerProfilePrincipals(t *testing.T) {
+	profiles, err := template.NewRegistry(&template.ProfilesConfig{
+		Default: "client",
+		Profiles: map[string]*template.Profile{
+			"client":   {Lifespan: time.Hour},
+			"codesign": {Lifespan: time.Hour, Principals: []string{"hmac:release-pipeline"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create profiles registry: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		profile   string
+		code      int
+	}{
+		{name: "permitted principal", principal: &auth.Principal{Name: "release-pipeline", Method: "hmac"}, profile: "codesign", code: http.StatusOK},
+		{name: "same name by another method", principal: &auth.Principal{Name: "release-pipeline", Method: "mtls"}, profile: "codesign", code: http.StatusForbidden},
+		{name: "other principal", principal: &auth.Principal{Name: "other", Method: "hmac"}, profile: "codesign", code: http.StatusForbidden},
+		{name: "unrestricted profile", principal: &auth.Principal{Name: "other", Method: "mtls"}, profile: "client", code: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := NewService(
+				newTestIssuer(t),
+				&recordingAuditor{},
+				WithProfiles(profiles),
+				WithAuthenticator(&staticAuthenticator{principal: test.principal}),
+			).HTTPHandler()
+
+			req := newSignRequest(t, newTestCSR(t, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}}))
+			req.URL.RawQuery = "profile=" + test.profile
+			req.Header.Set("Authorization", "test")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Fatalf("expected status code %d, got: %d (%s)", test.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignHandlerRequestTooLarge(t *testing.T) {
+	authenticator, err := auth.NewHMACAuthenticator(auth.HMACKey{ID: "ci", Secret: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("failed to create hmac authenticator: %v", err)
+	}
+	aud := &recordingAuditor{}
+	handler := NewService(newTestIssuer(t), aud, WithAuthenticator(authenticator)).HTTPHandler()
+
+	// bodies too large to authenticate are rejected before their signature is checked
+	req := httptest.NewRequest(http.MethodPost, "/certificates/sign", bytes.NewReader(make([]byte, 2<<20)))
+	req.Header.Set("X-Request-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set("Authorization", "HMAC-SHA256 ci:00")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status code %d, got: %d (%s)", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+	expected := []string{auditor.EventTypeAuthenticationFailed}
+	if events := aud.eventTypes(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected audit events %v, got: %v", expected, events)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	principalContextKey  = "principal"
+	privilegedContextKey = "privileged"
+)
+
+// requireAuthentication returns a middleware which rejects (and audits) requests that cannot be
+// authenticated by the given authenticator (or every request, if it is nil) and otherwise stores
+// the principal in the gin context.
+func (s *Service) requireAuthentication(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isPrivileged(c) {
+			c.Next()
+			return
+		}
+		if authenticator == nil {
+			s.abortUnauthenticated(c, http.StatusUnauthorized, "no authentication methods are configured for this endpoint")
+			return
+		}
+
+		principal, err := authenticator.Authenticate(c.Request)
+		if err != nil {
+			if errors.Is(err, auth.ErrNoCredentials) {
+				s.abortUnauthenticated(c, http.StatusUnauthorized, "missing credentials")
+				return
+			}
+			if errors.Is(err, auth.ErrRequestTooLarge) {
+				s.abortUnauthenticated(c, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			s.abortUnauthenticated(c, http.StatusUnauthorized, fmt.Sprintf("authentication failed: %v", err))
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// abortUnauthenticated emits an auditor.EventTypeAuthenticationFailed event for a request and
+// aborts it with the given status code: 401 Unauthorized, or 413 Request Entity Too Large for
+// bodies too large to authenticate. Failures to audit it are only logged.
+func (s *Service) abortUnauthenticated(c *gin.Context, code int, detail string) {
+	category := auditor.FailureCategoryUnauthorized
+	if code != http.StatusUnauthorized {
+		category = auditor.FailureCategoryInvalidRequest
+	}
+	event := auditor.NewAuthenticationFailedEvent(
+		auditClient(c),
+		auditor.Request{Method: c.Request.Method, Path: c.Request.URL.Path},
+		auditor.Failure{Category: category, StatusCode: code, Detail: detail},
+	)
+	if err := s.failureAuditor.Audit(event); err != nil {
+		log.Printf("failed to emit authentication failure audit event: %v", err)
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": detail})
+}
+
+// authenticatePrivileged returns a middleware which marks requests that can be authenticated
+// by the given (admin) authenticator as privileged, storing the principal in the gin context.
+// Other requests are passed on as they are, e.g. to requireAuthentication, except those
+// with bodies too large to authenticate, which are rejected (see auth.ErrRequestTooLarge).
+func (s *Service) authenticatePrivileged(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authenticator == nil {
+			c.Next()
+			return
+		}
+		principal, err := authenticator.Authenticate(c.Request)
+		if errors.Is(err, auth.ErrRequestTooLarge) {
+			s.abortUnauthenticated(c, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err == nil {
+			c.Set(principalContextKey, principal)
+			c.Set(privilegedContextKey, true)
+		}
+		c.Next()
+	}
+}
+
+// isPrivileged returns true if a request was authenticated by authenticatePrivileged.
+func isPrivileged(c *gin.Context) bool {
+	return c.GetBool(privilegedContextKey)
+}
+
+// getPrincipal returns the authenticated principal for a request, if any.
+func getPrincipal(c *gin.Context) *auth.Principal {
+	if value, ok := c.Get(principalContextKey); ok {
+		if principal, ok := value.(*auth.Principal); ok {
+			return principal
+		}
+	}
+	return nil
+}
+
+// principalName returns the name of the authenticated principal for a request, or "" if none.
+func principalName(c *gin.Context) string {
+	if principal := getPrincipal(c); principal != nil {
+		return principal.Name
+	}
+	return ""
+}
+
+// principalMethod returns the authentication method of the principal for a request, or "" if none.
+func principalMethod(c *gin.Context) string {
+	if principal := getPrincipal(c); principal != nil {
+		return principal.Method
+	}
+	return ""
+}
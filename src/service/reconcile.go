@@ -0,0 +1,91 @@
+package service
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/store"
+)
+
+// ReconcileIntents flags the issuance intents which were never completed (e.g. the process
+// stopped after signing a certificate, or the certificate could not be audited as issued)
+// by emitting an auditor.EventTypeIssuanceUnresolved event for each, along with the
+// certificate issued for it if one is found in the certificate store, or otherwise
+// the serial number the certificate would have been signed with. It must be called
+// before the service handles requests, and returns the number of intents flagged.
+func (s *Service) ReconcileIntents() (int, error) {
+	if s.intents == nil {
+		return 0, nil
+	}
+	pending, err := s.intents.Pending()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pending issuance intents: %v", err)
+	}
+
+	flagged := 0
+	for _, intent := range pending {
+		cert, err := s.findIntentCertificate(intent)
+		if err != nil {
+			return flagged, fmt.Errorf("failed to look up certificate for issuance intent %s: %v", intent.EventID, err)
+		}
+
+		event := auditor.NewIssuanceUnresolvedEvent(intent, cert)
+		if err = s.auditor.Audit(event); err != nil {
+			return flagged, fmt.Errorf("failed to emit audit event for unresolved issuance intent %s: %v", intent.EventID, err)
+		}
+		if err = s.intents.Resolve(intent.EventID, auditor.ResolutionFlagged); err != nil {
+			return flagged, fmt.Errorf("failed to resolve issuance intent %s: %v", intent.EventID, err)
+		}
+		flagged++
+
+		switch {
+		case cert != nil:
+			log.Printf("flagged unresolved issuance intent %s: certificate %s was issued but not audited", intent.EventID, cert.SerialNumber)
+		case intent.IssuedCertificate.SerialNumber != "":
+			log.Printf("flagged unresolved issuance intent %s: certificate %s may have been signed but was not recorded", intent.EventID, intent.IssuedCertificate.SerialNumber)
+		default:
+			log.Printf("flagged unresolved issuance intent %s: no certificate found", intent.EventID)
+		}
+	}
+	return flagged, nil
+}
+
+// findIntentCertificate returns the certificate in the store with the serial number of an
+// issuance intent or, for intents recorded without one, the first certificate issued for
+// the public key of the intent since the intent. It returns nil if none is found.
+func (s *Service) findIntentCertificate(intent *auditor.Event) (*x509.Certificate, error) {
+	if s.certificates == nil {
+		return nil, nil
+	}
+	if intent.IssuedCertificate.SerialNumber != "" {
+		serialNumber, ok := new(big.Int).SetString(intent.IssuedCertificate.SerialNumber, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", intent.IssuedCertificate.SerialNumber)
+		}
+		record, err := s.certificates.Get(serialNumber)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificate(record.Raw)
+	}
+	records, _, err := s.certificates.Search(&store.Query{
+		PublicKeyFingerprint: intent.CertificateSigningRequest.PublicKeyFingerprint,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.IssuedAt.UnixMilli() < intent.Timestamp {
+			continue
+		}
+		return x509.ParseCertificate(record.Raw)
+	}
+	return nil, nil
+}
@@ -1,33 +1,211 @@
 package service
 
 import (
+	"encoding/asn1"
 	"net/http"
 
+	"github.com/adrianosela/ca/src/acme"
 	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/auth"
 	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/policy"
+	"github.com/adrianosela/ca/src/revocation"
+	"github.com/adrianosela/ca/src/scep"
+	"github.com/adrianosela/ca/src/store"
+	"github.com/adrianosela/ca/src/template"
 	"github.com/gin-gonic/gin"
 )
 
 type Service struct {
 	iss     issuer.CertificateIssuer
 	auditor auditor.Auditor
+	// failureAuditor receives the events of denied and failed requests
+	failureAuditor auditor.Auditor
+
+	profiles           *template.Registry
+	policy             *policy.Policy
+	certificates       store.CertificateStore
+	intents            auditor.IntentJournal
+	revocations        revocation.Store
+	crlBuilder         *revocation.CRLBuilder
+	ocspResponder      *revocation.OCSPResponder
+	authenticator      auth.Authenticator
+	adminAuthenticator auth.Authenticator
+	acme               *acme.Server
+	est                bool
+	estCSRAttributes   []asn1.ObjectIdentifier
+	scep               *scep.Server
+}
+
+// Option represents a configuration option for the Service.
+type Option func(*Service)
+
+// WithProfiles enables selecting a certificate profile on signing requests.
+func WithProfiles(profiles *template.Registry) Option {
+	return func(s *Service) {
+		s.profiles = profiles
+	}
+}
+
+// WithPolicy enables enforcing an issuance policy on certificate signing requests.
+func WithPolicy(p *policy.Policy) Option {
+	return func(s *Service) {
+		s.policy = p
+	}
+}
+
+// WithIntentJournal enables keeping track of issuance intents until they
+// are resolved, such that ReconcileIntents can flag those never completed.
+func WithIntentJournal(intents auditor.IntentJournal) Option {
+	return func(s *Service) {
+		s.intents = intents
+	}
+}
+
+// WithFailureAuditor sets the Auditor for the events of denied and failed requests (which
+// unauthenticated clients can trigger at will) instead of the service's auditor, e.g. an
+// auditor.AsyncAuditor such that they neither delay responses nor overload audit sinks.
+func WithFailureAuditor(failureAuditor auditor.Auditor) Option {
+	return func(s *Service) {
+		s.failureAuditor = failureAuditor
+	}
+}
+
+// WithCertificateStore enables recording issued certificates in an inventory.
+func WithCertificateStore(certificates store.CertificateStore) Option {
+	return func(s *Service) {
+		s.certificates = certificates
+	}
+}
+
+// WithRevocation enables the certificate revocation and CRL endpoints. The CRL
+// builder may be nil (e.g. if the issuer is not allowed to sign CRLs), in which
+// case certificates can still be revoked but the CRL endpoint is disabled.
+func WithRevocation(store revocation.Store, crlBuilder *revocation.CRLBuilder) Option {
+	return func(s *Service) {
+		s.revocations = store
+		s.crlBuilder = crlBuilder
+	}
+}
+
+// WithOCSPResponder enables the OCSP responder endpoints.
+func WithOCSPResponder(responder *revocation.OCSPResponder) Option {
+	return func(s *Service) {
+		s.ocspResponder = responder
+	}
+}
+
+// WithAuthenticator requires callers of the certificate signing endpoint to authenticate.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *Service) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithAdminAuthenticator sets the authenticator for privileged endpoints
+// e.g. revocation. Without one, privileged endpoints reject every request.
+func WithAdminAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *Service) {
+		s.adminAuthenticator = authenticator
+	}
+}
+
+// WithACME enables the ACME (RFC 8555) endpoints under /acme.
+func WithACME(server *acme.Server) Option {
+	return func(s *Service) {
+		s.acme = server
+	}
+}
+
+// WithEST enables the EST (RFC 7030) enrollment endpoints under /.well-known/est.
+// The given attributes (if any) are advertised to clients via /csrattrs.
+func WithEST(csrAttributes ...asn1.ObjectIdentifier) Option {
+	return func(s *Service) {
+		s.est = true
+		s.estCSRAttributes = csrAttributes
+	}
+}
+
+// WithSCEP enables the SCEP (RFC 8894) endpoint at /scep.
+func WithSCEP(server *scep.Server) Option {
+	return func(s *Service) {
+		s.scep = server
+	}
 }
 
 func NewService(
 	iss issuer.CertificateIssuer,
 	auditor auditor.Auditor,
+	opts ...Option,
 ) *Service {
-	return &Service{
+	s := &Service{
 		iss:     iss,
 		auditor: auditor,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.failureAuditor == nil {
+		s.failureAuditor = auditor
+	}
+	return s
 }
 
 func (s *Service) HTTPHandler() http.Handler {
 	r := gin.Default()
 
 	r.GET("/certificates/ca", s.caHandler)
-	r.POST("/certificates/sign", s.signHandler)
+	r.GET("/certificates/ca/chain", s.caChainHandler)
+	// admins may also sign, which is required for CA certificate profiles
+	if s.authenticator != nil {
+		r.POST(
+			"/certificates/sign",
+			s.authenticatePrivileged(s.adminAuthenticator),
+			s.requireAuthentication(s.authenticator),
+			s.signHandler,
+		)
+	} else {
+		r.POST("/certificates/sign", s.authenticatePrivileged(s.adminAuthenticator), s.signHandler)
+	}
+
+	// the inventory lists every name ever issued, so it is restricted like signing
+	if s.certificates != nil {
+		inventory := r.Group("/certificates",
+			s.authenticatePrivileged(s.adminAuthenticator),
+			s.requireAuthentication(s.authenticator),
+		)
+		inventory.GET("", s.listCertificatesHandler)
+		inventory.GET("/:serial", s.getCertificateHandler)
+	}
+
+	if s.revocations != nil {
+		if s.crlBuilder != nil {
+			r.GET("/certificates/crl", s.crlHandler)
+		}
+		r.POST(
+			"/certificates/:serial/revoke",
+			s.requireAuthentication(s.adminAuthenticator),
+			s.revokeHandler,
+		)
+	}
+
+	if s.ocspResponder != nil {
+		r.POST("/ocsp", s.ocspHandler)
+		r.GET("/ocsp/*request", s.ocspHandler)
+	}
+
+	if s.est {
+		s.registerEST(r.Group("/.well-known/est"))
+		s.registerEST(r.Group("/.well-known/est/:label"))
+	}
+
+	if s.acme != nil {
+		s.acme.Register(r)
+	}
+
+	if s.scep != nil {
+		s.scep.Register(r)
+	}
 
 	return r
 }
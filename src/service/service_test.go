@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/ca/src/auditor"
+	"github.com/adrianosela/ca/src/issuer"
+	"github.com/adrianosela/ca/src/template"
+	"github.com/gin-gonic/gin"
+)
+
+// recordingAuditor is an implementation of the Auditor interface which keeps audit events in memory.
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []*auditor.Event
+}
+
+func (r *recordingAuditor) Audit(e *auditor.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// eventTypes returns the types of the audited events, in order.
+func (r *recordingAuditor) eventTypes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := []string{}
+	for _, e := range r.events {
+		types = append(types, e.EventType)
+	}
+	return types
+}
+
+// newTestIssuer returns a CertificateIssuer with a self-signed root CA certificate.
+func newTestIssuer(t *testing.T) issuer.CertificateIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	return issuer.New(caCert, key, template.New(time.Minute, time.Hour))
+}
+
+// newTestCSR returns a (DER encoded) certificate signing request for a new key.
+func newTestCSR(t *testing.T, csrTemplate *x509.CertificateRequest) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, key)
+	if err != nil {
+		t.Fatalf("failed to create csr: %v", err)
+	}
+	return csrDER
+}
+
+// newSignRequest returns a JSON certificate signing request for the sign endpoint.
+func newSignRequest(t *testing.T, csrDER []byte) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(&certificateSigningRequestBody{ASN1Data: csrDER})
+	if err != nil {
+		t.Fatalf("failed to json-encode request body: %v", err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/certificates/sign", bytes.NewReader(body))
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/youmark/pkcs8"
+)
+
+// NewFileSigner returns a crypto.Signer for the private key in a PEM file. The key may
+// be in PKCS#1 (RSA), SEC 1 (EC) or PKCS#8 form, and may be encrypted with a passphrase
+// either as a PKCS#8 "ENCRYPTED PRIVATE KEY" or as a legacy OpenSSL encrypted PEM block.
+func NewFileSigner(path string, passphrase []byte) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in key file %s", path)
+	}
+
+	der := block.Bytes
+	// legacy (deprecated, but still produced by e.g. "openssl genrsa -aes256") encrypted PEM
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, errors.New("key file is encrypted but no passphrase was provided")
+		}
+		if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+			return nil, fmt.Errorf("failed to decrypt key file: %v", err)
+		}
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, errors.New("key file is encrypted but no passphrase was provided")
+		}
+		key, err = pkcs8.ParsePKCS8PrivateKey(der, passphrase)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q in key file", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,114 @@
+package signer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/kmssigner"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+const describeKeyTimeout = time.Second * 10
+
+// kmsSignatureAlgorithms maps the KMS signing algorithms usable
+// for x509 certificates to their x509 signature algorithms.
+var kmsSignatureAlgorithms = map[types.SigningAlgorithmSpec]x509.SignatureAlgorithm{
+	types.SigningAlgorithmSpecRsassaPkcs1V15Sha256: x509.SHA256WithRSA,
+	types.SigningAlgorithmSpecRsassaPkcs1V15Sha384: x509.SHA384WithRSA,
+	types.SigningAlgorithmSpecRsassaPkcs1V15Sha512: x509.SHA512WithRSA,
+	types.SigningAlgorithmSpecRsassaPssSha256:      x509.SHA256WithRSAPSS,
+	types.SigningAlgorithmSpecRsassaPssSha384:      x509.SHA384WithRSAPSS,
+	types.SigningAlgorithmSpecRsassaPssSha512:      x509.SHA512WithRSAPSS,
+	types.SigningAlgorithmSpecEcdsaSha256:          x509.ECDSAWithSHA256,
+	types.SigningAlgorithmSpecEcdsaSha384:          x509.ECDSAWithSHA384,
+	types.SigningAlgorithmSpecEcdsaSha512:          x509.ECDSAWithSHA512,
+}
+
+// kmsDefaultSigningAlgorithms maps KMS key specs to the signing algorithm used
+// unless one is configured, which is the one Go would pick for the key type.
+var kmsDefaultSigningAlgorithms = map[types.KeySpec]types.SigningAlgorithmSpec{
+	types.KeySpecRsa2048:     types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	types.KeySpecRsa3072:     types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	types.KeySpecRsa4096:     types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	types.KeySpecEccNistP256: types.SigningAlgorithmSpecEcdsaSha256,
+	types.KeySpecEccNistP384: types.SigningAlgorithmSpecEcdsaSha384,
+	types.KeySpecEccNistP521: types.SigningAlgorithmSpecEcdsaSha512,
+}
+
+// KMSSigner is an AWS KMS crypto.Signer bound to a single signing algorithm.
+//
+// Since KMS signs with the algorithm chosen up front (rather than the one in the
+// crypto.SignerOpts of each call), everything it signs must declare the matching
+// x509 signature algorithm, see SignatureAlgorithm.
+type KMSSigner struct {
+	*kmssigner.Signer
+	signatureAlgorithm x509.SignatureAlgorithm
+}
+
+// NewKMSSigner returns a crypto.Signer for an AWS KMS key. The signing algorithm is
+// derived from the key spec unless one is given (e.g. RSASSA_PSS_SHA_256), in which
+// case it must be one of the algorithms the key supports.
+func NewKMSSigner(ctx context.Context, cfg aws.Config, keyID string, signingAlgorithm string) (*KMSSigner, error) {
+	ctx, cancel := context.WithTimeout(ctx, describeKeyTimeout)
+	defer cancel()
+
+	output, err := kms.NewFromConfig(cfg).DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe KMS key %s: %v", keyID, err)
+	}
+	metadata := output.KeyMetadata
+	if metadata.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("KMS key %s has key usage %s, must be %s", keyID, metadata.KeyUsage, types.KeyUsageTypeSignVerify)
+	}
+
+	algorithm, signatureAlgorithm, err := selectKMSSigningAlgorithm(
+		metadata.KeySpec,
+		metadata.SigningAlgorithms,
+		types.SigningAlgorithmSpec(signingAlgorithm),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("KMS key %s: %v", keyID, err)
+	}
+
+	signer, err := kmssigner.NewSigner(cfg, keyID, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &KMSSigner{Signer: signer, signatureAlgorithm: signatureAlgorithm}, nil
+}
+
+// SignatureAlgorithm returns the x509 signature algorithm of the signer's signatures.
+func (s *KMSSigner) SignatureAlgorithm() x509.SignatureAlgorithm {
+	return s.signatureAlgorithm
+}
+
+// selectKMSSigningAlgorithm returns the signing algorithm to use with a KMS key (either
+// the requested one or the default for the key spec) and its x509 signature algorithm.
+func selectKMSSigningAlgorithm(
+	keySpec types.KeySpec,
+	supported []types.SigningAlgorithmSpec,
+	requested types.SigningAlgorithmSpec,
+) (types.SigningAlgorithmSpec, x509.SignatureAlgorithm, error) {
+	algorithm := requested
+	if algorithm == "" {
+		var ok bool
+		if algorithm, ok = kmsDefaultSigningAlgorithms[keySpec]; !ok {
+			return "", x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported key spec %s", keySpec)
+		}
+	}
+
+	signatureAlgorithm, ok := kmsSignatureAlgorithms[algorithm]
+	if !ok {
+		return "", x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	for _, s := range supported {
+		if s == algorithm {
+			return algorithm, signatureAlgorithm, nil
+		}
+	}
+	return "", x509.UnknownSignatureAlgorithm, fmt.Errorf("signing algorithm %s is not supported by key spec %s (supported: %v)", algorithm, keySpec, supported)
+}
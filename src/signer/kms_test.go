@@ -0,0 +1,147 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestSelectKMSSigningAlgorithm(t *testing.T) {
+	rsaAlgorithms := []types.SigningAlgorithmSpec{
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha384,
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha512,
+		types.SigningAlgorithmSpecRsassaPssSha256,
+		types.SigningAlgorithmSpecRsassaPssSha384,
+		types.SigningAlgorithmSpecRsassaPssSha512,
+	}
+
+	tests := []struct {
+		name      string
+		keySpec   types.KeySpec
+		supported []types.SigningAlgorithmSpec
+		requested types.SigningAlgorithmSpec
+		// expected is the expected signature algorithm, x509.UnknownSignatureAlgorithm if an error is expected
+		expected x509.SignatureAlgorithm
+	}{
+		{name: "rsa default", keySpec: types.KeySpecRsa3072, supported: rsaAlgorithms, expected: x509.SHA256WithRSA},
+		{
+			name:      "p256 default",
+			keySpec:   types.KeySpecEccNistP256,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha256},
+			expected:  x509.ECDSAWithSHA256,
+		},
+		{
+			name:      "p384 default",
+			keySpec:   types.KeySpecEccNistP384,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha384},
+			expected:  x509.ECDSAWithSHA384,
+		},
+		{
+			name:      "p521 default",
+			keySpec:   types.KeySpecEccNistP521,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha512},
+			expected:  x509.ECDSAWithSHA512,
+		},
+		{
+			name:      "requested rsa-pss",
+			keySpec:   types.KeySpecRsa2048,
+			supported: rsaAlgorithms,
+			requested: types.SigningAlgorithmSpecRsassaPssSha256,
+			expected:  x509.SHA256WithRSAPSS,
+		},
+		{
+			name:      "requested algorithm not supported by the key",
+			keySpec:   types.KeySpecEccNistP256,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha256},
+			requested: types.SigningAlgorithmSpecRsassaPssSha256,
+		},
+		{
+			name:      "requested algorithm unusable for certificates",
+			keySpec:   types.KeySpecEccSecgP256k1,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha256},
+			requested: types.SigningAlgorithmSpecSm2dsa,
+		},
+		{
+			name:      "unsupported key spec",
+			keySpec:   types.KeySpecEccSecgP256k1,
+			supported: []types.SigningAlgorithmSpec{types.SigningAlgorithmSpecEcdsaSha256},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			algorithm, signatureAlgorithm, err := selectKMSSigningAlgorithm(test.keySpec, test.supported, test.requested)
+			if test.expected == x509.UnknownSignatureAlgorithm {
+				if err == nil {
+					t.Fatalf("expected an error, got signing algorithm: %s", algorithm)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if signatureAlgorithm != test.expected {
+				t.Fatalf("expected signature algorithm %s, got: %s", test.expected, signatureAlgorithm)
+			}
+			if kmsSignatureAlgorithms[algorithm] != signatureAlgorithm {
+				t.Fatalf("expected signing algorithm %s to match signature algorithm %s", algorithm, signatureAlgorithm)
+			}
+		})
+	}
+}
+
+// boundSigner is a crypto.Signer bound to a single signature algorithm, like the KMSSigner.
+type boundSigner struct {
+	crypto.Signer
+	signatureAlgorithm x509.SignatureAlgorithm
+}
+
+func (s *boundSigner) SignatureAlgorithm() x509.SignatureAlgorithm {
+	return s.signatureAlgorithm
+}
+
+func TestVerifyPublicKeySignatureAlgorithm(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test ca"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		wantErr bool
+	}{
+		{name: "unbound signer", signer: key},
+		{name: "matching signature algorithm", signer: &boundSigner{Signer: key, signatureAlgorithm: x509.ECDSAWithSHA256}},
+		{name: "signature algorithm of another key type", signer: &boundSigner{Signer: key, signatureAlgorithm: x509.SHA256WithRSAPSS}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := VerifyPublicKey(test.signer, cert); (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
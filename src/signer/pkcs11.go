@@ -0,0 +1,52 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// NewPKCS11Signer returns a crypto.Signer for a key pair held by a PKCS#11 token.
+func NewPKCS11Signer(c *PKCS11Config) (crypto.Signer, error) {
+	var id, label []byte
+	if c.KeyID != "" {
+		var err error
+		if id, err = hex.DecodeString(c.KeyID); err != nil {
+			return nil, fmt.Errorf("invalid pkcs11 key_id: %v", err)
+		}
+	}
+	if c.KeyLabel != "" {
+		label = []byte(c.KeyLabel)
+	}
+
+	var pin string
+	if c.PINEnv != "" {
+		pin = os.Getenv(c.PINEnv)
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:        c.ModulePath,
+		TokenLabel:  c.TokenLabel,
+		TokenSerial: c.TokenSerial,
+		SlotNumber:  c.SlotNumber,
+		Pin:         pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize pkcs11 token: %v", err)
+	}
+
+	signer, err := ctx.FindKeyPair(id, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pkcs11 key pair: %v", err)
+	}
+	if signer == nil {
+		return nil, errors.New("pkcs11 key pair not found")
+	}
+	return signer, nil
+}
@@ -0,0 +1,15 @@
+package signer
+
+// PKCS11Config represents the configuration of a PKCS#11 (HSM) signer. The token is
+// selected by exactly one of its label, serial number or slot number, and the key by
+// its label and/or (hex encoded) id.
+type PKCS11Config struct {
+	ModulePath  string `yaml:"module_path"`
+	TokenLabel  string `yaml:"token_label"`
+	TokenSerial string `yaml:"token_serial"`
+	SlotNumber  *int   `yaml:"slot_number"`
+	// PINEnv is the environment variable holding the user PIN.
+	PINEnv   string `yaml:"pin_env"`
+	KeyLabel string `yaml:"key_label"`
+	KeyID    string `yaml:"key_id"`
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+	"errors"
+)
+
+// NewPKCS11Signer returns a crypto.Signer for a key pair held by a PKCS#11 token.
+// PKCS#11 support requires cgo, which this binary was built without.
+func NewPKCS11Signer(c *PKCS11Config) (crypto.Signer, error) {
+	return nil, errors.New("pkcs11 signer is not supported by binaries built without cgo")
+}
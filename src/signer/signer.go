@@ -0,0 +1,152 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// supported signer backends.
+const (
+	TypeKMS    = "kms"
+	TypeFile   = "file"
+	TypePKCS11 = "pkcs11"
+)
+
+// Config represents the configuration of the signer holding the CA's private key.
+type Config struct {
+	Type   string        `yaml:"type"`
+	KMS    *KMSConfig    `yaml:"kms"`
+	File   *FileConfig   `yaml:"file"`
+	PKCS11 *PKCS11Config `yaml:"pkcs11"`
+}
+
+// KMSConfig represents the configuration of an AWS KMS signer.
+type KMSConfig struct {
+	KeyID string `yaml:"key_id"`
+	// SigningAlgorithm optionally overrides the KMS signing algorithm otherwise
+	// derived from the key spec e.g. RSASSA_PSS_SHA_256 for RSA-PSS signatures.
+	SigningAlgorithm string `yaml:"signing_algorithm"`
+}
+
+// FileConfig represents the configuration of a PEM key file signer.
+type FileConfig struct {
+	Path string `yaml:"path"`
+	// PassphraseEnv is the environment variable holding
+	// the passphrase of an encrypted key file (if any).
+	PassphraseEnv string `yaml:"passphrase_env"`
+}
+
+// Validate checks the configuration for errors without initializing the signer.
+func (c *Config) Validate() error {
+	switch c.Type {
+	case TypeKMS:
+		if c.KMS == nil || c.KMS.KeyID == "" {
+			return errors.New("kms signer requires a key_id")
+		}
+		if algorithm := c.KMS.SigningAlgorithm; algorithm != "" {
+			if _, ok := kmsSignatureAlgorithms[types.SigningAlgorithmSpec(algorithm)]; !ok {
+				return fmt.Errorf("unsupported kms signing_algorithm %q", algorithm)
+			}
+		}
+	case TypeFile:
+		if c.File == nil || c.File.Path == "" {
+			return errors.New("file signer requires a path")
+		}
+	case TypePKCS11:
+		if c.PKCS11 == nil || c.PKCS11.ModulePath == "" {
+			return errors.New("pkcs11 signer requires a module_path")
+		}
+		if c.PKCS11.KeyLabel == "" && c.PKCS11.KeyID == "" {
+			return errors.New("pkcs11 signer requires a key_label or key_id")
+		}
+	default:
+		return fmt.Errorf("unsupported signer type %q, must be one of %q, %q or %q", c.Type, TypeKMS, TypeFile, TypePKCS11)
+	}
+	return nil
+}
+
+// New returns the crypto.Signer selected by the configuration.
+func New(ctx context.Context, c *Config) (crypto.Signer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case TypeKMS:
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS SDK config: %v", err)
+		}
+		signer, err := NewKMSSigner(ctx, cfg, c.KMS.KeyID, c.KMS.SigningAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize KMS signer: %v", err)
+		}
+		return signer, nil
+	case TypeFile:
+		var passphrase []byte
+		if c.File.PassphraseEnv != "" {
+			passphrase = []byte(os.Getenv(c.File.PassphraseEnv))
+		}
+		return NewFileSigner(c.File.Path, passphrase)
+	default:
+		return NewPKCS11Signer(c.PKCS11)
+	}
+}
+
+// algorithmBoundSigner is a signer that only produces one kind of signature.
+type algorithmBoundSigner interface {
+	SignatureAlgorithm() x509.SignatureAlgorithm
+}
+
+// SignatureAlgorithm returns the x509 signature algorithm a signer is bound to,
+// or x509.UnknownSignatureAlgorithm if it signs with whatever it is asked to.
+func SignatureAlgorithm(signer crypto.Signer) x509.SignatureAlgorithm {
+	if s, ok := signer.(algorithmBoundSigner); ok {
+		return s.SignatureAlgorithm()
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// VerifyPublicKey verifies that the public key of a signer is that of a certificate,
+// and that the signer's signature algorithm (if bound to one) suits the key type.
+func VerifyPublicKey(signer crypto.Signer, cert *x509.Certificate) error {
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return fmt.Errorf("unsupported signer public key type %T", signer.Public())
+	}
+	if !pub.Equal(cert.PublicKey) {
+		return errors.New("signer public key does not match the issuer certificate")
+	}
+
+	signatureAlgorithm := SignatureAlgorithm(signer)
+	if signatureAlgorithm == x509.UnknownSignatureAlgorithm {
+		return nil
+	}
+	if keyAlgorithm := publicKeyAlgorithmOf(signatureAlgorithm); keyAlgorithm != cert.PublicKeyAlgorithm {
+		return fmt.Errorf(
+			"signer signature algorithm %s requires a %s key but the issuer certificate has a %s key",
+			signatureAlgorithm, keyAlgorithm, cert.PublicKeyAlgorithm,
+		)
+	}
+	return nil
+}
+
+func publicKeyAlgorithmOf(signatureAlgorithm x509.SignatureAlgorithm) x509.PublicKeyAlgorithm {
+	switch signatureAlgorithm {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return x509.RSA
+	case x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return x509.ECDSA
+	case x509.PureEd25519:
+		return x509.Ed25519
+	default:
+		return x509.UnknownPublicKeyAlgorithm
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+)
+
+// FileStore is a local file implementation of the CertificateStore interface.
+// Records are kept in memory and every change is appended to a JSON lines
+// file, which is replayed (latest record for a serial number wins) on startup.
+type FileStore struct {
+	mu     sync.Mutex
+	memory *MemoryStore
+	file   *os.File
+}
+
+// ensure FileStore implements CertificateStore.
+var _ CertificateStore = (*FileStore)(nil)
+
+// NewFileStore returns a local file implementation of the CertificateStore interface.
+func NewFileStore(path string) (*FileStore, error) {
+	memory := NewMemoryStore()
+
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open certificates file: %v", err)
+	}
+	if err == nil {
+		defer existing.Close()
+		if err = replay(existing, memory, path); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open certificates file for writing: %v", err)
+	}
+
+	return &FileStore{memory: memory, file: file}, nil
+}
+
+// replay loads the records of a certificates file into a MemoryStore. Records are written
+// (and synced) one line at a time, so only the last line can be partially written, by a
+// crash while writing it. Its write never returned, so the record was never acknowledged,
+// and it is truncated such that further records are appended on a line of their own.
+func replay(existing *os.File, memory *MemoryStore, path string) error {
+	reader := bufio.NewReader(existing)
+	size := int64(0)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				log.Printf("discarding partially written record at line %d of certificates file", line)
+				if err = os.Truncate(path, size); err != nil {
+					return fmt.Errorf("failed to truncate partially written certificate record: %v", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read certificates file: %v", err)
+		}
+		size += int64(len(data))
+
+		var r Record
+		if err = json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("failed to json-decode line %d of certificates file: %v", line, err)
+		}
+		memory.records[r.SerialNumber] = &r
+	}
+}
+
+// Close closes the underlying file.
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
+
+// Put adds a certificate to the store.
+func (s *FileStore) Put(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.memory.has(r.SerialNumber) {
+		return fmt.Errorf("certificate with serial number %s already in store", r.SerialNumber)
+	}
+	if err := s.append(r); err != nil {
+		return err
+	}
+	return s.memory.Put(r)
+}
+
+// Get returns the certificate with the given serial number.
+func (s *FileStore) Get(serialNumber *big.Int) (*Record, error) {
+	return s.memory.Get(serialNumber)
+}
+
+// UpdateStatus sets the status of the certificate with the given serial number.
+func (s *FileStore) UpdateStatus(serialNumber *big.Int, status Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.memory.Get(serialNumber)
+	if err != nil {
+		return err
+	}
+	updated := *r
+	updated.Status = status
+	if err = s.append(&updated); err != nil {
+		return err
+	}
+	return s.memory.UpdateStatus(serialNumber, status)
+}
+
+// List returns all certificates in the store, ordered by issuance time.
+func (s *FileStore) List() ([]*Record, error) {
+	return s.memory.List()
+}
+
+// Search returns a page of the records matching a query, ordered by issuance time.
+func (s *FileStore) Search(q *Query) ([]*Record, int, error) {
+	return s.memory.Search(q)
+}
+
+// append must be called with the lock held.
+func (s *FileStore) append(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode certificate record: %v", err)
+	}
+	if _, err = s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write certificate record: %v", err)
+	}
+	if err = s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync certificates file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestRecord returns a certificate record with the given serial number.
+func newTestRecord(serialNumber int64) *Record {
+	return &Record{
+		SerialNumber: big.NewInt(serialNumber).String(),
+		Subject:      "CN=a.example.com",
+		DNSNames:     []string{"a.example.com"},
+		NotBefore:    time.Now().Add(-time.Minute).UTC(),
+		NotAfter:     time.Now().Add(time.Hour).UTC(),
+		Status:       StatusValid,
+		IssuedAt:     time.Now().UTC(),
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certificates.jsonl")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to create file store: %v", err)
+	}
+	for _, serialNumber := range []int64{1, 2} {
+		if err = s.Put(newTestRecord(serialNumber)); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err = s.UpdateStatus(big.NewInt(1), StatusRevoked); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("failed to close file store: %v", err)
+	}
+
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen file store: %v", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		serialNumber int64
+		// status is the status of the latest record for the serial number
+		status Status
+	}{
+		{serialNumber: 1, status: StatusRevoked},
+		{serialNumber: 2, status: StatusValid},
+	}
+	for _, test := range tests {
+		r, err := s.Get(big.NewInt(test.serialNumber))
+		if err != nil {
+			t.Fatalf("expected record %d to be replayed, got: %v", test.serialNumber, err)
+		}
+		if r.Status != test.status {
+			t.Fatalf("expected record %d status %s, got: %s", test.serialNumber, test.status, r.Status)
+		}
+	}
+	if err = s.Put(newTestRecord(2)); err == nil {
+		t.Fatal("expected an error putting a replayed serial number again")
+	}
+}
+
+func TestFileStoreReplayTruncation(t *testing.T) {
+	tests := []struct {
+		name string
+		// tail is appended to a file with a single complete record
+		tail string
+		// wantErr is whether the file must be rejected rather than replayed
+		wantErr bool
+	}{
+		{name: "complete records"},
+		{name: "partially written last record", tail: `{"serial_number":"2","sub`},
+		{name: "corrupt complete record", tail: "{\"serial_number\":\"2\",\"sub\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "certificates.jsonl")
+			s, err := NewFileStore(path)
+			if err != nil {
+				t.Fatalf("failed to create file store: %v", err)
+			}
+			if err = s.Put(newTestRecord(1)); err != nil {
+				t.Fatalf("failed to put record: %v", err)
+			}
+			s.Close()
+			complete, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read certificates file: %v", err)
+			}
+			if err = os.WriteFile(path, append(complete, test.tail...), 0600); err != nil {
+				t.Fatalf("failed to write certificates file: %v", err)
+			}
+
+			s, err = NewFileStore(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			// the partial record is discarded, and the next one gets a line of its own
+			if err = s.Put(newTestRecord(3)); err != nil {
+				t.Fatalf("failed to put record: %v", err)
+			}
+			s.Close()
+			s, err = NewFileStore(path)
+			if err != nil {
+				t.Fatalf("failed to reopen file store: %v", err)
+			}
+			defer s.Close()
+			records, err := s.List()
+			if err != nil {
+				t.Fatalf("failed to list records: %v", err)
+			}
+			if len(records) != 2 {
+				t.Fatalf("expected %d records, got: %d", 2, len(records))
+			}
+			if _, err = s.Get(big.NewInt(2)); err == nil {
+				t.Fatal("expected the partially written record to be discarded")
+			}
+		})
+	}
+}
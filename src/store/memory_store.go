@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the CertificateStore interface.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// ensure MemoryStore implements CertificateStore.
+var _ CertificateStore = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an in-memory implementation of the CertificateStore interface.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+// Put adds a certificate to the store.
+func (s *MemoryStore) Put(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[r.SerialNumber]; ok {
+		return fmt.Errorf("certificate with serial number %s already in store", r.SerialNumber)
+	}
+	s.records[r.SerialNumber] = r
+	return nil
+}
+
+// Get returns the certificate with the given serial number.
+func (s *MemoryStore) Get(serialNumber *big.Int) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.records[serialNumber.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+// UpdateStatus sets the status of the certificate with the given serial number.
+func (s *MemoryStore) UpdateStatus(serialNumber *big.Int, status Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[serialNumber.String()]
+	if !ok {
+		return ErrNotFound
+	}
+	updated := *r
+	updated.Status = status
+	s.records[r.SerialNumber] = &updated
+	return nil
+}
+
+// List returns all certificates in the store, ordered by issuance time.
+func (s *MemoryStore) List() ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].IssuedAt.Equal(records[j].IssuedAt) {
+			return records[i].SerialNumber < records[j].SerialNumber
+		}
+		return records[i].IssuedAt.Before(records[j].IssuedAt)
+	})
+	return records, nil
+}
+
+// Search returns a page of the records matching a query, ordered by issuance time.
+func (s *MemoryStore) Search(q *Query) ([]*Record, int, error) {
+	records, err := s.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	matches := []*Record{}
+	for _, r := range records {
+		if q.Matches(r, now) {
+			matches = append(matches, r)
+		}
+	}
+
+	total := len(matches)
+	if q.Offset >= total {
+		return []*Record{}, total, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, total, nil
+}
+
+func (s *MemoryStore) has(serialNumber string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.records[serialNumber]
+	return ok
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Status represents the status of an issued certificate.
+type Status string
+
+const (
+	// StatusValid is the status of issued certificates which have not been revoked.
+	StatusValid Status = "valid"
+	// StatusRevoked is the status of revoked certificates.
+	StatusRevoked Status = "revoked"
+	// StatusExpired is never stored, but reported by Record.StatusAt
+	// for non-revoked certificates past their NotAfter time.
+	StatusExpired Status = "expired"
+)
+
+// ErrNotFound is returned by a CertificateStore when a certificate is not in the store.
+var ErrNotFound = errors.New("certificate not found")
+
+// Record represents an issued certificate in the inventory.
+type Record struct {
+	SerialNumber         string    `json:"serial_number"`
+	Subject              string    `json:"subject"`
+	DNSNames             []string  `json:"dns_names"`
+	IPAddresses          []string  `json:"ip_addresses"`
+	EmailAddresses       []string  `json:"email_addresses"`
+	URIs                 []string  `json:"uris"`
+	NotBefore            time.Time `json:"not_before"`
+	NotAfter             time.Time `json:"not_after"`
+	PublicKeyFingerprint string    `json:"public_key_fingerprint"`
+	Status               Status    `json:"status"`
+	IssuedAt             time.Time `json:"issued_at"`
+	Raw                  []byte    `json:"raw"`
+}
+
+// StatusAt returns the status of the certificate at a given time.
+func (r *Record) StatusAt(t time.Time) Status {
+	if r.Status == StatusValid && t.After(r.NotAfter) {
+		return StatusExpired
+	}
+	return r.Status
+}
+
+// NewRecord returns the inventory record for a newly issued certificate.
+func NewRecord(cert *x509.Certificate) (*Record, error) {
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKIX public key: %v", err)
+	}
+	hash := sha256.Sum256(publicKeyDER)
+
+	ipAddresses := []string{}
+	for _, ip := range cert.IPAddresses {
+		ipAddresses = append(ipAddresses, ip.String())
+	}
+
+	uris := []string{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return &Record{
+		SerialNumber:         cert.SerialNumber.String(),
+		Subject:              cert.Subject.String(),
+		DNSNames:             append([]string{}, cert.DNSNames...),
+		IPAddresses:          ipAddresses,
+		EmailAddresses:       append([]string{}, cert.EmailAddresses...),
+		URIs:                 uris,
+		NotBefore:            cert.NotBefore.UTC(),
+		NotAfter:             cert.NotAfter.UTC(),
+		PublicKeyFingerprint: hex.EncodeToString(hash[:]),
+		Status:               StatusValid,
+		IssuedAt:             time.Now().UTC(),
+		Raw:                  cert.Raw,
+	}, nil
+}
+
+// Query represents search criteria for certificates in a CertificateStore.
+// Zero-valued fields are ignored, and a zero Limit means no limit.
+type Query struct {
+	DNSName              string
+	PublicKeyFingerprint string
+	ExpiringBefore       time.Time
+	Status               Status
+	Offset               int
+	Limit                int
+}
+
+// Matches returns true if a record matches the query's criteria at a given time.
+func (q *Query) Matches(r *Record, now time.Time) bool {
+	// DNS names are case-insensitive
+	if q.DNSName != "" && !containsFold(r.DNSNames, q.DNSName) {
+		return false
+	}
+	if q.PublicKeyFingerprint != "" && !strings.EqualFold(q.PublicKeyFingerprint, r.PublicKeyFingerprint) {
+		return false
+	}
+	if !q.ExpiringBefore.IsZero() && !r.NotAfter.Before(q.ExpiringBefore) {
+		return false
+	}
+	if q.Status != "" && r.StatusAt(now) != q.Status {
+		return false
+	}
+	return true
+}
+
+// CertificateStore represents an entity capable of
+// persisting an inventory of issued certificates.
+type CertificateStore interface {
+	Put(*Record) error
+	Get(serialNumber *big.Int) (*Record, error)
+	UpdateStatus(serialNumber *big.Int, status Status) error
+	List() ([]*Record, error)
+	// Search returns a page of the records matching a query
+	// along with the total number of matching records.
+	Search(*Query) ([]*Record, int, error)
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package template
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+)
+
+// boundNamesBuilder is an internal-only implementation of the CertificateTemplateBuilder interface
+// which issues certificates for a fixed set of subject alternative names, regardless of those
+// requested in the CSR, e.g. names derived from the caller's authenticated identity. The subject
+// requested in the CSR is dropped, since it could otherwise name an identity other than the caller's.
+type boundNamesBuilder struct {
+	base           CertificateTemplateBuilder
+	dnsNames       []string
+	emailAddresses []string
+	uris           []*url.URL
+}
+
+// ensure boundNamesBuilder implements CertificateTemplateBuilder.
+var _ CertificateTemplateBuilder = (*boundNamesBuilder)(nil)
+
+// WithBoundNames returns a CertificateTemplateBuilder which builds templates with the given
+// builder and then replaces their subject alternative names with the given names, and clears their subject.
+func WithBoundNames(
+	base CertificateTemplateBuilder,
+	dnsNames []string,
+	emailAddresses []string,
+	uris []*url.URL,
+) CertificateTemplateBuilder {
+	return &boundNamesBuilder{
+		base:           base,
+		dnsNames:       dnsNames,
+		emailAddresses: emailAddresses,
+		uris:           uris,
+	}
+}
+
+// BuildTemplate builds a certificate template based off of a given certificate signing request (CSR).
+func (b *boundNamesBuilder) BuildTemplate(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	template, err := b.base.BuildTemplate(csr)
+	if err != nil {
+		return nil, err
+	}
+	template.DNSNames = b.dnsNames
+	template.EmailAddresses = b.emailAddresses
+	template.URIs = b.uris
+	template.IPAddresses = nil
+	template.Subject = pkix.Name{}
+	template.RawSubject = nil
+	return template, nil
+}
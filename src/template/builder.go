@@ -1,9 +1,12 @@
 package template
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
-	"math/big"
-	"math/rand"
+	"fmt"
 	"time"
 )
 
@@ -16,24 +19,146 @@ type CertificateTemplateBuilder interface {
 // templateBuilder is an internal-only implementation
 // of the CertificateTemplateBuilder interface.
 type templateBuilder struct {
-	clockSkew time.Duration
-	lifespan  time.Duration
+	clockSkew    time.Duration
+	lifespan     time.Duration
+	serialNumber SerialNumberGenerator
+	keyUsage     x509.KeyUsage
+	extKeyUsage  []x509.ExtKeyUsage
+
+	isCA            bool
+	maxPathLen      int
+	nameConstraints *NameConstraints
+
+	// principals restricts the builder to the given "<method>:<name>" principals, if not empty
+	principals []string
+}
+
+// Option represents a configuration option for the default CertificateTemplateBuilder.
+type Option func(*templateBuilder)
+
+// WithSerialNumberGenerator sets the SerialNumberGenerator used for issued certificates.
+func WithSerialNumberGenerator(generator SerialNumberGenerator) Option {
+	return func(t *templateBuilder) { t.serialNumber = generator }
+}
+
+// WithKeyUsage sets the key usage of issued certificates, less any
+// usages the subject public key type does not allow (see BuildTemplate).
+func WithKeyUsage(keyUsage x509.KeyUsage) Option {
+	return func(t *templateBuilder) { t.keyUsage = keyUsage }
+}
+
+// WithExtKeyUsage sets the extended key usages of issued certificates.
+func WithExtKeyUsage(extKeyUsage ...x509.ExtKeyUsage) Option {
+	return func(t *templateBuilder) { t.extKeyUsage = extKeyUsage }
+}
+
+// WithCA makes issued certificates subordinate CA certificates (with the CertSign and CRLSign
+// key usages) allowing at most maxPathLen CA certificates below them, or any if negative.
+func WithCA(maxPathLen int) Option {
+	return func(t *templateBuilder) {
+		t.isCA = true
+		t.maxPathLen = maxPathLen
+	}
+}
+
+// WithNameConstraints sets the name constraints of issued CA certificates (see WithCA).
+// It has no effect on builders which do not issue CA certificates.
+func WithNameConstraints(nameConstraints *NameConstraints) Option {
+	return func(t *templateBuilder) { t.nameConstraints = nameConstraints }
+}
+
+// WithPrincipals restricts the builder to callers authenticated as one of the given principals,
+// each of the form "<method>:<name>" e.g. "hmac:ci-runner" (see Permits). The method is part of
+// the principal since authentication methods name principals independently of one another.
+// Builders without this option may be used by any caller.
+func WithPrincipals(principals ...string) Option {
+	return func(t *templateBuilder) { t.principals = principals }
+}
+
+// Permits returns true if a CertificateTemplateBuilder may be used by the principal with the
+// given authentication method and name (both empty for unauthenticated callers), see WithPrincipals.
+func Permits(builder CertificateTemplateBuilder, method, name string) bool {
+	if b, ok := builder.(interface{ Permits(string, string) bool }); ok {
+		return b.Permits(method, name)
+	}
+	return true
+}
+
+// Permits returns true if the builder may be used by the principal with the given method and name.
+func (t *templateBuilder) Permits(method, name string) bool {
+	if len(t.principals) == 0 {
+		return true
+	}
+	if method == "" || name == "" {
+		return false
+	}
+	for _, permitted := range t.principals {
+		if permitted == method+":"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCA returns true if a CertificateTemplateBuilder builds CA certificate templates.
+func IsCA(builder CertificateTemplateBuilder) bool {
+	if b, ok := builder.(interface{ IsCA() bool }); ok {
+		return b.IsCA()
+	}
+	return false
+}
+
+// IsCA returns true if the builder builds CA certificate templates.
+func (t *templateBuilder) IsCA() bool {
+	return t.isCA
 }
 
 // BuildTemplate builds a certificate template based off of a given certificate signing request (CSR).
+// The key usage is restricted to the operations possible with the CSR's public key type.
 func (t *templateBuilder) BuildTemplate(csr *x509.CertificateRequest) (*x509.Certificate, error) {
-	return &x509.Certificate{
-		SerialNumber: big.NewInt(rand.Int63()),
+	serialNumber, err := t.serialNumber.SerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
 		NotBefore:    time.Now().Add(-1 * t.clockSkew),
 		NotAfter:     time.Now().Add(t.lifespan),
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:     keyUsageFor(csr.PublicKey, t.keyUsage),
+		ExtKeyUsage:  t.extKeyUsage,
 
 		// fields from CSR, tweak as needed
 		Subject:     csr.Subject,
 		IPAddresses: csr.IPAddresses,
 		DNSNames:    csr.DNSNames,
-	}, nil
+	}
+	if t.isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.MaxPathLen = t.maxPathLen
+		template.MaxPathLenZero = t.maxPathLen == 0
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		t.nameConstraints.apply(template)
+	}
+	return template, nil
+}
+
+// keyUsageFor restricts a key usage to the operations possible with a public key:
+// only RSA keys can encipher keys or data, only ECDSA keys can agree keys, and
+// encipher only and decipher only are meaningless without key agreement.
+func keyUsageFor(pub crypto.PublicKey, keyUsage x509.KeyUsage) x509.KeyUsage {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		keyUsage &^= x509.KeyUsageKeyAgreement
+	case *ecdsa.PublicKey:
+		keyUsage &^= x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment
+	case ed25519.PublicKey:
+		keyUsage &^= x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement
+	}
+	if keyUsage&x509.KeyUsageKeyAgreement == 0 {
+		keyUsage &^= x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly
+	}
+	return keyUsage
 }
 
 // ensure templateBuilder implements CertificateTemplateBuilder.
@@ -43,9 +168,17 @@ var _ CertificateTemplateBuilder = (*templateBuilder)(nil)
 func New(
 	clockSkew time.Duration,
 	lifespan time.Duration,
+	opts ...Option,
 ) CertificateTemplateBuilder {
-	return &templateBuilder{
-		clockSkew: clockSkew,
-		lifespan:  lifespan,
+	t := &templateBuilder{
+		clockSkew:    clockSkew,
+		lifespan:     lifespan,
+		serialNumber: NewRandomSerialNumberGenerator(),
+		keyUsage:     x509.KeyUsageDigitalSignature,
+		extKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	return t
 }
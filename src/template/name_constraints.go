@@ -0,0 +1,36 @@
+package template
+
+import (
+	"crypto/x509"
+	"net"
+)
+
+// NameConstraints represents the name constraints (RFC 5280 section 4.2.1.10)
+// of issued CA certificates, limiting the names their subtree may certify.
+type NameConstraints struct {
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+	PermittedURIDomains     []string
+	ExcludedURIDomains      []string
+}
+
+// apply sets the name constraints on a certificate template, marking the
+// extension critical as required by RFC 5280 section 4.2.1.10.
+func (n *NameConstraints) apply(template *x509.Certificate) {
+	if n == nil {
+		return
+	}
+	template.PermittedDNSDomainsCritical = true
+	template.PermittedDNSDomains = n.PermittedDNSDomains
+	template.ExcludedDNSDomains = n.ExcludedDNSDomains
+	template.PermittedIPRanges = n.PermittedIPRanges
+	template.ExcludedIPRanges = n.ExcludedIPRanges
+	template.PermittedEmailAddresses = n.PermittedEmailAddresses
+	template.ExcludedEmailAddresses = n.ExcludedEmailAddresses
+	template.PermittedURIDomains = n.PermittedURIDomains
+	template.ExcludedURIDomains = n.ExcludedURIDomains
+}
@@ -0,0 +1,40 @@
+package template
+
+import (
+	"crypto/x509"
+)
+
+// prebuiltBuilder is an internal-only implementation of the CertificateTemplateBuilder
+// interface which returns a certificate template built ahead of time (see Prebuild).
+type prebuiltBuilder struct {
+	template *x509.Certificate
+}
+
+// ensure prebuiltBuilder implements CertificateTemplateBuilder.
+var _ CertificateTemplateBuilder = (*prebuiltBuilder)(nil)
+
+// Prebuild builds the certificate template for a CSR with the given builder before the
+// certificate is signed, such that its serial number (and names) can be recorded ahead
+// of signing, e.g. in an issuance intent. It returns the template and a builder which
+// returns (a copy of) it, which must only be used to issue the certificate for that CSR.
+func Prebuild(
+	base CertificateTemplateBuilder,
+	csr *x509.CertificateRequest,
+) (*x509.Certificate, CertificateTemplateBuilder, error) {
+	template, err := base.BuildTemplate(csr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, &prebuiltBuilder{template: template}, nil
+}
+
+// IsCA returns true if the prebuilt template is a CA certificate template.
+func (p *prebuiltBuilder) IsCA() bool {
+	return p.template.IsCA
+}
+
+// BuildTemplate returns a copy of the prebuilt certificate template.
+func (p *prebuiltBuilder) BuildTemplate(*x509.CertificateRequest) (*x509.Certificate, error) {
+	template := *p.template
+	return &template, nil
+}
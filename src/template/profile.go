@@ -0,0 +1,186 @@
+package template
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Profile represents the configuration of a named certificate profile.
+type Profile struct {
+	KeyUsage    []string      `yaml:"key_usage"`
+	ExtKeyUsage []string      `yaml:"ext_key_usage"`
+	Lifespan    time.Duration `yaml:"lifespan"`
+	ClockSkew   time.Duration `yaml:"clock_skew"`
+	// Principals restricts the profile to the given (non-privileged) principals, if set,
+	// each of the form "<method>:<name>" e.g. "hmac:ci-runner" (see WithPrincipals).
+	Principals []string `yaml:"principals"`
+	// CA makes the profile issue subordinate CA certificates, if set.
+	CA *CAProfile `yaml:"ca"`
+}
+
+// CAProfile represents the configuration of a subordinate CA certificate profile.
+type CAProfile struct {
+	// MaxPathLen is the maximum number of CA certificates below issued CA
+	// certificates, or -1 for no limit. Defaults to 0 i.e. issuing CAs only.
+	MaxPathLen int `yaml:"max_path_len"`
+}
+
+// Builder returns the CertificateTemplateBuilder for the profile.
+func (p *Profile) Builder(opts ...Option) (CertificateTemplateBuilder, error) {
+	if p.Lifespan <= 0 {
+		return nil, fmt.Errorf("lifespan must be positive")
+	}
+	if p.ClockSkew < 0 {
+		return nil, fmt.Errorf("clock skew must not be negative")
+	}
+
+	var keyUsage x509.KeyUsage
+	for _, name := range p.KeyUsage {
+		usage, ok := keyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown key usage %q", name)
+		}
+		keyUsage |= usage
+	}
+
+	extKeyUsage := []x509.ExtKeyUsage{}
+	for _, name := range p.ExtKeyUsage {
+		usage, ok := extKeyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %q", name)
+		}
+		extKeyUsage = append(extKeyUsage, usage)
+	}
+
+	opts = append([]Option{WithKeyUsage(keyUsage), WithExtKeyUsage(extKeyUsage...)}, opts...)
+	for _, principal := range p.Principals {
+		if method, name, ok := strings.Cut(principal, ":"); !ok || method == "" || name == "" {
+			return nil, fmt.Errorf("principal %q must be of the form <method>:<name>", principal)
+		}
+	}
+	if len(p.Principals) > 0 {
+		opts = append(opts, WithPrincipals(p.Principals...))
+	}
+	if p.CA != nil {
+		if p.CA.MaxPathLen < -1 {
+			return nil, fmt.Errorf("ca max path length must be at least -1")
+		}
+		opts = append(opts, WithCA(p.CA.MaxPathLen))
+	}
+	return New(p.ClockSkew, p.Lifespan, opts...), nil
+}
+
+// ExtKeyUsagesNotAllowedBy returns the extended key usages of the profile which an issuer
+// certificate does not allow. Verifiers such as Go's crypto/x509 require the extended key
+// usages of a certificate to be allowed by those of every CA certificate above it (if
+// the CA certificate has any), so certificates with them would not verify.
+func (p *Profile) ExtKeyUsagesNotAllowedBy(issuer *x509.Certificate) []string {
+	notAllowed := []string{}
+	if len(issuer.ExtKeyUsage) == 0 && len(issuer.UnknownExtKeyUsage) == 0 {
+		return notAllowed
+	}
+	for _, name := range p.ExtKeyUsage {
+		allowed := false
+		for _, issuerUsage := range issuer.ExtKeyUsage {
+			allowed = allowed || issuerUsage == x509.ExtKeyUsageAny || issuerUsage == extKeyUsages[name]
+		}
+		if !allowed {
+			notAllowed = append(notAllowed, name)
+		}
+	}
+	return notAllowed
+}
+
+// ProfilesConfig represents the configuration of all certificate profiles.
+type ProfilesConfig struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// HasCAProfile returns true if any profile issues subordinate CA certificates.
+func (c *ProfilesConfig) HasCAProfile() bool {
+	for _, profile := range c.Profiles {
+		if profile.CA != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry resolves certificate profile names to CertificateTemplateBuilders.
+type Registry struct {
+	defaultProfile string
+	builders       map[string]CertificateTemplateBuilder
+}
+
+// NewRegistry returns a Registry with a CertificateTemplateBuilder for every configured
+// profile. The given options (e.g. a serial number generator) apply to every profile.
+func NewRegistry(config *ProfilesConfig, opts ...Option) (*Registry, error) {
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("no certificate profiles configured")
+	}
+	if _, ok := config.Profiles[config.Default]; !ok {
+		return nil, fmt.Errorf("default profile %q is not configured", config.Default)
+	}
+
+	r := &Registry{
+		defaultProfile: config.Default,
+		builders:       make(map[string]CertificateTemplateBuilder),
+	}
+	for name, profile := range config.Profiles {
+		builder, err := profile.Builder(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %v", name, err)
+		}
+		r.builders[name] = builder
+	}
+	return r, nil
+}
+
+// Resolve returns the CertificateTemplateBuilder for a profile,
+// or for the default profile if the given name is empty.
+func (r *Registry) Resolve(name string) (CertificateTemplateBuilder, error) {
+	if name == "" {
+		name = r.defaultProfile
+	}
+	builder, ok := r.builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown certificate profile %q, must be one of %v", name, r.Names())
+	}
+	return builder, nil
+}
+
+// Names returns the (sorted) names of all profiles in the registry.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.builders))
+	for name := range r.builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package template
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermits(t *testing.T) {
+	restricted, err := (&Profile{Lifespan: time.Hour, Principals: []string{"hmac:ci-runner", "mtls:spiffe://example.com/ci"}}).Builder()
+	if err != nil {
+		t.Fatalf("failed to build profile: %v", err)
+	}
+	unrestricted, err := (&Profile{Lifespan: time.Hour}).Builder()
+	if err != nil {
+		t.Fatalf("failed to build profile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		builder CertificateTemplateBuilder
+		method  string
+		pname   string
+		permits bool
+	}{
+		{name: "permitted principal", builder: restricted, method: "hmac", pname: "ci-runner", permits: true},
+		{name: "permitted principal with colons in its name", builder: restricted, method: "mtls", pname: "spiffe://example.com/ci", permits: true},
+		{name: "same name by another method", builder: restricted, method: "mtls", pname: "ci-runner"},
+		{name: "same name by a method whose names are chosen by clients", builder: restricted, method: "oidc", pname: "ci-runner"},
+		{name: "other principal", builder: restricted, method: "hmac", pname: "other"},
+		{name: "unauthenticated", builder: restricted},
+		{name: "unrestricted", builder: unrestricted, method: "bearer", pname: "anyone", permits: true},
+		{name: "unrestricted unauthenticated", builder: unrestricted, permits: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if permits := Permits(test.builder, test.method, test.pname); permits != test.permits {
+				t.Fatalf("expected permits: %t, got: %t", test.permits, permits)
+			}
+		})
+	}
+}
+
+func TestProfileBuilderPrincipals(t *testing.T) {
+	tests := []struct {
+		name       string
+		principals []string
+		wantErr    bool
+	}{
+		{name: "none"},
+		{name: "namespaced", principals: []string{"hmac:ci-runner", "bearer:deployer"}},
+		{name: "without method", principals: []string{"ci-runner"}, wantErr: true},
+		{name: "empty method", principals: []string{":ci-runner"}, wantErr: true},
+		{name: "empty name", principals: []string{"hmac:"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := (&Profile{Lifespan: time.Hour, Principals: test.principals}).Builder()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %t, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
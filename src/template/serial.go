@@ -0,0 +1,95 @@
+package template
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/adrianosela/ca/src/store"
+)
+
+const (
+	// defaultSerialNumberBits exceeds the CA/B Forum Baseline Requirements
+	// minimum of 64 bits of CSPRNG output while staying well under the RFC
+	// 5280 limit of 20 octets for the DER encoded serial number.
+	defaultSerialNumberBits = 128
+
+	maxUniqueSerialNumberAttempts = 5
+)
+
+// SerialNumberGenerator represents an entity capable of
+// generating serial numbers for x509 certificates.
+type SerialNumberGenerator interface {
+	SerialNumber() (*big.Int, error)
+}
+
+// randomSerialNumberGenerator is an internal-only crypto/rand
+// implementation of the SerialNumberGenerator interface.
+type randomSerialNumberGenerator struct {
+	bits int
+}
+
+// ensure randomSerialNumberGenerator implements SerialNumberGenerator.
+var _ SerialNumberGenerator = (*randomSerialNumberGenerator)(nil)
+
+// NewRandomSerialNumberGenerator returns a SerialNumberGenerator
+// which produces positive 128 bit serial numbers from crypto/rand.
+func NewRandomSerialNumberGenerator() SerialNumberGenerator {
+	return &randomSerialNumberGenerator{bits: defaultSerialNumberBits}
+}
+
+// SerialNumber generates a new serial number.
+func (g *randomSerialNumberGenerator) SerialNumber() (*big.Int, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(g.bits))
+	for {
+		serialNumber, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read random serial number: %v", err)
+		}
+		// serial numbers must be positive (RFC 5280 section 4.1.2.2)
+		if serialNumber.Sign() > 0 {
+			return serialNumber, nil
+		}
+	}
+}
+
+// uniqueSerialNumberGenerator is an internal-only implementation of the SerialNumberGenerator
+// interface which ensures serial numbers are not already in a certificate inventory.
+type uniqueSerialNumberGenerator struct {
+	generator    SerialNumberGenerator
+	certificates store.CertificateStore
+}
+
+// ensure uniqueSerialNumberGenerator implements SerialNumberGenerator.
+var _ SerialNumberGenerator = (*uniqueSerialNumberGenerator)(nil)
+
+// NewUniqueSerialNumberGenerator returns a SerialNumberGenerator which discards serial
+// numbers (from the given generator) that were already issued according to the store.
+func NewUniqueSerialNumberGenerator(
+	generator SerialNumberGenerator,
+	certificates store.CertificateStore,
+) SerialNumberGenerator {
+	return &uniqueSerialNumberGenerator{
+		generator:    generator,
+		certificates: certificates,
+	}
+}
+
+// SerialNumber generates a new serial number.
+func (g *uniqueSerialNumberGenerator) SerialNumber() (*big.Int, error) {
+	for attempt := 0; attempt < maxUniqueSerialNumberAttempts; attempt++ {
+		serialNumber, err := g.generator.SerialNumber()
+		if err != nil {
+			return nil, err
+		}
+		_, err = g.certificates.Get(serialNumber)
+		if errors.Is(err, store.ErrNotFound) {
+			return serialNumber, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check serial number uniqueness: %v", err)
+		}
+	}
+	return nil, fmt.Errorf("failed to generate a unique serial number after %d attempts", maxUniqueSerialNumberAttempts)
+}
@@ -0,0 +1,98 @@
+package template
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/adrianosela/ca/src/store"
+)
+
+// sequenceSerialNumberGenerator is an implementation of the SerialNumberGenerator
+// interface which returns the given serial numbers in order, then an error.
+type sequenceSerialNumberGenerator struct {
+	serialNumbers []int64
+}
+
+func (g *sequenceSerialNumberGenerator) SerialNumber() (*big.Int, error) {
+	if len(g.serialNumbers) == 0 {
+		return nil, errors.New("no more serial numbers")
+	}
+	serialNumber := big.NewInt(g.serialNumbers[0])
+	g.serialNumbers = g.serialNumbers[1:]
+	return serialNumber, nil
+}
+
+// failingCertificateStore is an implementation of the CertificateStore
+// interface whose lookups fail with other errors than store.ErrNotFound.
+type failingCertificateStore struct {
+	store.CertificateStore
+}
+
+func (s *failingCertificateStore) Get(*big.Int) (*store.Record, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestUniqueSerialNumberGenerator(t *testing.T) {
+	issued := store.NewMemoryStore()
+	for _, serialNumber := range []string{"1", "2", "3", "4", "5", "6"} {
+		if err := issued.Put(&store.Record{SerialNumber: serialNumber}); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		serialNumbers []int64
+		certificates  store.CertificateStore
+		// expected is the expected serial number, 0 if an error is expected
+		expected int64
+	}{
+		{name: "unique", serialNumbers: []int64{7}, certificates: issued, expected: 7},
+		{name: "retried after collisions", serialNumbers: []int64{1, 2, 7}, certificates: issued, expected: 7},
+		{name: "retried on the last attempt", serialNumbers: []int64{1, 2, 3, 4, 7}, certificates: issued, expected: 7},
+		{name: "too many collisions", serialNumbers: []int64{1, 2, 3, 4, 5, 7}, certificates: issued},
+		{name: "generator failure", serialNumbers: []int64{1}, certificates: issued},
+		{name: "store failure", serialNumbers: []int64{7}, certificates: &failingCertificateStore{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator := NewUniqueSerialNumberGenerator(
+				&sequenceSerialNumberGenerator{serialNumbers: test.serialNumbers},
+				test.certificates,
+			)
+			serialNumber, err := generator.SerialNumber()
+			if test.expected == 0 {
+				if err == nil {
+					t.Fatalf("expected an error, got serial number: %s", serialNumber)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if serialNumber.Int64() != test.expected {
+				t.Fatalf("expected serial number %d, got: %s", test.expected, serialNumber)
+			}
+		})
+	}
+}
+
+func TestRandomSerialNumberGenerator(t *testing.T) {
+	generator := NewRandomSerialNumberGenerator()
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		serialNumber, err := generator.SerialNumber()
+		if err != nil {
+			t.Fatalf("failed to generate serial number: %v", err)
+		}
+		// serial numbers must be positive and at most 20 octets (RFC 5280 section 4.1.2.2)
+		if serialNumber.Sign() <= 0 || len(serialNumber.Bytes()) > 20 {
+			t.Fatalf("expected a positive serial number of at most 20 octets, got: %s", serialNumber)
+		}
+		if seen[serialNumber.String()] {
+			t.Fatalf("expected unique serial numbers, got %s twice", serialNumber)
+		}
+		seen[serialNumber.String()] = true
+	}
+}
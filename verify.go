@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/adrianosela/ca/src/auditor"
+)
+
+// verify walks a hash-chained audit log written by a file auditor and
+// reports the first broken link, missing entry or invalid checkpoint.
+// When a public key is given, the log must also end with a checkpoint,
+// since the hash chain alone can be recomputed by anyone rewriting it.
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicKeyFile := flags.String("public-key", "", "path to the PEM public key (or certificate) checkpoints are signed with")
+	allowUnsignedTail := flags.Bool("allow-unsigned-tail", false, "accept entries after the last checkpoint (e.g. of a running service's log), which are not signed")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s verify [-public-key file [-allow-unsigned-tail]] <audit log file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var pub crypto.PublicKey
+	if *publicKeyFile != "" {
+		var err error
+		if pub, err = loadPublicKey(*publicKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load public key: %v\n", err)
+			return 2
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open audit log: %v\n", err)
+		return 2
+	}
+	defer file.Close()
+
+	report, err := auditor.VerifyFileLog(file, pub)
+	fmt.Printf("entries: %d (events: %d, checkpoints: %d)\n", report.Entries, report.Events, report.Checkpoints)
+	if err != nil {
+		fmt.Printf("audit log is NOT intact: %v\n", err)
+		return 1
+	}
+	if !report.SignaturesVerified {
+		fmt.Println("checkpoint signatures not verified (no -public-key given)")
+	} else if report.Checkpoints == 0 {
+		fmt.Println("audit log is NOT verified: no signed checkpoints")
+		return 1
+	}
+	if unsigned := report.Entries - report.LastCheckpointLine; unsigned > 0 {
+		fmt.Printf("%d entries after the last checkpoint\n", unsigned)
+		if report.SignaturesVerified && !*allowUnsignedTail {
+			fmt.Println("audit log is NOT verified: entries after the last checkpoint are not signed (see -allow-unsigned-tail)")
+			return 1
+		}
+	}
+	fmt.Println("audit log OK")
+	return 0
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}